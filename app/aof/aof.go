@@ -0,0 +1,462 @@
+// Package aof implements the append-only file: every write command is
+// logged in RESP form so the dataset can be rebuilt by replaying it. Like
+// Redis 7 the log is split into a base file holding a snapshot and
+// incremental files of commands, listed by a manifest.
+package aof
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/crypt"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// FsyncPolicy controls when appended commands are flushed to disk
+type FsyncPolicy int
+
+const (
+	// FsyncAlways syncs after every command
+	FsyncAlways FsyncPolicy = iota
+	// FsyncEverySec syncs once per second from a background goroutine
+	FsyncEverySec
+	// FsyncNo leaves flushing to the operating system
+	FsyncNo
+)
+
+// ParseFsyncPolicy parses an appendfsync value
+func ParseFsyncPolicy(s string) (FsyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "always":
+		return FsyncAlways, nil
+	case "everysec":
+		return FsyncEverySec, nil
+	case "no":
+		return FsyncNo, nil
+	}
+	return 0, fmt.Errorf("invalid appendfsync value: %s", s)
+}
+
+func (p FsyncPolicy) String() string {
+	switch p {
+	case FsyncAlways:
+		return "always"
+	case FsyncNo:
+		return "no"
+	}
+	return "everysec"
+}
+
+// EncodeCommand encodes a command as a RESP array of bulk strings
+func EncodeCommand(name string, args []string) []byte {
+	return []byte(parser.ToBulkStringArray(append([]string{name}, args...)))
+}
+
+// File is a multi-part append-only file open for writing. Commands are
+// appended to the last incremental file listed in the manifest. With an
+// encryption key every command is sealed in its own chunk, so a crash can
+// only cut the file short between commands or inside the last one.
+type File struct {
+	mu       sync.Mutex
+	dir      string
+	name     string
+	manifest *Manifest
+	file     *os.File      // Last incremental file
+	enc      *crypt.Writer // Encrypts to file, nil without a key
+	key      []byte
+	policy   FsyncPolicy
+	unsynced bool
+
+	// Some files of the log were written with another encryption setting
+	needsRewrite bool
+
+	// Sequence number of the first incremental file opened by the running
+	// rewrite, 0 when no rewrite runs
+	rewriteIncr int64
+
+	done chan struct{}
+}
+
+// Exists reports whether dir holds the manifest of the log called name
+func Exists(dir, name string) bool {
+	_, err := os.Stat(filepath.Join(dir, ManifestName(name)))
+	return err == nil
+}
+
+// Create writes snapshot as a new base file in dir followed by an empty
+// incremental file, and points the manifest at them. Files of a previous
+// log in dir are deleted once the manifest has been switched. The base file
+// is encrypted when key is not nil.
+func Create(dir, name string, snapshot map[string]storage.KeyValue, key []byte) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	old := &Manifest{}
+	if Exists(dir, name) {
+		m, err := ReadManifest(filepath.Join(dir, ManifestName(name)))
+		if err != nil {
+			return err
+		}
+		old = m
+	}
+
+	tmp, err := WriteBase(dir, snapshot, key)
+	if err != nil {
+		return err
+	}
+	base := ManifestFile{Name: baseFileName(name, old.nextBaseSeq(), true), Seq: old.nextBaseSeq(), Type: TypeBase}
+	if err := os.Rename(tmp, filepath.Join(dir, base.Name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	incr := ManifestFile{Name: incrFileName(name, old.nextIncrSeq()), Seq: old.nextIncrSeq(), Type: TypeIncr}
+	file, err := os.OpenFile(filepath.Join(dir, incr.Name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	file.Close()
+
+	m := &Manifest{Base: &base, Incr: []ManifestFile{incr}, History: retired(old, 0)}
+	if err := writeManifest(dir, name, m); err != nil {
+		return err
+	}
+	removeHistory(dir, name, m)
+	return nil
+}
+
+// Upgrade moves a single-file append-only file written by an older version
+// into dir as the base of a multi-part log
+func Upgrade(legacyPath, dir, name string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	base := ManifestFile{Name: baseFileName(name, 1, false), Seq: 1, Type: TypeBase}
+	if err := os.Rename(legacyPath, filepath.Join(dir, base.Name)); err != nil {
+		return err
+	}
+	return writeManifest(dir, name, &Manifest{Base: &base})
+}
+
+// retired returns the files of m made obsolete by a rewrite whose first
+// incremental file has sequence number keepFrom, or all of them if 0
+func retired(m *Manifest, keepFrom int64) []ManifestFile {
+	history := append([]ManifestFile(nil), m.History...)
+	if m.Base != nil {
+		history = append(history, ManifestFile{Name: m.Base.Name, Seq: m.Base.Seq, Type: TypeHistory})
+	}
+	for _, f := range m.Incr {
+		if keepFrom == 0 || f.Seq < keepFrom {
+			history = append(history, ManifestFile{Name: f.Name, Seq: f.Seq, Type: TypeHistory})
+		}
+	}
+	return history
+}
+
+// Open opens the log described by the manifest in dir for appending. An
+// incremental file is created if the manifest has none, or if the last one
+// was written with another encryption setting than key: each file is
+// either fully encrypted or not at all.
+func Open(dir, name string, policy FsyncPolicy, key []byte) (*File, error) {
+	m, err := ReadManifest(filepath.Join(dir, ManifestName(name)))
+	if err != nil {
+		return nil, err
+	}
+	removeHistory(dir, name, m)
+
+	var file *os.File
+	var enc *crypt.Writer
+	if len(m.Incr) > 0 {
+		file, enc, err = openIncr(filepath.Join(dir, m.Incr[len(m.Incr)-1].Name), key)
+		if err != nil && !errors.Is(err, errEncryptionChanged) {
+			return nil, err
+		}
+	}
+	if file == nil {
+		incr := ManifestFile{Name: incrFileName(name, m.nextIncrSeq()), Seq: m.nextIncrSeq(), Type: TypeIncr}
+		if file, enc, err = openIncr(filepath.Join(dir, incr.Name), key); err != nil {
+			return nil, err
+		}
+		m.Incr = append(m.Incr, incr)
+		if err := writeManifest(dir, name, m); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	f := &File{
+		dir:      dir,
+		name:     name,
+		manifest: m,
+		file:     file,
+		enc:      enc,
+		key:      key,
+		policy:   policy,
+		done:     make(chan struct{}),
+	}
+	for _, mf := range m.Files() {
+		if !matchesKey(filepath.Join(dir, mf.Name), key) {
+			f.needsRewrite = true
+		}
+	}
+	go f.syncLoop()
+	return f, nil
+}
+
+// errEncryptionChanged is returned by openIncr for a file written with
+// another encryption setting
+var errEncryptionChanged = errors.New("the file was written with another encryption setting")
+
+// openIncr opens the incremental file at path for appending, creating it
+// if needed. New files written with a key start with the encryption header.
+func openIncr(path string, key []byte) (*os.File, *crypt.Writer, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	var enc *crypt.Writer
+	if info.Size() == 0 {
+		if key != nil {
+			enc, err = crypt.NewWriter(file, key)
+		}
+	} else {
+		var encrypted bool
+		encrypted, err = crypt.IsEncrypted(file)
+		if err == nil && encrypted != (key != nil) {
+			err = errEncryptionChanged
+		}
+		if err == nil && encrypted {
+			enc, err = crypt.Resume(file, key)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, enc, nil
+}
+
+// matchesKey reports whether the file at path is encrypted if and only if
+// key is set. Empty files match either way.
+func matchesKey(path string, key []byte) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return true
+	}
+	defer file.Close()
+	if info, err := file.Stat(); err != nil || info.Size() == 0 {
+		return true
+	}
+	encrypted, err := crypt.IsEncrypted(file)
+	return err != nil || encrypted == (key != nil)
+}
+
+// NeedsRewrite reports whether files of the log were written with another
+// encryption setting, which only a rewrite changes
+func (f *File) NeedsRewrite() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.needsRewrite
+}
+
+// Dir returns the directory holding the files of the log
+func (f *File) Dir() string {
+	return f.dir
+}
+
+// Append logs a command. The data is written to the file right away and
+// synced according to the fsync policy.
+func (f *File) Append(name string, args []string) error {
+	data := EncodeCommand(name, args)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.enc != nil {
+		if _, err := f.enc.Write(data); err != nil {
+			return err
+		}
+		if err := f.enc.Flush(); err != nil {
+			return err
+		}
+	} else if _, err := f.file.Write(data); err != nil {
+		return err
+	}
+	if f.policy == FsyncAlways {
+		return f.file.Sync()
+	}
+	f.unsynced = true
+	return nil
+}
+
+// SetPolicy changes the fsync policy
+func (f *File) SetPolicy(policy FsyncPolicy) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.policy = policy
+}
+
+// syncLoop flushes the file once per second with the everysec policy
+func (f *File) syncLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+		}
+
+		f.mu.Lock()
+		if f.policy == FsyncEverySec && f.unsynced {
+			if err := f.file.Sync(); err != nil {
+				fmt.Printf("Failed to fsync the append only file: %v\n", err)
+			} else {
+				f.unsynced = false
+			}
+		}
+		f.mu.Unlock()
+	}
+}
+
+// StartRewrite switches appends to a new incremental file, recorded in the
+// manifest right away. The caller must hold the execution lock and take the
+// snapshot for the new base under it, so the new incremental file starts
+// exactly where the snapshot ends.
+func (f *File) StartRewrite() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := f.manifest.clone()
+	incr := ManifestFile{Name: incrFileName(f.name, m.nextIncrSeq()), Seq: m.nextIncrSeq(), Type: TypeIncr}
+	os.Remove(filepath.Join(f.dir, incr.Name))
+	file, enc, err := openIncr(filepath.Join(f.dir, incr.Name), f.key)
+	if err != nil {
+		return err
+	}
+	m.Incr = append(m.Incr, incr)
+	if err := writeManifest(f.dir, f.name, m); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+
+	// Commands logged so far stay in the previous file and must be durable
+	// before anything is written after them
+	if err := f.file.Sync(); err != nil {
+		fmt.Printf("Failed to fsync the append only file: %v\n", err)
+	}
+	f.file.Close()
+	f.file = file
+	f.enc = enc
+	f.unsynced = false
+	f.manifest = m
+	f.rewriteIncr = incr.Seq
+	return nil
+}
+
+// FinishRewrite installs the base file written at tmpPath. The manifest is
+// switched to it and the incremental files opened since StartRewrite in a
+// single atomic rename; the files it replaces are deleted afterwards.
+func (f *File) FinishRewrite(tmpPath string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keepFrom := f.rewriteIncr
+	f.rewriteIncr = 0
+
+	m := f.manifest.clone()
+	base := ManifestFile{Name: baseFileName(f.name, m.nextBaseSeq(), true), Seq: m.nextBaseSeq(), Type: TypeBase}
+	if err := os.Rename(tmpPath, filepath.Join(f.dir, base.Name)); err != nil {
+		return err
+	}
+
+	next := &Manifest{Base: &base, History: retired(m, keepFrom)}
+	for _, incr := range m.Incr {
+		if incr.Seq >= keepFrom {
+			next.Incr = append(next.Incr, incr)
+		}
+	}
+	if err := writeManifest(f.dir, f.name, next); err != nil {
+		os.Remove(filepath.Join(f.dir, base.Name))
+		return err
+	}
+	f.manifest = next
+	f.needsRewrite = false
+	removeHistory(f.dir, f.name, next)
+	return nil
+}
+
+// AbortRewrite forgets a failed rewrite. The incremental file it opened
+// stays listed in the manifest, so no command is lost.
+func (f *File) AbortRewrite() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rewriteIncr = 0
+}
+
+// Close syncs and closes the file
+func (f *File) Close() error {
+	close(f.done)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.file.Sync(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// WriteBase writes snapshot in RDB format to a temporary file in dir and
+// returns its path, for use as a base file. The file is encrypted when key
+// is not nil.
+func WriteBase(dir string, snapshot map[string]storage.KeyValue, key []byte) (string, error) {
+	tmp, err := os.CreateTemp(dir, fmt.Sprintf("temp-rewriteaof-bg-%d-*.rdb", os.Getpid()))
+	if err != nil {
+		return "", err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := rdb.WriteFileSnapshot(tmp, snapshot, nil, key); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// syncDir flushes a directory so a rename in it survives a crash
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,87 @@
+package aof
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/codecrafters-io/redis-starter-go/app/crypt"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+)
+
+// CheckStats summarizes a verified file
+type CheckStats struct {
+	Keys     int   // Keys of the RDB preamble
+	Commands int   // Complete commands
+	Size     int64 // Size of the file
+}
+
+// CheckFile reads a file of an append-only log without loading it. Only
+// base files may start with an RDB preamble. Problems in the preamble are
+// reported as an *rdb.Error, problems with commands as an *Error: truncating
+// the file at its Offset leaves only complete commands. An encrypted file is
+// decrypted with key; the Offset of an ErrTruncated error is then an offset
+// in the file, other offsets are offsets in the decrypted data.
+func CheckFile(path string, base bool, key []byte) (CheckStats, error) {
+	var stats CheckStats
+	file, err := crypt.Open(path, key)
+	if err != nil {
+		return stats, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return stats, err
+	}
+	stats.Size = info.Size()
+
+	var offset int64
+	magic := make([]byte, 5)
+	n, err := io.ReadFull(file, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return stats, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return stats, err
+	}
+	if n == len(magic) && string(magic) == "REDIS" {
+		if !base {
+			return stats, fmt.Errorf("RDB preamble found in a file that is not a base file")
+		}
+		decoder := rdb.NewDecoder(file)
+		for {
+			_, err := decoder.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return stats, err
+			}
+			stats.Keys++
+		}
+		offset = decoder.Offset()
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return stats, err
+		}
+	}
+
+	reader := NewReader(file, offset)
+	for {
+		_, err := reader.Next()
+		if err == io.EOF {
+			return stats, nil
+		}
+		var aofErr *Error
+		if errors.Is(err, ErrTruncated) && errors.As(err, &aofErr) {
+			if aofErr.Offset, err = file.EncryptedOffset(aofErr.Offset); err != nil {
+				return stats, fmt.Errorf("cannot locate the truncated command in the encrypted file: %w", err)
+			}
+			return stats, aofErr
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Commands++
+	}
+}
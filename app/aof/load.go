@@ -0,0 +1,123 @@
+package aof
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/codecrafters-io/redis-starter-go/app/crypt"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/repository"
+)
+
+// LoadStats summarizes the replay of an append-only file
+type LoadStats struct {
+	Files     int  // Files loaded
+	Keys      int  // Keys loaded from the base file's RDB data
+	Commands  int  // Commands replayed
+	Truncated bool // An incomplete command at the end was removed
+}
+
+// Load rebuilds the dataset from the multi-part log called name in dir: the
+// base file listed in the manifest is loaded, replacing the content of repo
+// when it holds RDB data, then the commands of the base and incremental
+// files are passed to apply in order. A command cut short at the end of the
+// last file is an error unless allowTruncated is set, in which case the
+// file is truncated to its last complete command like Redis'
+// aof-load-truncated. Encrypted files are decrypted with key.
+func Load(dir, name string, repo repository.KeyValueRepository, apply func(args []string), allowTruncated bool, key []byte) (LoadStats, error) {
+	var stats LoadStats
+	m, err := ReadManifest(filepath.Join(dir, ManifestName(name)))
+	if err != nil {
+		return stats, err
+	}
+
+	files := m.Files()
+	for i, f := range files {
+		last := i == len(files)-1
+		if err := loadFile(filepath.Join(dir, f.Name), f.Type == TypeBase, repo, apply, allowTruncated && last, key, &stats); err != nil {
+			return stats, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		stats.Files++
+	}
+	return stats, nil
+}
+
+// loadFile replays one file of the log. Only a base file may start with
+// RDB data.
+func loadFile(path string, base bool, repo repository.KeyValueRepository, apply func(args []string), allowTruncated bool, key []byte, stats *LoadStats) error {
+	file, err := crypt.Open(path, key)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var offset int64
+	if base {
+		var keys int
+		offset, keys, err = loadPreamble(file, repo)
+		if err != nil {
+			return err
+		}
+		stats.Keys += keys
+	}
+
+	reader := NewReader(file, offset)
+	for {
+		args, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, ErrTruncated) && allowTruncated {
+			// In an encrypted file the command starts a chunk
+			offset, err := file.EncryptedOffset(reader.Offset())
+			if err != nil {
+				return fmt.Errorf("cannot truncate the encrypted file: %w", err)
+			}
+			fmt.Printf("!!! Warning: short read while loading the AOF file %s, truncating it at offset %d !!!\n", path, offset)
+			if err := os.Truncate(path, offset); err != nil {
+				return err
+			}
+			stats.Truncated = true
+			return nil
+		}
+		if errors.Is(err, ErrTruncated) {
+			return fmt.Errorf("%w (set aof-load-truncated to yes to load it anyway)", err)
+		}
+		if err != nil {
+			return err
+		}
+
+		apply(args)
+		stats.Commands++
+	}
+}
+
+// loadPreamble loads the RDB data at the start of file, if any, and
+// positions file on the first command. Returns the offset of that command.
+func loadPreamble(file io.ReadSeeker, repo repository.KeyValueRepository) (int64, int, error) {
+	magic := make([]byte, 5)
+	n, err := io.ReadFull(file, magic)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	if n < len(magic) || string(magic) != "REDIS" {
+		return 0, 0, nil
+	}
+
+	snapshot, stats, err := rdb.ReadSnapshot(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("loading the RDB preamble: %w", err)
+	}
+	repo.Replace(snapshot)
+
+	if _, err := file.Seek(stats.Bytes, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	return stats.Bytes, stats.Loaded, nil
+}
@@ -0,0 +1,237 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FileType is the role of a file listed in the manifest
+type FileType byte
+
+const (
+	// TypeBase is the snapshot the log starts from, in RDB or AOF format
+	TypeBase FileType = 'b'
+	// TypeIncr is a file of commands logged after the base
+	TypeIncr FileType = 'i'
+	// TypeHistory is a file replaced by a rewrite, waiting to be deleted
+	TypeHistory FileType = 'h'
+)
+
+// ManifestFile is an entry of the manifest
+type ManifestFile struct {
+	Name string
+	Seq  int64
+	Type FileType
+}
+
+// Manifest lists the files making up a multi-part append-only file, in the
+// same format as Redis 7:
+//
+//	file appendonly.aof.2.base.rdb seq 2 type b
+//	file appendonly.aof.3.incr.aof seq 3 type i
+//
+// The dataset is rebuilt by loading the base and then every incremental
+// file in order.
+type Manifest struct {
+	Base    *ManifestFile
+	Incr    []ManifestFile
+	History []ManifestFile
+}
+
+// ManifestName returns the name of the manifest of the log called name
+func ManifestName(name string) string {
+	return name + ".manifest"
+}
+
+// baseFileName returns the name of a base file, in RDB format unless the
+// base was upgraded from a plain append-only file
+func baseFileName(name string, seq int64, rdbFormat bool) string {
+	if rdbFormat {
+		return fmt.Sprintf("%s.%d.base.rdb", name, seq)
+	}
+	return fmt.Sprintf("%s.%d.base.aof", name, seq)
+}
+
+// incrFileName returns the name of an incremental file
+func incrFileName(name string, seq int64) string {
+	return fmt.Sprintf("%s.%d.incr.aof", name, seq)
+}
+
+// ReadManifest reads and validates the manifest at path
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("invalid manifest line %d: %q", lineNo, line)
+		}
+		var entry ManifestFile
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				entry.Name = fields[i+1]
+			case "seq":
+				seq, err := strconv.ParseInt(fields[i+1], 10, 64)
+				if err != nil || seq < 1 {
+					return nil, fmt.Errorf("invalid seq on manifest line %d: %q", lineNo, fields[i+1])
+				}
+				entry.Seq = seq
+			case "type":
+				if len(fields[i+1]) != 1 {
+					return nil, fmt.Errorf("invalid type on manifest line %d: %q", lineNo, fields[i+1])
+				}
+				entry.Type = FileType(fields[i+1][0])
+			}
+			// Unknown keys are ignored so newer manifests can still be read
+		}
+		if entry.Name == "" || entry.Seq == 0 || strings.ContainsAny(entry.Name, "/\\") {
+			return nil, fmt.Errorf("invalid manifest line %d: %q", lineNo, line)
+		}
+
+		switch entry.Type {
+		case TypeBase:
+			if m.Base != nil {
+				return nil, fmt.Errorf("manifest line %d: found duplicate base file", lineNo)
+			}
+			m.Base = &entry
+		case TypeIncr:
+			if n := len(m.Incr); n > 0 && entry.Seq <= m.Incr[n-1].Seq {
+				return nil, fmt.Errorf("manifest line %d: incr files out of order", lineNo)
+			}
+			m.Incr = append(m.Incr, entry)
+		case TypeHistory:
+			m.History = append(m.History, entry)
+		default:
+			return nil, fmt.Errorf("invalid type on manifest line %d: %q", lineNo, string(entry.Type))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if m.Base == nil && len(m.Incr) == 0 {
+		return nil, fmt.Errorf("manifest %s lists no files", path)
+	}
+	return m, nil
+}
+
+// Encode formats the manifest
+func (m *Manifest) Encode() []byte {
+	var buf bytes.Buffer
+	write := func(f ManifestFile) {
+		fmt.Fprintf(&buf, "file %s seq %d type %c\n", f.Name, f.Seq, f.Type)
+	}
+	if m.Base != nil {
+		write(*m.Base)
+	}
+	for _, f := range m.History {
+		write(f)
+	}
+	for _, f := range m.Incr {
+		write(f)
+	}
+	return buf.Bytes()
+}
+
+// Files returns the files to load in order: the base, then the increments
+func (m *Manifest) Files() []ManifestFile {
+	var files []ManifestFile
+	if m.Base != nil {
+		files = append(files, *m.Base)
+	}
+	return append(files, m.Incr...)
+}
+
+// nextBaseSeq returns the sequence number of the next base file
+func (m *Manifest) nextBaseSeq() int64 {
+	if m.Base == nil {
+		return 1
+	}
+	return m.Base.Seq + 1
+}
+
+// nextIncrSeq returns the sequence number of the next incremental file
+func (m *Manifest) nextIncrSeq() int64 {
+	if len(m.Incr) == 0 {
+		return 1
+	}
+	return m.Incr[len(m.Incr)-1].Seq + 1
+}
+
+// clone returns a copy of the manifest that can be modified freely
+func (m *Manifest) clone() *Manifest {
+	c := &Manifest{
+		Incr:    append([]ManifestFile(nil), m.Incr...),
+		History: append([]ManifestFile(nil), m.History...),
+	}
+	if m.Base != nil {
+		base := *m.Base
+		c.Base = &base
+	}
+	return c
+}
+
+// writeManifest atomically replaces the manifest of the log called name
+func writeManifest(dir, name string, m *Manifest) error {
+	tmp, err := os.CreateTemp(dir, "temp-"+ManifestName(name)+"-*")
+	if err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := tmp.Write(m.Encode()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, ManifestName(name))); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// removeHistory deletes the files replaced by a rewrite and drops them
+// from the manifest once the deletion is recorded
+func removeHistory(dir, name string, m *Manifest) {
+	if len(m.History) == 0 {
+		return
+	}
+	for _, f := range m.History {
+		if err := os.Remove(filepath.Join(dir, f.Name)); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Failed to remove the history file %s: %v\n", f.Name, err)
+		}
+	}
+	m.History = nil
+	if err := writeManifest(dir, name, m); err != nil {
+		fmt.Printf("Failed to update the AOF manifest: %v\n", err)
+	}
+}
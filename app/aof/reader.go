@@ -0,0 +1,130 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+var (
+	// ErrTruncated is returned when the file ends in the middle of a command
+	ErrTruncated = errors.New("unexpected end of file")
+
+	// ErrFormat is returned when the content is not a sequence of commands
+	ErrFormat = errors.New("bad file format")
+)
+
+// maxBulkLen is the largest argument accepted, like Redis' proto-max-bulk-len
+const maxBulkLen = 512 * 1024 * 1024
+
+// Error is a reading error with the offset of the command that could not
+// be read. Truncating the file at Offset leaves only complete commands.
+type Error struct {
+	Offset int64
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v reading the append only file at offset %d", e.Err, e.Offset)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads the commands of an append-only file
+type Reader struct {
+	r      *bufio.Reader
+	offset int64 // Offset of the next command
+	read   int64 // Bytes consumed so far
+}
+
+// NewReader creates a reader of commands starting at offset base of the file
+func NewReader(r io.Reader, base int64) *Reader {
+	return &Reader{
+		r:      bufio.NewReaderSize(r, 64*1024),
+		offset: base,
+		read:   base,
+	}
+}
+
+// Offset returns the offset following the last complete command
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// Next returns the arguments of the next command, command name first. It
+// returns io.EOF at the end of the file.
+func (r *Reader) Next() ([]string, error) {
+	if _, err := r.r.Peek(1); err == io.EOF {
+		return nil, io.EOF
+	}
+
+	count, err := r.readHeader('*')
+	if err != nil {
+		return nil, err
+	}
+	if count < 1 {
+		return nil, r.fail(ErrFormat)
+	}
+
+	args := make([]string, 0, min(count, 1024))
+	for i := 0; i < count; i++ {
+		length, err := r.readHeader('$')
+		if err != nil {
+			return nil, err
+		}
+		if length < 0 || length > maxBulkLen {
+			return nil, r.fail(ErrFormat)
+		}
+		buf := make([]byte, length+2)
+		n, err := io.ReadFull(r.r, buf)
+		r.read += int64(n)
+		if err != nil {
+			return nil, r.fail(readError(err))
+		}
+		if buf[length] != '\r' || buf[length+1] != '\n' {
+			return nil, r.fail(ErrFormat)
+		}
+		args = append(args, string(buf[:length]))
+	}
+
+	r.offset = r.read
+	return args, nil
+}
+
+// readHeader reads a "<prefix><number>\r\n" line
+func (r *Reader) readHeader(prefix byte) (int, error) {
+	line, err := r.r.ReadSlice('\n')
+	r.read += int64(len(line))
+	if err == bufio.ErrBufferFull {
+		return 0, r.fail(ErrFormat)
+	}
+	if err != nil {
+		return 0, r.fail(readError(err))
+	}
+	if len(line) < 4 || line[0] != prefix || line[len(line)-2] != '\r' {
+		return 0, r.fail(ErrFormat)
+	}
+	n, err := strconv.Atoi(string(line[1 : len(line)-2]))
+	if err != nil {
+		return 0, r.fail(ErrFormat)
+	}
+	return n, nil
+}
+
+// readError classifies an error of the underlying reader: the end of the
+// data means the file was cut short, anything else (such as a chunk of an
+// encrypted file failing authentication) is reported as is
+func readError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	return err
+}
+
+func (r *Reader) fail(err error) error {
+	return &Error{Offset: r.offset, Err: err}
+}
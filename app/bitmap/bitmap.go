@@ -0,0 +1,199 @@
+// Package bitmap implements the bit level operations on string values used
+// by SETBIT, GETBIT, BITCOUNT, BITPOS, BITOP and BITFIELD.
+package bitmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// MaxBits is the number of addressable bits in a string (512MB)
+const MaxBits = 512 * 1024 * 1024 * 8
+
+// ErrBitOffset is returned for offsets that are not valid bit positions
+var ErrBitOffset = errors.New("ERR bit offset is not an integer or out of range")
+
+// Grow returns buf extended with zero bytes so it holds at least size bytes.
+// Capacity grows greedily like Redis' sds strings to amortize repeated
+// appends at the end of the bitmap.
+func Grow(buf []byte, size int) []byte {
+	if size <= len(buf) {
+		return buf
+	}
+	if size <= cap(buf) {
+		old := len(buf)
+		buf = buf[:size]
+		clear(buf[old:])
+		return buf
+	}
+
+	newCap := size * 2
+	if size >= 1024*1024 {
+		newCap = size + 1024*1024
+	}
+	grown := make([]byte, size, newCap)
+	copy(grown, buf)
+	return grown
+}
+
+// GetBit returns the bit at offset, bits past the end of buf are zero
+func GetBit(buf []byte, offset uint64) int {
+	byteIndex := offset >> 3
+	if byteIndex >= uint64(len(buf)) {
+		return 0
+	}
+	return int(buf[byteIndex]>>(7-offset&7)) & 1
+}
+
+// SetBit sets the bit at offset to value, growing buf as needed. It returns
+// the updated buffer and the previous value of the bit.
+func SetBit(buf []byte, offset uint64, value int) ([]byte, int) {
+	buf = Grow(buf, int(offset>>3)+1)
+	byteIndex := offset >> 3
+	mask := byte(1) << (7 - offset&7)
+	old := 0
+	if buf[byteIndex]&mask != 0 {
+		old = 1
+	}
+	if value != 0 {
+		buf[byteIndex] |= mask
+	} else {
+		buf[byteIndex] &^= mask
+	}
+	return buf, old
+}
+
+// normalizeRange converts a possibly negative inclusive [start, end] range
+// over length units into absolute indexes. ok is false for empty ranges.
+func normalizeRange(start, end, length int64) (int64, int64, bool) {
+	if start < 0 {
+		start += length
+	}
+	if end < 0 {
+		end += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= length {
+		end = length - 1
+	}
+	return start, end, start <= end
+}
+
+// rangeMasks converts an absolute bit range into byte indexes plus masks
+// selecting the bits of the first and last byte that fall outside the range
+func rangeMasks(start, end int64) (int64, int64, byte, byte) {
+	firstMask := ^byte(0xff >> (start & 7))
+	lastMask := byte(0xff >> (end&7 + 1))
+	return start >> 3, end >> 3, firstMask, lastMask
+}
+
+// Count returns the number of set bits in buf
+func Count(buf []byte) int64 {
+	var count int
+	for len(buf) >= 8 {
+		count += bits.OnesCount64(binary.LittleEndian.Uint64(buf))
+		buf = buf[8:]
+	}
+	for _, b := range buf {
+		count += bits.OnesCount8(b)
+	}
+	return int64(count)
+}
+
+// CountRange returns the number of set bits between start and end
+// (inclusive, negative values count from the end). The range is in bytes
+// unless bitUnit is set.
+func CountRange(buf []byte, start, end int64, bitUnit bool) int64 {
+	length := int64(len(buf))
+	if bitUnit {
+		length *= 8
+	}
+	start, end, ok := normalizeRange(start, end, length)
+	if !ok {
+		return 0
+	}
+	if !bitUnit {
+		return Count(buf[start : end+1])
+	}
+
+	startByte, endByte, firstMask, lastMask := rangeMasks(start, end)
+	count := Count(buf[startByte : endByte+1])
+	count -= int64(bits.OnesCount8(buf[startByte] & firstMask))
+	count -= int64(bits.OnesCount8(buf[endByte] & lastMask))
+	return count
+}
+
+// firstBit returns the position of the first bit set to bit in buf. When
+// looking for a clear bit and none is found, the position right after buf
+// is returned as if the string was padded with zeros; -1 is returned when
+// looking for a set bit that does not exist.
+func firstBit(buf []byte, bit int) int64 {
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for i, b := range buf {
+		if b == skip {
+			continue
+		}
+		if bit == 0 {
+			b = ^b
+		}
+		return int64(i)*8 + int64(bits.LeadingZeros8(b))
+	}
+	if bit == 0 {
+		return int64(len(buf)) * 8
+	}
+	return -1
+}
+
+// Pos returns the position of the first bit set to bit, searching between
+// start and end like BITPOS. When endGiven is false a search for a clear
+// bit may return the first position past the end of the string.
+func Pos(buf []byte, bit int, start, end int64, endGiven, bitUnit bool) int64 {
+	length := int64(len(buf))
+	if bitUnit {
+		length *= 8
+	}
+	start, end, ok := normalizeRange(start, end, length)
+	if !ok {
+		return -1
+	}
+
+	var firstMask, lastMask byte
+	if bitUnit {
+		start, end, firstMask, lastMask = rangeMasks(start, end)
+	}
+	span := buf[start : end+1]
+
+	if firstMask != 0 || lastMask != 0 {
+		// Force the bits outside of the range to the value we are not
+		// looking for so they are never reported
+		span = append([]byte(nil), span...)
+		last := len(span) - 1
+		if bit == 1 {
+			span[0] &^= firstMask
+			span[last] &^= lastMask
+		} else {
+			span[0] |= firstMask
+			span[last] |= lastMask
+		}
+	}
+
+	pos := firstBit(span, bit)
+	// With an explicit end the bits past the range are not zero padding,
+	// so not finding a clear bit means there is none
+	if endGiven && bit == 0 && pos == int64(len(span))*8 {
+		return -1
+	}
+	if pos != -1 {
+		pos += start * 8
+	}
+	return pos
+}
@@ -0,0 +1,182 @@
+package bitmap
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Overflow is the BITFIELD overflow behavior for SET and INCRBY
+type Overflow int
+
+const (
+	OverflowWrap Overflow = iota
+	OverflowSat           // saturate at the minimum or maximum value
+	OverflowFail          // leave the field untouched and reply with a null
+)
+
+// ParseOverflow parses a BITFIELD OVERFLOW argument
+func ParseOverflow(name string) (Overflow, error) {
+	switch strings.ToUpper(name) {
+	case "WRAP":
+		return OverflowWrap, nil
+	case "SAT":
+		return OverflowSat, nil
+	case "FAIL":
+		return OverflowFail, nil
+	}
+	return 0, fmt.Errorf("ERR Invalid OVERFLOW type specified")
+}
+
+// FieldType is a BITFIELD integer encoding such as i8 or u16
+type FieldType struct {
+	Signed bool
+	Bits   uint64
+}
+
+// ParseFieldType parses a BITFIELD type: i1 to i64 or u1 to u63
+func ParseFieldType(s string) (FieldType, error) {
+	err := fmt.Errorf("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u') {
+		return FieldType{}, err
+	}
+	width, parseErr := strconv.ParseUint(s[1:], 10, 64)
+	signed := s[0] == 'i'
+	if parseErr != nil || width < 1 || (signed && width > 64) || (!signed && width > 63) {
+		return FieldType{}, err
+	}
+	return FieldType{Signed: signed, Bits: width}, nil
+}
+
+// ParseFieldOffset parses a BITFIELD offset. Offsets prefixed with '#' are
+// multiplied by the width of the field.
+func ParseFieldOffset(s string, ft FieldType) (uint64, error) {
+	multiply := strings.HasPrefix(s, "#")
+	if multiply {
+		s = s[1:]
+	}
+	offset, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, ErrBitOffset
+	}
+	if multiply {
+		if offset > MaxBits/int64(ft.Bits) {
+			return 0, ErrBitOffset
+		}
+		offset *= int64(ft.Bits)
+	}
+	if offset+int64(ft.Bits) > MaxBits {
+		return 0, ErrBitOffset
+	}
+	return uint64(offset), nil
+}
+
+// getUnsigned reads width bits at offset as an unsigned integer
+func getUnsigned(buf []byte, offset, width uint64) uint64 {
+	var value uint64
+	for i := uint64(0); i < width; i++ {
+		value = value<<1 | uint64(GetBit(buf, offset+i))
+	}
+	return value
+}
+
+// setUnsigned writes the low width bits of value at offset, buf must
+// already be large enough
+func setUnsigned(buf []byte, offset, width, value uint64) {
+	for i := uint64(0); i < width; i++ {
+		pos := offset + i
+		mask := byte(1) << (7 - pos&7)
+		if value>>(width-1-i)&1 != 0 {
+			buf[pos>>3] |= mask
+		} else {
+			buf[pos>>3] &^= mask
+		}
+	}
+}
+
+// Get reads the field at offset. Unsigned values always fit in an int64
+// since u64 is not supported.
+func (ft FieldType) Get(buf []byte, offset uint64) int64 {
+	value := getUnsigned(buf, offset, ft.Bits)
+	if ft.Signed && ft.Bits < 64 && value&(1<<(ft.Bits-1)) != 0 {
+		value |= math.MaxUint64 << ft.Bits
+	}
+	return int64(value)
+}
+
+// Set writes value into the field at offset, growing buf as needed
+func (ft FieldType) Set(buf []byte, offset uint64, value int64) []byte {
+	buf = Grow(buf, int((offset+ft.Bits-1)>>3)+1)
+	setUnsigned(buf, offset, ft.Bits, uint64(value))
+	return buf
+}
+
+// CheckOverflow reports whether value+incr overflows the field. When it
+// does, the returned value is the result to store according to the
+// overflow behavior (meaningless for OverflowFail).
+func (ft FieldType) CheckOverflow(value, incr int64, overflow Overflow) (int64, bool) {
+	if ft.Signed {
+		return ft.checkSigned(value, incr, overflow)
+	}
+	return ft.checkUnsigned(uint64(value), incr, overflow)
+}
+
+func (ft FieldType) checkUnsigned(value uint64, incr int64, overflow Overflow) (int64, bool) {
+	limit := uint64(1)<<ft.Bits - 1
+	maxIncr := int64(limit - value)
+	minIncr := -int64(value)
+
+	var saturated uint64
+	switch {
+	case value > limit || (incr > 0 && incr > maxIncr):
+		saturated = limit
+	case incr < 0 && incr < minIncr:
+		saturated = 0
+	default:
+		return int64(value) + incr, false
+	}
+
+	if overflow == OverflowWrap {
+		return int64((value + uint64(incr)) & limit), true
+	}
+	return int64(saturated), true
+}
+
+func (ft FieldType) checkSigned(value, incr int64, overflow Overflow) (int64, bool) {
+	maxValue := int64(math.MaxInt64)
+	if ft.Bits < 64 {
+		maxValue = int64(1)<<(ft.Bits-1) - 1
+	}
+	minValue := -maxValue - 1
+
+	// maxIncr and minIncr may overflow but are only used once value is
+	// known to be in range, like Redis does
+	maxIncr := int64(uint64(maxValue) - uint64(value))
+	minIncr := minValue - value
+
+	var saturated int64
+	switch {
+	case value > maxValue || (ft.Bits != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr):
+		saturated = maxValue
+	case value < minValue || (ft.Bits != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr):
+		saturated = minValue
+	default:
+		return value + incr, false
+	}
+
+	if overflow == OverflowWrap {
+		// Add as unsigned so wrapping is defined, then sign extend
+		result := uint64(value) + uint64(incr)
+		if ft.Bits < 64 {
+			mask := uint64(math.MaxUint64) << ft.Bits
+			if result&(1<<(ft.Bits-1)) != 0 {
+				result |= mask
+			} else {
+				result &^= mask
+			}
+		}
+		return int64(result), true
+	}
+	return saturated, true
+}
@@ -0,0 +1,93 @@
+package bitmap
+
+import "fmt"
+
+// Op is a BITOP operation
+type Op int
+
+const (
+	OpAnd  Op = iota
+	OpOr      // bits set in any source
+	OpXor     // bits set in an odd number of sources
+	OpNot     // inverted bits of the single source
+	OpDiff    // bits of the first source not set in any other source
+)
+
+// ParseOp parses a BITOP operation name
+func ParseOp(name string) (Op, error) {
+	switch name {
+	case "AND":
+		return OpAnd, nil
+	case "OR":
+		return OpOr, nil
+	case "XOR":
+		return OpXor, nil
+	case "NOT":
+		return OpNot, nil
+	case "DIFF":
+		return OpDiff, nil
+	}
+	return 0, fmt.Errorf("ERR syntax error")
+}
+
+// CheckSources validates the number of source keys for op
+func (op Op) CheckSources(count int) error {
+	switch {
+	case op == OpNot && count != 1:
+		return fmt.Errorf("ERR BITOP NOT must be called with a single source key.")
+	case op == OpDiff && count < 2:
+		return fmt.Errorf("ERR BITOP DIFF must be called with at least two source keys.")
+	}
+	return nil
+}
+
+// Apply computes op over the source strings. Shorter sources are padded
+// with zero bytes, so the result is as long as the longest source.
+func Apply(op Op, sources [][]byte) []byte {
+	maxLen := 0
+	for _, src := range sources {
+		maxLen = max(maxLen, len(src))
+	}
+	if maxLen == 0 {
+		return nil
+	}
+
+	at := func(src []byte, i int) byte {
+		if i < len(src) {
+			return src[i]
+		}
+		return 0
+	}
+
+	result := make([]byte, maxLen)
+	for i := range result {
+		switch op {
+		case OpNot:
+			result[i] = ^at(sources[0], i)
+		case OpAnd:
+			b := byte(0xff)
+			for _, src := range sources {
+				b &= at(src, i)
+			}
+			result[i] = b
+		case OpOr, OpXor, OpDiff:
+			var b byte
+			rest := sources
+			if op == OpDiff {
+				rest = sources[1:]
+			}
+			for _, src := range rest {
+				if op == OpXor {
+					b ^= at(src, i)
+				} else {
+					b |= at(src, i)
+				}
+			}
+			if op == OpDiff {
+				b = at(sources[0], i) &^ b
+			}
+			result[i] = b
+		}
+	}
+	return result
+}
@@ -13,14 +13,71 @@ type CLIConfig struct {
 	IsReplica  bool
 	MasterHost string
 	MasterPort string
+
+	// Persistence
+	Dir        string
+	DBFilename string
+	SaveRules  []SaveRule
+
+	// Append-only file
+	AppendOnly       bool
+	AppendFilename   string
+	AppendDirname    string
+	AppendFsync      string
+	AOFLoadTruncated bool
+
+	// File holding the key encrypting persistence files
+	EncryptionKeyFile string
+
+	// Replication backlog
+	ReplBacklogSize int
+	ReplBacklogTTL  int
+
+	// Seconds before a stalled replication link is dropped
+	ReplTimeout int
+
+	// Seconds between the PINGs a master sends to its replicas
+	ReplPingReplicaPeriod int
+
+	// Whether replicas refuse writes from clients
+	ReplicaReadOnly bool
+
+	// Output buffer limit of replicas
+	ReplicaOutputBufferLimit OutputBufferLimit
+
+	// Diskless replication
+	ReplDisklessSync      bool
+	ReplDisklessSyncDelay int
+	ReplDisklessLoad      string
 }
 
 // ParseArgs parses command line arguments and returns CLIConfig
 func ParseArgs() (*CLIConfig, error) {
 	config := &CLIConfig{
-		Port:      "6379", // Default port
-		IsReplica: false,
+		Port:       "6379", // Default port
+		IsReplica:  false,
+		Dir:        ".",
+		DBFilename: "dump.rdb",
+		SaveRules:  DefaultSaveRules,
+
+		AppendFilename:   "appendonly.aof",
+		AppendDirname:    "appendonlydir",
+		AppendFsync:      "everysec",
+		AOFLoadTruncated: true,
+
+		ReplBacklogSize: 1024 * 1024,
+		ReplBacklogTTL:  3600,
+		ReplTimeout:     60,
+		ReplicaReadOnly: true,
+
+		ReplPingReplicaPeriod: 10,
+
+		ReplicaOutputBufferLimit: DefaultReplicaOutputBufferLimit,
+
+		ReplDisklessSyncDelay: 5,
+		ReplDisklessLoad:      DisklessLoadDisabled,
 	}
+	saveGiven := false
 
 	args := os.Args[1:] // Skip program name
 
@@ -49,6 +106,194 @@ func ParseArgs() (*CLIConfig, error) {
 			config.IsReplica = true
 			i++ // Skip the next argument since we consumed it
 
+		case "--dir":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--dir requires a value")
+			}
+			config.Dir = args[i+1]
+			i++
+
+		case "--dbfilename":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--dbfilename requires a value")
+			}
+			// Like Redis, the file name must not contain a path
+			if strings.ContainsAny(args[i+1], "/\\") {
+				return nil, fmt.Errorf("--dbfilename can't be a path, just a filename: %s", args[i+1])
+			}
+			config.DBFilename = args[i+1]
+			i++
+
+		case "--save":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--save requires a value")
+			}
+			rules, err := ParseSaveRules(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			// Repeated --save options add up, the first one replaces the defaults
+			if !saveGiven {
+				config.SaveRules = nil
+				saveGiven = true
+			}
+			config.SaveRules = append(config.SaveRules, rules...)
+			i++
+
+		case "--replica-read-only", "--slave-read-only":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", args[i])
+			}
+			enabled, err := parseYesNo(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", args[i], err)
+			}
+			config.ReplicaReadOnly = enabled
+			i++
+
+		case "--repl-diskless-sync":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--repl-diskless-sync requires a value")
+			}
+			enabled, err := parseYesNo(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("--repl-diskless-sync: %v", err)
+			}
+			config.ReplDisklessSync = enabled
+			i++
+
+		case "--repl-diskless-sync-delay":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--repl-diskless-sync-delay requires a value")
+			}
+			delay, err := strconv.Atoi(args[i+1])
+			if err != nil || delay < 0 {
+				return nil, fmt.Errorf("invalid --repl-diskless-sync-delay: %s", args[i+1])
+			}
+			config.ReplDisklessSyncDelay = delay
+			i++
+
+		case "--repl-diskless-load":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--repl-diskless-load requires a value")
+			}
+			switch mode := strings.ToLower(args[i+1]); mode {
+			case DisklessLoadDisabled, DisklessLoadOnEmptyDB, DisklessLoadSwapDB:
+				config.ReplDisklessLoad = mode
+			default:
+				return nil, fmt.Errorf("--repl-diskless-load must be disabled, on-empty-db or swapdb, got: %s", args[i+1])
+			}
+			i++
+
+		case "--appendonly", "--aof-load-truncated":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("%s requires a value", args[i])
+			}
+			enabled, err := parseYesNo(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", args[i], err)
+			}
+			if args[i] == "--appendonly" {
+				config.AppendOnly = enabled
+			} else {
+				config.AOFLoadTruncated = enabled
+			}
+			i++
+
+		case "--appendfilename":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--appendfilename requires a value")
+			}
+			if strings.ContainsAny(args[i+1], "/\\") {
+				return nil, fmt.Errorf("--appendfilename can't be a path, just a filename: %s", args[i+1])
+			}
+			config.AppendFilename = args[i+1]
+			i++
+
+		case "--appenddirname":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--appenddirname requires a value")
+			}
+			if strings.ContainsAny(args[i+1], "/\\") {
+				return nil, fmt.Errorf("--appenddirname can't be a path, just a dirname: %s", args[i+1])
+			}
+			config.AppendDirname = args[i+1]
+			i++
+
+		case "--appendfsync":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--appendfsync requires a value")
+			}
+			switch policy := strings.ToLower(args[i+1]); policy {
+			case "always", "everysec", "no":
+				config.AppendFsync = policy
+			default:
+				return nil, fmt.Errorf("--appendfsync must be always, everysec or no, got: %s", args[i+1])
+			}
+			i++
+
+		case "--encryption-key-file":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--encryption-key-file requires a value")
+			}
+			config.EncryptionKeyFile = args[i+1]
+			i++
+
+		case "--repl-backlog-size":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--repl-backlog-size requires a value")
+			}
+			size, err := ParseMemory(args[i+1])
+			if err != nil || size < 1 {
+				return nil, fmt.Errorf("invalid --repl-backlog-size: %s", args[i+1])
+			}
+			config.ReplBacklogSize = int(size)
+			i++
+
+		case "--repl-backlog-ttl":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--repl-backlog-ttl requires a value")
+			}
+			ttl, err := strconv.Atoi(args[i+1])
+			if err != nil || ttl < 0 {
+				return nil, fmt.Errorf("invalid --repl-backlog-ttl: %s", args[i+1])
+			}
+			config.ReplBacklogTTL = ttl
+			i++
+
+		case "--repl-timeout":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--repl-timeout requires a value")
+			}
+			timeout, err := strconv.Atoi(args[i+1])
+			if err != nil || timeout < 1 {
+				return nil, fmt.Errorf("invalid --repl-timeout: %s", args[i+1])
+			}
+			config.ReplTimeout = timeout
+			i++
+
+		case "--repl-ping-replica-period":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--repl-ping-replica-period requires a value")
+			}
+			period, err := strconv.Atoi(args[i+1])
+			if err != nil || period < 1 {
+				return nil, fmt.Errorf("invalid --repl-ping-replica-period: %s", args[i+1])
+			}
+			config.ReplPingReplicaPeriod = period
+			i++
+
+		case "--client-output-buffer-limit":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--client-output-buffer-limit requires a value")
+			}
+			limit, err := ParseReplicaOutputBufferLimit(args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			config.ReplicaOutputBufferLimit = limit
+			i++
+
 		default:
 			return nil, fmt.Errorf("unknown argument: %s", args[i])
 		}
@@ -68,3 +313,41 @@ func ParseArgs() (*CLIConfig, error) {
 
 	return config, nil
 }
+
+// ParseMemory parses an amount of memory in bytes, optionally followed by
+// one of the units Redis accepts: k, kb, m, mb, g and gb (case
+// insensitive). k, m and g are powers of 1000, kb, mb and gb of 1024.
+func ParseMemory(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+	lower := strings.ToLower(s)
+	factor := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			factor = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory value: %s", s)
+	}
+	return n * factor, nil
+}
+
+// parseYesNo parses a boolean option given as yes or no
+func parseYesNo(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("argument must be 'yes' or 'no', got: %s", s)
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OutputBufferLimit bounds the data waiting to be sent to a client. It is
+// disconnected once it holds more than Hard bytes, or more than Soft bytes
+// for SoftSeconds in a row. 0 disables a limit.
+type OutputBufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds int
+}
+
+// DefaultReplicaOutputBufferLimit is the limit of the replica class Redis
+// uses without configuration
+var DefaultReplicaOutputBufferLimit = OutputBufferLimit{256 * 1024 * 1024, 64 * 1024 * 1024, 60}
+
+// ParseReplicaOutputBufferLimit parses "replica <hard> <soft> <soft
+// seconds>", the only class of client-output-buffer-limit supported. slave
+// is accepted as an alias of replica and the sizes take memory units.
+func ParseReplicaOutputBufferLimit(s string) (OutputBufferLimit, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 4 {
+		return OutputBufferLimit{}, fmt.Errorf("invalid client-output-buffer-limit: %q", s)
+	}
+	switch strings.ToLower(fields[0]) {
+	case "replica", "slave":
+	case "normal", "pubsub":
+		return OutputBufferLimit{}, fmt.Errorf("unsupported client-output-buffer-limit class: %s", fields[0])
+	default:
+		return OutputBufferLimit{}, fmt.Errorf("invalid client-output-buffer-limit class: %s", fields[0])
+	}
+
+	hard, err1 := ParseMemory(fields[1])
+	soft, err2 := ParseMemory(fields[2])
+	seconds, err3 := strconv.Atoi(fields[3])
+	if err1 != nil || err2 != nil || err3 != nil || seconds < 0 {
+		return OutputBufferLimit{}, fmt.Errorf("invalid client-output-buffer-limit: %q", s)
+	}
+	return OutputBufferLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}, nil
+}
+
+// FormatReplicaOutputBufferLimit formats limit the way CONFIG GET
+// client-output-buffer-limit reports it
+func FormatReplicaOutputBufferLimit(limit OutputBufferLimit) string {
+	return fmt.Sprintf("replica %d %d %d", limit.Hard, limit.Soft, limit.SoftSeconds)
+}
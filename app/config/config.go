@@ -1,6 +1,11 @@
 package config
 
-import "fmt"
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+)
 
 // ServerConfig holds all server configuration
 type ServerConfig struct {
@@ -13,17 +18,111 @@ type ServerConfig struct {
 	// Network configuration
 	Port string
 
-	// Replication constants
+	// Persistence configuration
+	Dir        string
+	DBFilename string
+	SaveRules  []SaveRule
+
+	// Append-only file configuration
+	AppendOnly       bool
+	AppendFilename   string
+	AppendDirname    string
+	AppendFsync      string
+	AOFLoadTruncated bool
+
+	// Key encrypting the RDB and append-only files, nil when they are
+	// stored in plaintext. Never returned by CONFIG GET.
+	EncryptionKey []byte
+
+	// Replication ID and offset of the dataset. A replica takes those of
+	// its master; MasterCached reports that they describe its dataset, so
+	// it can ask its master for a partial resynchronization.
 	MasterReplId     string
 	MasterReplOffset int
+	MasterCached     bool
+
+	// Replication ID of the history this server followed before it was
+	// promoted, valid up to SecondReplOffset (-1 when there is none), so
+	// the replicas of its former master can partially resynchronize
+	MasterReplId2    string
+	SecondReplOffset int
+
+	// Replication backlog
+	ReplBacklogSize int
+	ReplBacklogTTL  int // Seconds without replicas before it is freed, 0 for never
+
+	// Seconds a replica waits for data from its master before dropping
+	// the link, during the handshake, the transfer of the RDB file and the
+	// stream of commands alike
+	ReplTimeout int
+
+	// Seconds between the PINGs a master sends to its replicas, so an idle
+	// link is not mistaken for a stalled one
+	ReplPingReplicaPeriod int
+
+	// Whether a replica refuses writes from clients
+	ReplicaReadOnly bool
+
+	// Limit of the data queued for each replica
+	ReplicaOutputBufferLimit OutputBufferLimit
+
+	// Diskless replication: whether a master streams the RDB of a full
+	// resync straight to the replicas supporting it, waiting
+	// ReplDisklessSyncDelay seconds for more replicas to share the
+	// transfer, and how a replica loads it (one of the DisklessLoad modes)
+	ReplDisklessSync      bool
+	ReplDisklessSyncDelay int
+	ReplDisklessLoad      string
 }
 
 // Global server configuration instance
 var Server = &ServerConfig{
 	Role:             "master",
 	Port:             "6379",
-	MasterReplId:     "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb",
+	Dir:              ".",
+	DBFilename:       "dump.rdb",
+	SaveRules:        DefaultSaveRules,
+	AppendFilename:   "appendonly.aof",
+	AppendDirname:    "appendonlydir",
+	AppendFsync:      "everysec",
+	AOFLoadTruncated: true,
+	MasterReplId:     NewReplId(),
 	MasterReplOffset: 0,
+	MasterReplId2:    NoReplId,
+	SecondReplOffset: -1,
+	ReplBacklogSize:  1024 * 1024,
+	ReplBacklogTTL:   3600,
+	ReplTimeout:      60,
+	ReplicaReadOnly:  true,
+
+	ReplPingReplicaPeriod: 10,
+
+	ReplicaOutputBufferLimit: DefaultReplicaOutputBufferLimit,
+
+	ReplDisklessSyncDelay: 5,
+	ReplDisklessLoad:      DisklessLoadDisabled,
+}
+
+// Modes of repl-diskless-load. A replica saves the RDB it receives on a
+// full resync as its RDB file and loads it from there when disabled, or
+// decodes it straight from the socket. on-empty-db does so only while its
+// dataset is empty.
+const (
+	DisklessLoadDisabled  = "disabled"
+	DisklessLoadOnEmptyDB = "on-empty-db"
+	DisklessLoadSwapDB    = "swapdb"
+)
+
+// NoReplId is the replication ID reported when there is none
+const NoReplId = "0000000000000000000000000000000000000000"
+
+// NewReplId returns a random replication ID of 40 hexadecimal characters
+func NewReplId() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
 }
 
 // SetServerRole sets the server's role (master or slave)
@@ -45,7 +144,109 @@ func SetReplicaConfig(masterHost, masterPort string) {
 	Server.Role = "slave"
 }
 
+// SetMasterConfig configures a replica as a master again
+func SetMasterConfig() {
+	Server.IsReplica = false
+	Server.MasterHost = ""
+	Server.MasterPort = ""
+	Server.Role = "master"
+}
+
+// SetPort sets the port the server listens on, announced to masters
+func SetPort(port string) {
+	Server.Port = port
+}
+
+// ShiftReplId starts a new replication history, when a replica is
+// promoted. The current ID is kept as the second one, valid up to the
+// current offset.
+func ShiftReplId() {
+	Server.MasterReplId2 = Server.MasterReplId
+	Server.SecondReplOffset = Server.MasterReplOffset + 1
+	Server.MasterReplId = NewReplId()
+}
+
+// ClearReplId2 forgets the second replication ID
+func ClearReplId2() {
+	Server.MasterReplId2 = NoReplId
+	Server.SecondReplOffset = -1
+}
+
 // IsServerMaster returns true if the server is a master
 func IsServerMaster() bool {
 	return Server.Role == "master"
 }
+
+// SetReplBacklogConfig sets the size of the replication backlog and how
+// long it is kept without replicas
+func SetReplBacklogConfig(size, ttl int) {
+	Server.ReplBacklogSize = size
+	Server.ReplBacklogTTL = ttl
+}
+
+// SetReplTimeout sets the replication timeout in seconds
+func SetReplTimeout(seconds int) {
+	Server.ReplTimeout = seconds
+}
+
+// SetReplPingReplicaPeriod sets the seconds between the PINGs a master
+// sends to its replicas
+func SetReplPingReplicaPeriod(seconds int) {
+	Server.ReplPingReplicaPeriod = seconds
+}
+
+// SetReplicaReadOnly sets whether a replica refuses writes from clients
+func SetReplicaReadOnly(readOnly bool) {
+	Server.ReplicaReadOnly = readOnly
+}
+
+// SetReplicaOutputBufferLimit sets the output buffer limit of replicas
+func SetReplicaOutputBufferLimit(limit OutputBufferLimit) {
+	Server.ReplicaOutputBufferLimit = limit
+}
+
+// SetReplDisklessConfig sets how full resyncs transfer the RDB file
+func SetReplDisklessConfig(sync bool, syncDelay int, load string) {
+	Server.ReplDisklessSync = sync
+	Server.ReplDisklessSyncDelay = syncDelay
+	Server.ReplDisklessLoad = load
+}
+
+// SetPersistenceConfig sets the location of the RDB file and the rules
+// triggering automatic saves
+func SetPersistenceConfig(dir, dbFilename string, saveRules []SaveRule) {
+	Server.Dir = dir
+	Server.DBFilename = dbFilename
+	Server.SaveRules = saveRules
+}
+
+// RDBPath returns the path of the RDB file
+func RDBPath() string {
+	return filepath.Join(Server.Dir, Server.DBFilename)
+}
+
+// SetAppendOnlyConfig sets the append-only file options
+func SetAppendOnlyConfig(enabled bool, filename, dirname, fsync string, loadTruncated bool) {
+	Server.AppendOnly = enabled
+	Server.AppendFilename = filename
+	Server.AppendDirname = dirname
+	Server.AppendFsync = fsync
+	Server.AOFLoadTruncated = loadTruncated
+}
+
+// SetEncryptionKey sets the key encrypting persistence files, nil to write
+// them in plaintext
+func SetEncryptionKey(key []byte) {
+	Server.EncryptionKey = key
+}
+
+// AOFDir returns the directory holding the files of the append-only file
+func AOFDir() string {
+	return filepath.Join(Server.Dir, Server.AppendDirname)
+}
+
+// AOFPath returns the path of a single-file append-only file written by an
+// older version, upgraded to the multi-part layout when found
+func AOFPath() string {
+	return filepath.Join(Server.Dir, Server.AppendFilename)
+}
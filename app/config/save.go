@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SaveRule triggers a background save once Changes writes happened and at
+// least Seconds elapsed since the last save
+type SaveRule struct {
+	Seconds int
+	Changes int
+}
+
+// DefaultSaveRules are the save points Redis uses without configuration
+var DefaultSaveRules = []SaveRule{{3600, 1}, {300, 100}, {60, 10000}}
+
+// ParseSaveRules parses "<seconds> <changes> [<seconds> <changes> ...]".
+// An empty string disables automatic saves.
+func ParseSaveRules(s string) ([]SaveRule, error) {
+	fields := strings.Fields(s)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save parameters: %q", s)
+	}
+
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.Atoi(fields[i])
+		changes, err2 := strconv.Atoi(fields[i+1])
+		if err1 != nil || err2 != nil || seconds < 1 || changes < 0 {
+			return nil, fmt.Errorf("invalid save parameters: %q", s)
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
+
+// FormatSaveRules formats rules the way CONFIG GET save reports them
+func FormatSaveRules(rules []SaveRule) string {
+	parts := make([]string, 0, len(rules)*2)
+	for _, rule := range rules {
+		parts = append(parts, strconv.Itoa(rule.Seconds), strconv.Itoa(rule.Changes))
+	}
+	return strings.Join(parts, " ")
+}
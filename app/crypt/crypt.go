@@ -0,0 +1,267 @@
+// Package crypt encrypts persistence files at rest with AES-256-GCM. Data
+// is sealed in chunks of at most ChunkSize bytes, so files of any size can
+// be written and read as streams, and an append-only file can grow one
+// chunk at a time. An encrypted file is laid out as:
+//
+//	header: "RDENC" | version (1 byte) | file id (16 random bytes)
+//	chunk:  length (4 bytes, big endian) | sealed data
+//
+// length is the size of the sealed data, the plaintext plus the 16 byte
+// authentication tag. Chunks are not sealed with the key itself but with a
+// subkey derived from it and the file id with HKDF-SHA256, so every file
+// has its own key. The nonce of a chunk is its index in the file: it never
+// repeats under a subkey, however many chunks are appended, which random
+// nonces under a single key could not guarantee. Each chunk is also
+// authenticated together with the header and its index, so chunks cannot
+// be altered, reordered or moved to another file without being detected.
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	// KeySize is the size of an AES-256 key
+	KeySize = 32
+	// ChunkSize is the largest amount of plaintext sealed in one chunk
+	ChunkSize = 64 * 1024
+	// EnvKey is the environment variable holding the key when no key file
+	// is given
+	EnvKey = "REDIS_ENCRYPTION_KEY"
+)
+
+const (
+	magic      = "RDENC"
+	version    = 1
+	idSize     = 16
+	headerSize = len(magic) + 1 + idSize
+	tagSize    = 16
+	lengthSize = 4
+
+	// subkeyInfo binds the subkeys derived from a key to their use
+	subkeyInfo = "RDENC chunk key"
+)
+
+var (
+	// ErrNoKey is returned when opening an encrypted file without a key
+	ErrNoKey = errors.New("the file is encrypted and no encryption key is configured")
+
+	// ErrAuth is returned when a chunk fails authentication
+	ErrAuth = errors.New("chunk authentication failed: wrong key or corrupted data")
+
+	// ErrFormat is returned when a file is not in the expected format
+	ErrFormat = errors.New("bad encrypted file format")
+)
+
+// Error is a decryption error with the offset in the encrypted file of the
+// chunk that could not be read
+type Error struct {
+	Offset int64
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v at encrypted offset %d", e.Err, e.Offset)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ParseKey decodes a key given as 64 hexadecimal digits or in base64
+func ParseKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := hex.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("the encryption key must be %d bytes, given as hex or base64", KeySize)
+}
+
+// LoadKey reads the key stored in the file at path, either as raw bytes or
+// encoded like ParseKey expects
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0o077 != 0 {
+		fmt.Printf("Warning: the encryption key file %s is accessible by other users\n", path)
+	}
+	if len(data) == KeySize {
+		return data, nil
+	}
+	return ParseKey(string(data))
+}
+
+// ConfiguredKey returns the key stored in keyFile, or the one in the EnvKey
+// environment variable when keyFile is empty. It returns nil when neither
+// is set, meaning files are not encrypted.
+func ConfiguredKey(keyFile string) ([]byte, error) {
+	if keyFile != "" {
+		key, err := LoadKey(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("encryption key file %s: %w", keyFile, err)
+		}
+		return key, nil
+	}
+	if s, ok := os.LookupEnv(EnvKey); ok && s != "" {
+		key, err := ParseKey(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", EnvKey, err)
+		}
+		return key, nil
+	}
+	return nil, nil
+}
+
+// checkKey reports a key of the wrong size
+func checkKey(key []byte) error {
+	if len(key) != KeySize {
+		return fmt.Errorf("the encryption key must be %d bytes, got %d", KeySize, len(key))
+	}
+	return nil
+}
+
+// newAEAD creates the AES-256-GCM cipher sealing the chunks of the file
+// with the given header, keyed with the subkey of key for that file
+func newAEAD(key, header []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(deriveSubkey(key, header[len(magic)+1:]))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// deriveSubkey derives the key of the file with the given id from key with
+// HKDF-SHA256 (RFC 5869), the id being the salt. A single block of output
+// is expanded, as KeySize is the size of a SHA-256 hash.
+func deriveSubkey(key, id []byte) []byte {
+	extract := hmac.New(sha256.New, id)
+	extract.Write(key)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(subkeyInfo))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+// chunkNonce returns the nonce of chunk index, the index itself
+func chunkNonce(aead cipher.AEAD, index uint64) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], index)
+	return nonce
+}
+
+// additionalData returns the data authenticated with chunk index of the
+// file with the given header
+func additionalData(header []byte, index uint64) []byte {
+	ad := make([]byte, len(header)+8)
+	copy(ad, header)
+	binary.BigEndian.PutUint64(ad[len(header):], index)
+	return ad
+}
+
+// readHeader reads and validates the header at the start of r
+func readHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, &Error{Offset: 0, Err: err}
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, &Error{Offset: 0, Err: ErrFormat}
+	}
+	if header[len(magic)] != version {
+		return nil, &Error{Offset: 0, Err: fmt.Errorf("unsupported encrypted file version %d", header[len(magic)])}
+	}
+	return header, nil
+}
+
+// IsEncrypted reports whether r starts with the header of an encrypted
+// file. r is positioned back at its start.
+func IsEncrypted(r io.ReadSeeker) (bool, error) {
+	prefix := make([]byte, len(magic))
+	n, err := io.ReadFull(r, prefix)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return bytes.Equal(prefix[:n], []byte(magic)) && n == len(magic), nil
+}
+
+// File is a file opened for reading by Open, decrypted when needed
+type File struct {
+	io.ReadSeeker
+	file      *os.File
+	encrypted bool
+}
+
+// Open opens the file at path for reading. An encrypted file is decrypted
+// transparently with key; other files are read as they are, so plaintext
+// files written before encryption was enabled can still be loaded.
+func Open(path string, key []byte) (*File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := IsEncrypted(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !encrypted {
+		return &File{ReadSeeker: file, file: file}, nil
+	}
+	if key == nil {
+		file.Close()
+		return nil, ErrNoKey
+	}
+	reader, err := NewReader(file, key)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &File{ReadSeeker: reader, file: file, encrypted: true}, nil
+}
+
+// Encrypted reports whether the file is encrypted
+func (f *File) Encrypted() bool {
+	return f.encrypted
+}
+
+// EncryptedOffset returns the offset in the file of the plaintext offset
+// plain, which must be a chunk boundary in an encrypted file
+func (f *File) EncryptedOffset(plain int64) (int64, error) {
+	if !f.encrypted {
+		return plain, nil
+	}
+	return f.ReadSeeker.(*Reader).ChunkOffset(plain)
+}
+
+// Stat returns information about the underlying file
+func (f *File) Stat() (os.FileInfo, error) {
+	return f.file.Stat()
+}
+
+// Close closes the underlying file
+func (f *File) Close() error {
+	return f.file.Close()
+}
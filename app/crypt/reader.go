@@ -0,0 +1,184 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Reader decrypts a file written by a Writer
+type Reader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	header []byte
+	index  uint64 // Index of the next chunk
+	offset int64  // Encrypted offset of the next chunk
+	plain  []byte // Decrypted data of the current chunk not read yet
+	err    error
+}
+
+// NewReader creates a reader decrypting r with key. The header is read
+// right away, so a file in the wrong format is reported here.
+func NewReader(r io.Reader, key []byte) (*Reader, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	header, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key, header)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{r: r, aead: aead, header: header, offset: int64(headerSize)}, nil
+}
+
+// Read reads decrypted data. A chunk cut short at the end of the file is
+// reported as io.ErrUnexpectedEOF, and a chunk failing authentication as
+// an *Error wrapping ErrAuth.
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk
+func (r *Reader) next() error {
+	var length [lengthSize]byte
+	if _, err := io.ReadFull(r.r, length[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size < tagSize || size > ChunkSize+tagSize {
+		return &Error{Offset: r.offset, Err: ErrFormat}
+	}
+
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(r.r, sealed); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	plain, err := r.aead.Open(sealed[:0], chunkNonce(r.aead, r.index), sealed, additionalData(r.header, r.index))
+	if err != nil {
+		return &Error{Offset: r.offset, Err: ErrAuth}
+	}
+	r.plain = plain
+	r.index++
+	r.offset += int64(lengthSize + len(sealed))
+	return nil
+}
+
+// walk calls fn with the encrypted offset and the plaintext size of each
+// chunk of r until fn returns false, without decrypting them. It returns
+// the offset following the last chunk visited, and an *Error wrapping
+// io.ErrUnexpectedEOF if the file ends in the middle of a chunk.
+func walk(r io.ReadSeeker, fn func(offset, size int64) bool) (int64, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	offset := int64(headerSize)
+	for offset < end {
+		if end-offset < lengthSize {
+			return offset, &Error{Offset: offset, Err: io.ErrUnexpectedEOF}
+		}
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return offset, err
+		}
+		var length [lengthSize]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return offset, err
+		}
+		size := int64(binary.BigEndian.Uint32(length[:]))
+		if size < tagSize || size > ChunkSize+tagSize {
+			return offset, &Error{Offset: offset, Err: ErrFormat}
+		}
+		if end-offset < lengthSize+size {
+			return offset, &Error{Offset: offset, Err: io.ErrUnexpectedEOF}
+		}
+		if !fn(offset, size-tagSize) {
+			return offset, nil
+		}
+		offset += lengthSize + size
+	}
+	return offset, nil
+}
+
+// Seek moves to a plaintext offset. Only io.SeekStart is supported, and
+// the underlying reader must be an io.Seeker. Chunks before the offset
+// are skipped without being decrypted.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := r.r.(io.ReadSeeker)
+	if !ok || whence != io.SeekStart || offset < 0 {
+		return 0, errors.New("crypt: unsupported seek")
+	}
+
+	var pos int64
+	var index uint64
+	inside := false
+	chunk, err := walk(seeker, func(_, size int64) bool {
+		if pos+size > offset {
+			inside = true
+			return false
+		}
+		pos += size
+		index++
+		return true
+	})
+	if err != nil && pos < offset {
+		return 0, err
+	}
+	if !inside && pos < offset {
+		return 0, errors.New("crypt: seek beyond the end of the file")
+	}
+	if _, err := seeker.Seek(chunk, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r.index, r.offset, r.plain, r.err = index, chunk, nil, nil
+	if pos == offset {
+		return offset, nil
+	}
+
+	// The offset falls inside this chunk
+	if err := r.next(); err != nil {
+		r.err = err
+		return 0, err
+	}
+	r.plain = r.plain[offset-pos:]
+	return offset, nil
+}
+
+// ChunkOffset returns the encrypted offset of the chunk starting at the
+// plaintext offset plain, which must be a chunk boundary. A file can be
+// truncated there to drop everything after plain. The read position is
+// reset to the start of the data.
+func (r *Reader) ChunkOffset(plain int64) (int64, error) {
+	seeker, ok := r.r.(io.ReadSeeker)
+	if !ok {
+		return 0, errors.New("crypt: the file is not seekable")
+	}
+	defer r.Seek(0, io.SeekStart)
+
+	var pos int64
+	chunk, err := walk(seeker, func(_, size int64) bool {
+		if pos >= plain {
+			return false
+		}
+		pos += size
+		return true
+	})
+	if pos != plain || (err != nil && !errors.Is(err, io.ErrUnexpectedEOF)) {
+		return 0, errors.New("crypt: the offset is not at a chunk boundary")
+	}
+	return chunk, nil
+}
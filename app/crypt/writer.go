@@ -0,0 +1,104 @@
+package crypt
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+)
+
+// Writer encrypts data written to it. Data is buffered until a whole chunk
+// is available or Flush is called.
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	index  uint64 // Index of the next chunk
+	buf    []byte
+}
+
+// NewWriter creates a writer encrypting to w with key, starting with the
+// header of a new file
+func NewWriter(w io.Writer, key []byte) (*Writer, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize)
+	copy(header, magic)
+	header[len(magic)] = version
+	if _, err := rand.Read(header[len(magic)+1:]); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, aead: aead, header: header}, nil
+}
+
+// Resume creates a writer appending chunks to the encrypted file f, whose
+// existing chunks are counted without being decrypted. A file ending in
+// the middle of a chunk is an error, it must be truncated first.
+func Resume(f io.ReadWriteSeeker, key []byte) (*Writer, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	reader, err := NewReader(f, key)
+	if err != nil {
+		return nil, err
+	}
+	var index uint64
+	end, err := walk(f, func(_, _ int64) bool {
+		index++
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &Writer{w: f, aead: reader.aead, header: reader.header, index: index}, nil
+}
+
+// Write buffers p, sealing every chunk it completes
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(ChunkSize-len(w.buf), len(p))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		if len(w.buf) == ChunkSize {
+			if err := w.Flush(); err != nil {
+				return written, err
+			}
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Flush seals the buffered data as a chunk and writes it
+func (w *Writer) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	chunk := make([]byte, lengthSize, lengthSize+len(w.buf)+tagSize)
+	binary.BigEndian.PutUint32(chunk, uint32(len(w.buf)+tagSize))
+	chunk = w.aead.Seal(chunk, chunkNonce(w.aead, w.index), w.buf, additionalData(w.header, w.index))
+	if _, err := w.w.Write(chunk); err != nil {
+		return err
+	}
+	w.index++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close flushes the buffered data. The underlying writer is not closed.
+func (w *Writer) Close() error {
+	return w.Flush()
+}
@@ -0,0 +1,199 @@
+// Package geo implements the 52 bit geohash encoding Redis uses to store
+// positions as sorted set scores, along with the helpers needed to search
+// the members within a radius or a box.
+package geo
+
+import "math"
+
+const (
+	LongMin = -180.0
+	LongMax = 180.0
+	// Latitude limits of the Web Mercator projection (EPSG:900913)
+	LatMin = -85.05112878
+	LatMax = 85.05112878
+
+	// StepMax is the precision of stored hashes: 26 bits per coordinate
+	StepMax = 26
+
+	// EarthRadius is the earth radius in meters used by Redis' haversine
+	EarthRadius = 6372797.560856
+
+	mercatorMax = 20037726.37
+)
+
+// Range is the interval covered by a coordinate
+type Range struct {
+	Min, Max float64
+}
+
+// Hash is a geohash of Step bits per coordinate
+type Hash struct {
+	Bits uint64
+	Step uint8
+}
+
+// IsZero reports whether h is the zero hash used to mark skipped areas
+func (h Hash) IsZero() bool {
+	return h.Bits == 0 && h.Step == 0
+}
+
+// Area is the box of coordinates covered by a hash
+type Area struct {
+	Hash      Hash
+	Longitude Range
+	Latitude  Range
+}
+
+// Neighbors holds the hashes surrounding a hash with the same precision
+type Neighbors struct {
+	North, East, West, South                   Hash
+	NorthEast, SouthEast, NorthWest, SouthWest Hash
+}
+
+var (
+	longRange = Range{LongMin, LongMax}
+	latRange  = Range{LatMin, LatMax}
+
+	// Latitude range of the standard geohash used for GEOHASH replies
+	standardLatRange = Range{-90, 90}
+)
+
+// ValidCoordinates reports whether the position can be indexed
+func ValidCoordinates(longitude, latitude float64) bool {
+	return longitude >= LongMin && longitude <= LongMax &&
+		latitude >= LatMin && latitude <= LatMax
+}
+
+// interleave64 interleaves the bits of x and y, with x in the even bits
+func interleave64(xlo, ylo uint32) uint64 {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF}
+	s := [...]uint{1, 2, 4, 8, 16}
+
+	x, y := uint64(xlo), uint64(ylo)
+	for i := 4; i >= 0; i-- {
+		x = (x | (x << s[i])) & b[i]
+		y = (y | (y << s[i])) & b[i]
+	}
+	return x | (y << 1)
+}
+
+// deinterleave64 reverses interleave64, returning x in the low 32 bits and
+// y in the high 32 bits
+func deinterleave64(interleaved uint64) uint64 {
+	b := [...]uint64{0x5555555555555555, 0x3333333333333333, 0x0F0F0F0F0F0F0F0F, 0x00FF00FF00FF00FF, 0x0000FFFF0000FFFF, 0x00000000FFFFFFFF}
+	s := [...]uint{0, 1, 2, 4, 8, 16}
+
+	x, y := interleaved, interleaved>>1
+	for i := 0; i < len(b); i++ {
+		x = (x | (x >> s[i])) & b[i]
+		y = (y | (y >> s[i])) & b[i]
+	}
+	return x | (y << 32)
+}
+
+// encode computes the hash of a position with the given ranges and step
+func encode(lonRange, latRange Range, longitude, latitude float64, step uint8) (Hash, bool) {
+	if !ValidCoordinates(longitude, latitude) ||
+		latitude < latRange.Min || latitude > latRange.Max ||
+		longitude < lonRange.Min || longitude > lonRange.Max {
+		return Hash{}, false
+	}
+
+	latOffset := (latitude - latRange.Min) / (latRange.Max - latRange.Min)
+	longOffset := (longitude - lonRange.Min) / (lonRange.Max - lonRange.Min)
+	latOffset *= float64(uint64(1) << step)
+	longOffset *= float64(uint64(1) << step)
+	return Hash{Bits: interleave64(uint32(latOffset), uint32(longOffset)), Step: step}, true
+}
+
+// decode returns the area covered by hash
+func decode(lonRange, latRange Range, hash Hash) Area {
+	sep := deinterleave64(hash.Bits)
+	latScale := latRange.Max - latRange.Min
+	longScale := lonRange.Max - lonRange.Min
+	ilato := float64(uint32(sep))
+	ilono := float64(uint32(sep >> 32))
+	cells := float64(uint64(1) << hash.Step)
+
+	return Area{
+		Hash: hash,
+		Latitude: Range{
+			Min: latRange.Min + (ilato/cells)*latScale,
+			Max: latRange.Min + ((ilato+1)/cells)*latScale,
+		},
+		Longitude: Range{
+			Min: lonRange.Min + (ilono/cells)*longScale,
+			Max: lonRange.Min + ((ilono+1)/cells)*longScale,
+		},
+	}
+}
+
+// center returns the position at the middle of area, clamped to the
+// supported coordinates
+func (a Area) center() (float64, float64) {
+	longitude := (a.Longitude.Min + a.Longitude.Max) / 2
+	latitude := (a.Latitude.Min + a.Latitude.Max) / 2
+	longitude = math.Max(LongMin, math.Min(LongMax, longitude))
+	latitude = math.Max(LatMin, math.Min(LatMax, latitude))
+	return longitude, latitude
+}
+
+// Encode returns the sorted set score of a position
+func Encode(longitude, latitude float64) (float64, bool) {
+	hash, ok := encode(longRange, latRange, longitude, latitude, StepMax)
+	if !ok {
+		return 0, false
+	}
+	return float64(hash.Bits), true
+}
+
+// Decode returns the position stored in a sorted set score
+func Decode(score float64) (longitude, latitude float64) {
+	hash := Hash{Bits: uint64(score), Step: StepMax}
+	return decode(longRange, latRange, hash).center()
+}
+
+// StandardHash returns the 11 character geohash string of a score. Scores
+// use the Mercator latitude range, so the position is re-encoded with the
+// standard [-90, 90] range first.
+func StandardHash(score float64) string {
+	const alphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+	longitude, latitude := Decode(score)
+	hash, _ := encode(longRange, standardLatRange, longitude, latitude, StepMax)
+
+	buf := make([]byte, 11)
+	for i := range buf {
+		idx := 0
+		// 52 bits only make 10 characters, the last one is always zero
+		if i < 10 {
+			idx = int(hash.Bits>>(52-(i+1)*5)) & 0x1f
+		}
+		buf[i] = alphabet[idx]
+	}
+	return string(buf)
+}
+
+func degRad(ang float64) float64 { return ang * (math.Pi / 180.0) }
+func radDeg(ang float64) float64 { return ang / (math.Pi / 180.0) }
+
+// LatDistance returns the distance in meters between two latitudes
+func LatDistance(lat1, lat2 float64) float64 {
+	return EarthRadius * math.Abs(degRad(lat2)-degRad(lat1))
+}
+
+// Distance returns the haversine distance in meters between two positions
+func Distance(lon1, lat1, lon2, lat2 float64) float64 {
+	lon1r := degRad(lon1)
+	lon2r := degRad(lon2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	// Same longitude, skip the expensive math
+	if v == 0.0 {
+		return LatDistance(lat1, lat2)
+	}
+	lat1r := degRad(lat1)
+	lat2r := degRad(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	a := u*u + math.Cos(lat1r)*math.Cos(lat2r)*v*v
+	return 2.0 * EarthRadius * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,205 @@
+package geo
+
+import "math"
+
+// Shape is the area searched by GEOSEARCH: a circle of Radius or a box of
+// Width x Height centered on (Longitude, Latitude). Sizes are expressed in
+// the unit selected by the user and Conversion turns them into meters.
+type Shape struct {
+	Longitude, Latitude float64
+	Box                 bool
+	Radius              float64
+	Width, Height       float64
+	Conversion          float64
+}
+
+// Contains reports whether the position is inside the shape and returns its
+// distance from the center in meters
+func (s *Shape) Contains(longitude, latitude float64) (float64, bool) {
+	if !s.Box {
+		distance := Distance(s.Longitude, s.Latitude, longitude, latitude)
+		return distance, distance <= s.Radius*s.Conversion
+	}
+
+	// The latitude distance is cheaper to compute, check it first
+	if LatDistance(latitude, s.Latitude) > s.Height*s.Conversion/2 {
+		return 0, false
+	}
+	if Distance(longitude, latitude, s.Longitude, latitude) > s.Width*s.Conversion/2 {
+		return 0, false
+	}
+	return Distance(s.Longitude, s.Latitude, longitude, latitude), true
+}
+
+// boundingBox returns the min/max longitude and latitude enclosing the shape
+func (s *Shape) boundingBox() (minLon, minLat, maxLon, maxLat float64) {
+	height := s.Conversion * s.Radius
+	width := height
+	if s.Box {
+		height = s.Conversion * s.Height / 2
+		width = s.Conversion * s.Width / 2
+	}
+
+	latDelta := radDeg(height / EarthRadius)
+	longDeltaTop := radDeg(width / EarthRadius / math.Cos(degRad(s.Latitude+latDelta)))
+	longDeltaBottom := radDeg(width / EarthRadius / math.Cos(degRad(s.Latitude-latDelta)))
+
+	// The hemispheres are mirrored, so the widest edge of the box is the
+	// one closest to the equator
+	if s.Latitude < 0 {
+		minLon, maxLon = s.Longitude-longDeltaBottom, s.Longitude+longDeltaBottom
+	} else {
+		minLon, maxLon = s.Longitude-longDeltaTop, s.Longitude+longDeltaTop
+	}
+	return minLon, s.Latitude - latDelta, maxLon, s.Latitude + latDelta
+}
+
+// estimateSteps returns the geohash precision whose cells are large enough
+// for a search of rangeMeters around latitude
+func estimateSteps(rangeMeters, latitude float64) uint8 {
+	if rangeMeters == 0 {
+		return StepMax
+	}
+	step := 1
+	for rangeMeters < mercatorMax {
+		rangeMeters *= 2
+		step++
+	}
+	step -= 2 // Make sure the range is included in most of the base cases
+
+	// Cells get narrower towards the poles
+	if latitude > 66 || latitude < -66 {
+		step--
+		if latitude > 80 || latitude < -80 {
+			step--
+		}
+	}
+
+	return uint8(max(1, min(StepMax, step)))
+}
+
+// moveX moves hash by one cell east (d > 0) or west (d < 0)
+func moveX(hash *Hash, d int) {
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+	zz := uint64(0x5555555555555555) >> (64 - uint(hash.Step)*2)
+	if d > 0 {
+		x = x + (zz + 1)
+	} else {
+		x = x | zz
+		x = x - (zz + 1)
+	}
+	x &= uint64(0xaaaaaaaaaaaaaaaa) >> (64 - uint(hash.Step)*2)
+	hash.Bits = x | y
+}
+
+// moveY moves hash by one cell north (d > 0) or south (d < 0)
+func moveY(hash *Hash, d int) {
+	x := hash.Bits & 0xaaaaaaaaaaaaaaaa
+	y := hash.Bits & 0x5555555555555555
+	zz := uint64(0xaaaaaaaaaaaaaaaa) >> (64 - uint(hash.Step)*2)
+	if d > 0 {
+		y = y + (zz + 1)
+	} else {
+		y = y | zz
+		y = y - (zz + 1)
+	}
+	y &= uint64(0x5555555555555555) >> (64 - uint(hash.Step)*2)
+	hash.Bits = x | y
+}
+
+// neighbors returns the eight cells surrounding hash
+func neighbors(hash Hash) Neighbors {
+	n := Neighbors{
+		North: hash, East: hash, West: hash, South: hash,
+		NorthEast: hash, SouthEast: hash, NorthWest: hash, SouthWest: hash,
+	}
+	moveX(&n.East, 1)
+	moveX(&n.West, -1)
+	moveY(&n.South, -1)
+	moveY(&n.North, 1)
+	moveX(&n.NorthWest, -1)
+	moveY(&n.NorthWest, 1)
+	moveX(&n.SouthWest, -1)
+	moveY(&n.SouthWest, -1)
+	moveX(&n.NorthEast, 1)
+	moveY(&n.NorthEast, 1)
+	moveX(&n.SouthEast, 1)
+	moveY(&n.SouthEast, -1)
+	return n
+}
+
+// ScoreRange is a [Min, Max) interval of sorted set scores
+type ScoreRange struct {
+	Min, Max float64
+}
+
+// SearchRanges returns the score ranges of the cells that must be scanned to
+// find every member inside the shape: the cell containing the center and
+// the neighbors overlapping the shape, in the order Redis scans them
+func (s *Shape) SearchRanges() []ScoreRange {
+	minLon, minLat, maxLon, maxLat := s.boundingBox()
+
+	radiusMeters := s.Radius
+	if s.Box {
+		// Distance from the center to a corner
+		radiusMeters = math.Sqrt((s.Width/2)*(s.Width/2) + (s.Height/2)*(s.Height/2))
+	}
+	radiusMeters *= s.Conversion
+
+	steps := estimateSteps(radiusMeters, s.Latitude)
+	hash, _ := encode(longRange, latRange, s.Longitude, s.Latitude, steps)
+	n := neighbors(hash)
+	area := decode(longRange, latRange, hash)
+
+	// The estimated step may be too fine when the shape is close to the
+	// edge of the cell, so that a neighbor does not cover all of it
+	north := decode(longRange, latRange, n.North)
+	south := decode(longRange, latRange, n.South)
+	east := decode(longRange, latRange, n.East)
+	west := decode(longRange, latRange, n.West)
+	if steps > 1 && (north.Latitude.Max < maxLat || south.Latitude.Min > minLat ||
+		east.Longitude.Max < maxLon || west.Longitude.Min > minLon) {
+		steps--
+		hash, _ = encode(longRange, latRange, s.Longitude, s.Latitude, steps)
+		n = neighbors(hash)
+		area = decode(longRange, latRange, hash)
+	}
+
+	// Skip the neighbors that cannot contain matches
+	if steps >= 2 {
+		if area.Latitude.Min < minLat {
+			n.South, n.SouthWest, n.SouthEast = Hash{}, Hash{}, Hash{}
+		}
+		if area.Latitude.Max > maxLat {
+			n.North, n.NorthEast, n.NorthWest = Hash{}, Hash{}, Hash{}
+		}
+		if area.Longitude.Min < minLon {
+			n.West, n.SouthWest, n.NorthWest = Hash{}, Hash{}, Hash{}
+		}
+		if area.Longitude.Max > maxLon {
+			n.East, n.SouthEast, n.NorthEast = Hash{}, Hash{}, Hash{}
+		}
+	}
+
+	cells := []Hash{hash, n.North, n.South, n.East, n.West, n.NorthEast, n.NorthWest, n.SouthEast, n.SouthWest}
+	ranges := make([]ScoreRange, 0, len(cells))
+	var last *Hash
+	for i := range cells {
+		cell := &cells[i]
+		if cell.IsZero() {
+			continue
+		}
+		// With huge radiuses adjacent neighbors may be the same cell
+		if last != nil && *last == *cell {
+			continue
+		}
+		shift := 52 - uint(cell.Step)*2
+		ranges = append(ranges, ScoreRange{
+			Min: float64(cell.Bits << shift),
+			Max: float64((cell.Bits + 1) << shift),
+		})
+		last = cell
+	}
+	return ranges
+}
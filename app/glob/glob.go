@@ -0,0 +1,103 @@
+// Package glob implements the glob-style patterns used by KEYS, CONFIG GET
+// and the other commands matching names against a pattern.
+package glob
+
+// Match reports whether s matches pattern. The syntax follows Redis:
+// '*' matches any sequence, '?' any single byte, "[...]" a set of bytes
+// with ranges and '^' negation, and '\' escapes the next byte.
+func Match(pattern, s string, nocase bool) bool {
+	return match(pattern, s, nocase, 0)
+}
+
+// maxNesting bounds the recursion on '*' like Redis does, so patterns such
+// as "*a*a*a*..." cannot take exponential time
+const maxNesting = 1000
+
+func lower(c byte, nocase bool) byte {
+	if nocase && c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+func match(pattern, s string, nocase bool, nesting int) bool {
+	if nesting > maxNesting {
+		return false
+	}
+
+	for len(pattern) > 0 && len(s) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i < len(s); i++ {
+				if match(pattern[1:], s[i:], nocase, nesting+1) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			s = s[1:]
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+
+			matched := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						matched = true
+					}
+				case len(pattern) >= 3 && pattern[1] == '-':
+					start, end := lower(pattern[0], nocase), lower(pattern[2], nocase)
+					if start > end {
+						start, end = end, start
+					}
+					c := lower(s[0], nocase)
+					if c >= start && c <= end {
+						matched = true
+					}
+					pattern = pattern[2:]
+				default:
+					if lower(pattern[0], nocase) == lower(s[0], nocase) {
+						matched = true
+					}
+				}
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				// Unterminated set, the pattern ends here
+				return false
+			}
+			if matched == not {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if lower(pattern[0], nocase) != lower(s[0], nocase) {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+
+	for len(pattern) > 0 && pattern[0] == '*' {
+		pattern = pattern[1:]
+	}
+	return len(pattern) == 0 && len(s) == 0
+}
@@ -1,11 +1,11 @@
 package handlers
 
 import (
-	"encoding/hex"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 
-	"github.com/codecrafters-io/redis-starter-go/app/config"
 	"github.com/codecrafters-io/redis-starter-go/app/parser"
 	"github.com/codecrafters-io/redis-starter-go/app/replication"
 )
@@ -39,63 +39,63 @@ func HandleEcho(conn net.Conn, cmd *Command) {
 	fmt.Println("ECHO:", message)
 }
 
-// HandleReplconf handles the REPLCONF command
-func HandleReplconf(conn net.Conn, cmd *Command) {
-	// For now, REPLCONF always responds with +OK regardless of the arguments
-	// In the future, we might want to handle specific REPLCONF subcommands
-	response := "+OK\r\n"
-	_, err := conn.Write([]byte(response))
-	if err != nil {
-		fmt.Println("Failed to write REPLCONF response")
+// HandleSelect handles SELECT index. The server has a single database, so
+// only index 0 is accepted, like a Redis server configured with databases 1.
+// It lets clients such as MIGRATE select the database explicitly.
+func HandleSelect(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 1 {
+		writeResponse(conn, "SELECT", "-ERR wrong number of arguments for 'select' command\r\n")
 		return
 	}
-	fmt.Printf("REPLCONF: %v\n", cmd.Args)
-}
-
-// HandlePsync handles the PSYNC command
-func HandlePsync(conn net.Conn, cmd *Command) {
-	// Get the replication ID and offset from server configuration
-	replId := config.Server.MasterReplId
-	replOffset := config.Server.MasterReplOffset
 
-	// Respond with FULLRESYNC using the actual server configuration
-	response := fmt.Sprintf("+FULLRESYNC %s %d\r\n", replId, replOffset)
-	_, err := conn.Write([]byte(response))
+	index, err := strconv.Atoi(cmd.Args[0])
 	if err != nil {
-		fmt.Println("Failed to write PSYNC response")
+		writeResponse(conn, "SELECT", parser.ToError("ERR value is not an integer or out of range"))
 		return
 	}
-	fmt.Printf("PSYNC: %v -> FULLRESYNC %s %d\n", cmd.Args, replId, replOffset)
-
-	// Send empty RDB file after FULLRESYNC response
-	// This is the hex representation of an empty RDB file
-	emptyRDBHex := "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
-
-	// Decode hex to binary
-	rdbData, err := hex.DecodeString(emptyRDBHex)
-	if err != nil {
-		fmt.Printf("Failed to decode RDB hex: %v\n", err)
+	if index != 0 {
+		writeResponse(conn, "SELECT", parser.ToError("ERR DB index is out of range"))
 		return
 	}
+	writeResponse(conn, "SELECT", parser.ToSimpleString("OK"))
+}
 
-	// Send RDB file in the format: $<length>\r\n<binary_contents>
-	// Note: This is NOT a RESP bulk string, so no trailing \r\n
-	rdbResponse := fmt.Sprintf("$%d\r\n", len(rdbData))
-	_, err = conn.Write([]byte(rdbResponse))
-	if err != nil {
-		fmt.Printf("Failed to write RDB length: %v\n", err)
+// HandleReplconf handles the REPLCONF command. The port a replica announces
+// with listening-port is recorded for INFO, its capabilities announced with
+// capa to choose how to transfer the RDB file, and the offset it
+// acknowledges with ACK for WAIT; ACK gets no reply, like in Redis. Other
+// options are accepted.
+func HandleReplconf(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) == 2 && strings.ToLower(cmd.Args[0]) == "ack" {
+		offset, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+		if err != nil || !replication.Manager.Ack(conn, offset) {
+			fmt.Printf("REPLCONF: ignoring ACK %s from %s\n", cmd.Args[1], conn.RemoteAddr())
+		}
 		return
 	}
 
-	// Send the binary RDB data
-	_, err = conn.Write(rdbData)
+	// Options come in pairs, several of them possibly in one command
+	var capabilities []string
+	for i := 0; i+1 < len(cmd.Args); i += 2 {
+		switch strings.ToLower(cmd.Args[i]) {
+		case "listening-port":
+			port, err := strconv.Atoi(cmd.Args[i+1])
+			if err != nil || port < 0 || port > 65535 {
+				writeResponse(conn, "REPLCONF", parser.ToError("ERR value is not an integer or out of range"))
+				return
+			}
+			replication.Manager.SetListeningPort(conn, port)
+		case "capa":
+			capabilities = append(capabilities, strings.ToLower(cmd.Args[i+1]))
+		}
+	}
+	replication.Manager.SetCapabilities(conn, capabilities)
+
+	response := "+OK\r\n"
+	_, err := conn.Write([]byte(response))
 	if err != nil {
-		fmt.Printf("Failed to write RDB data: %v\n", err)
+		fmt.Println("Failed to write REPLCONF response")
 		return
 	}
-
-	fmt.Printf("Sent empty RDB file (%d bytes)\n", len(rdbData))
-
-	// Register this connection as a replica for command propagation
-	replication.Manager.AddReplica(conn)
+	fmt.Printf("REPLCONF: %v\n", cmd.Args)
 }
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/bitmap"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/repository"
+)
+
+// BitmapHandler handles the bit level string commands
+type BitmapHandler struct {
+	repo repository.KeyValueRepository
+}
+
+// NewBitmapHandler creates a new bitmap handler
+func NewBitmapHandler(repo repository.KeyValueRepository) *BitmapHandler {
+	return &BitmapHandler{
+		repo: repo,
+	}
+}
+
+// parseBitOffset parses a SETBIT/GETBIT offset
+func parseBitOffset(arg string) (uint64, error) {
+	offset, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil || offset >= bitmap.MaxBits {
+		return 0, bitmap.ErrBitOffset
+	}
+	return offset, nil
+}
+
+// parseBitArg parses a bit value argument, which must be 0 or 1
+func parseBitArg(arg string, errMsg string) (int, error) {
+	switch arg {
+	case "0":
+		return 0, nil
+	case "1":
+		return 1, nil
+	}
+	return 0, fmt.Errorf("%s", errMsg)
+}
+
+// parseBitRange parses the "start end [BYTE|BIT]" tail of BITCOUNT and BITPOS
+func parseBitRange(args []string) (start, end int64, bitUnit bool, err error) {
+	start, err = strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	end = -1
+	if len(args) > 1 {
+		end, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("ERR value is not an integer or out of range")
+		}
+	}
+	if len(args) > 2 {
+		switch strings.ToUpper(args[2]) {
+		case "BYTE":
+		case "BIT":
+			bitUnit = true
+		default:
+			return 0, 0, false, fmt.Errorf("ERR syntax error")
+		}
+	}
+	return start, end, bitUnit, nil
+}
+
+// HandleSetBit handles SETBIT key offset value
+func (h *BitmapHandler) HandleSetBit(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 3 {
+		writeResponse(conn, "SETBIT", "-ERR wrong number of arguments for 'setbit' command\r\n")
+		return
+	}
+
+	offset, err := parseBitOffset(cmd.Args[1])
+	if err != nil {
+		writeResponse(conn, "SETBIT", parser.ToError(err.Error()))
+		return
+	}
+	value, err := parseBitArg(cmd.Args[2], "ERR bit is not an integer or out of range")
+	if err != nil {
+		writeResponse(conn, "SETBIT", parser.ToError(err.Error()))
+		return
+	}
+
+	var old int
+	err = h.repo.UpdateString(cmd.Args[0], func(buf []byte, exists bool) ([]byte, error) {
+		buf, old = bitmap.SetBit(buf, offset, value)
+		return buf, nil
+	})
+	if err != nil {
+		writeResponse(conn, "SETBIT", parser.ToError(err.Error()))
+		return
+	}
+
+	if err := propagateCommand("SETBIT", cmd.Args); err != nil {
+		writeResponse(conn, "SETBIT", aofErrorReply(err))
+		return
+	}
+	writeResponse(conn, "SETBIT", parser.ToInteger(old))
+}
+
+// HandleGetBit handles GETBIT key offset
+func (h *BitmapHandler) HandleGetBit(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 2 {
+		writeResponse(conn, "GETBIT", "-ERR wrong number of arguments for 'getbit' command\r\n")
+		return
+	}
+
+	offset, err := parseBitOffset(cmd.Args[1])
+	if err != nil {
+		writeResponse(conn, "GETBIT", parser.ToError(err.Error()))
+		return
+	}
+	buf, _, err := h.repo.GetBytes(cmd.Args[0])
+	if err != nil {
+		writeResponse(conn, "GETBIT", parser.ToError(err.Error()))
+		return
+	}
+
+	writeResponse(conn, "GETBIT", parser.ToInteger(bitmap.GetBit(buf, offset)))
+}
+
+// HandleBitCount handles BITCOUNT key [start end [BYTE|BIT]]
+func (h *BitmapHandler) HandleBitCount(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 1 {
+		writeResponse(conn, "BITCOUNT", "-ERR wrong number of arguments for 'bitcount' command\r\n")
+		return
+	}
+	if len(cmd.Args) == 2 || len(cmd.Args) > 4 {
+		writeResponse(conn, "BITCOUNT", parser.ToError("ERR syntax error"))
+		return
+	}
+
+	var start, end int64 = 0, -1
+	var bitUnit bool
+	if len(cmd.Args) > 1 {
+		var err error
+		start, end, bitUnit, err = parseBitRange(cmd.Args[1:])
+		if err != nil {
+			writeResponse(conn, "BITCOUNT", parser.ToError(err.Error()))
+			return
+		}
+	}
+
+	buf, _, err := h.repo.GetBytes(cmd.Args[0])
+	if err != nil {
+		writeResponse(conn, "BITCOUNT", parser.ToError(err.Error()))
+		return
+	}
+
+	writeResponse(conn, "BITCOUNT", parser.ToInteger(int(bitmap.CountRange(buf, start, end, bitUnit))))
+}
+
+// HandleBitPos handles BITPOS key bit [start [end [BYTE|BIT]]]
+func (h *BitmapHandler) HandleBitPos(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 2 {
+		writeResponse(conn, "BITPOS", "-ERR wrong number of arguments for 'bitpos' command\r\n")
+		return
+	}
+	if len(cmd.Args) > 5 {
+		writeResponse(conn, "BITPOS", parser.ToError("ERR syntax error"))
+		return
+	}
+
+	bit, err := parseBitArg(cmd.Args[1], "ERR The bit argument must be 1 or 0.")
+	if err != nil {
+		writeResponse(conn, "BITPOS", parser.ToError(err.Error()))
+		return
+	}
+
+	var start, end int64 = 0, -1
+	var bitUnit bool
+	if len(cmd.Args) > 2 {
+		start, end, bitUnit, err = parseBitRange(cmd.Args[2:])
+		if err != nil {
+			writeResponse(conn, "BITPOS", parser.ToError(err.Error()))
+			return
+		}
+	}
+
+	buf, exists, err := h.repo.GetBytes(cmd.Args[0])
+	if err != nil {
+		writeResponse(conn, "BITPOS", parser.ToError(err.Error()))
+		return
+	}
+	if !exists {
+		// A missing key is an empty string padded with zeros: a clear bit is
+		// found right away and a set bit never
+		pos := 0
+		if bit == 1 {
+			pos = -1
+		}
+		writeResponse(conn, "BITPOS", parser.ToInteger(pos))
+		return
+	}
+
+	endGiven := len(cmd.Args) > 3
+	pos := bitmap.Pos(buf, bit, start, end, endGiven, bitUnit)
+	writeResponse(conn, "BITPOS", parser.ToInteger(int(pos)))
+}
+
+// HandleBitOp handles BITOP AND|OR|XOR|NOT|DIFF destkey key [key ...]
+func (h *BitmapHandler) HandleBitOp(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 3 {
+		writeResponse(conn, "BITOP", "-ERR wrong number of arguments for 'bitop' command\r\n")
+		return
+	}
+
+	op, err := bitmap.ParseOp(strings.ToUpper(cmd.Args[0]))
+	if err == nil {
+		err = op.CheckSources(len(cmd.Args) - 2)
+	}
+	if err != nil {
+		writeResponse(conn, "BITOP", parser.ToError(err.Error()))
+		return
+	}
+
+	destKey := cmd.Args[1]
+	sources := make([][]byte, 0, len(cmd.Args)-2)
+	for _, key := range cmd.Args[2:] {
+		buf, _, err := h.repo.GetBytes(key)
+		if err != nil {
+			writeResponse(conn, "BITOP", parser.ToError(err.Error()))
+			return
+		}
+		sources = append(sources, buf)
+	}
+
+	result := bitmap.Apply(op, sources)
+	if len(result) == 0 {
+		h.repo.Delete(destKey)
+	} else {
+		h.repo.Set(destKey, string(result), nil)
+	}
+
+	if err := propagateCommand("BITOP", cmd.Args); err != nil {
+		writeResponse(conn, "BITOP", aofErrorReply(err))
+		return
+	}
+	writeResponse(conn, "BITOP", parser.ToInteger(len(result)))
+}
+
+// bitfieldOp is a single GET, SET or INCRBY operation of BITFIELD
+type bitfieldOp struct {
+	name     string
+	ft       bitmap.FieldType
+	offset   uint64
+	value    int64
+	overflow bitmap.Overflow
+}
+
+// parseBitfieldOps parses the operations of BITFIELD and BITFIELD_RO and
+// reports whether any of them writes to the string
+func parseBitfieldOps(args []string, readOnly bool) ([]bitfieldOp, bool, error) {
+	var ops []bitfieldOp
+	var writes bool
+	overflow := bitmap.OverflowWrap
+
+	for i := 0; i < len(args); {
+		name := strings.ToUpper(args[i])
+		if name == "OVERFLOW" {
+			if i+1 >= len(args) {
+				return nil, false, fmt.Errorf("ERR syntax error")
+			}
+			var err error
+			if overflow, err = bitmap.ParseOverflow(args[i+1]); err != nil {
+				return nil, false, err
+			}
+			i += 2
+			continue
+		}
+
+		argc := 0
+		switch name {
+		case "GET":
+			argc = 2
+		case "SET", "INCRBY":
+			argc = 3
+		default:
+			return nil, false, fmt.Errorf("ERR syntax error")
+		}
+		if i+argc >= len(args) {
+			return nil, false, fmt.Errorf("ERR syntax error")
+		}
+		if readOnly && name != "GET" {
+			return nil, false, fmt.Errorf("ERR BITFIELD_RO only supports the GET subcommand")
+		}
+
+		op := bitfieldOp{name: name, overflow: overflow}
+		var err error
+		if op.ft, err = bitmap.ParseFieldType(args[i+1]); err != nil {
+			return nil, false, err
+		}
+		if op.offset, err = bitmap.ParseFieldOffset(args[i+2], op.ft); err != nil {
+			return nil, false, err
+		}
+		if argc == 3 {
+			op.value, err = strconv.ParseInt(args[i+3], 10, 64)
+			if err != nil {
+				return nil, false, fmt.Errorf("ERR value is not an integer or out of range")
+			}
+			writes = true
+		}
+
+		ops = append(ops, op)
+		i += argc + 1
+	}
+	return ops, writes, nil
+}
+
+// execute runs the operation against buf and returns the updated buffer
+// along with the encoded reply
+func (op *bitfieldOp) execute(buf []byte) ([]byte, string) {
+	old := op.ft.Get(buf, op.offset)
+	if op.name == "GET" {
+		return buf, parser.ToInteger(int(old))
+	}
+
+	var newValue, reply int64
+	var overflowed bool
+	if op.name == "INCRBY" {
+		newValue, overflowed = op.ft.CheckOverflow(old, op.value, op.overflow)
+		reply = newValue
+	} else {
+		newValue, overflowed = op.ft.CheckOverflow(0, op.value, op.overflow)
+		reply = old
+	}
+
+	if overflowed && op.overflow == bitmap.OverflowFail {
+		return buf, parser.ToNullBulkString()
+	}
+	return op.ft.Set(buf, op.offset, newValue), parser.ToInteger(int(reply))
+}
+
+// HandleBitField handles BITFIELD key [GET type offset] [SET type offset value]
+// [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL] ...
+func (h *BitmapHandler) HandleBitField(conn net.Conn, cmd *Command) {
+	h.handleBitField(conn, cmd, "BITFIELD", false)
+}
+
+// HandleBitFieldRO handles BITFIELD_RO key [GET type offset ...]
+func (h *BitmapHandler) HandleBitFieldRO(conn net.Conn, cmd *Command) {
+	h.handleBitField(conn, cmd, "BITFIELD_RO", true)
+}
+
+func (h *BitmapHandler) handleBitField(conn net.Conn, cmd *Command, commandName string, readOnly bool) {
+	if len(cmd.Args) < 1 {
+		writeResponse(conn, commandName, "-ERR wrong number of arguments for '"+strings.ToLower(commandName)+"' command\r\n")
+		return
+	}
+
+	ops, writes, err := parseBitfieldOps(cmd.Args[1:], readOnly)
+	if err != nil {
+		writeResponse(conn, commandName, parser.ToError(err.Error()))
+		return
+	}
+
+	replies := make([]string, 0, len(ops))
+	if !writes {
+		buf, _, err := h.repo.GetBytes(cmd.Args[0])
+		if err != nil {
+			writeResponse(conn, commandName, parser.ToError(err.Error()))
+			return
+		}
+		for i := range ops {
+			_, reply := ops[i].execute(buf)
+			replies = append(replies, reply)
+		}
+		writeResponse(conn, commandName, parser.ToArray(replies))
+		return
+	}
+
+	err = h.repo.UpdateString(cmd.Args[0], func(buf []byte, exists bool) ([]byte, error) {
+		if buf == nil {
+			buf = []byte{}
+		}
+		for i := range ops {
+			var reply string
+			buf, reply = ops[i].execute(buf)
+			replies = append(replies, reply)
+		}
+		return buf, nil
+	})
+	if err != nil {
+		writeResponse(conn, commandName, parser.ToError(err.Error()))
+		return
+	}
+
+	if err := propagateCommand(commandName, cmd.Args); err != nil {
+		writeResponse(conn, commandName, aofErrorReply(err))
+		return
+	}
+	writeResponse(conn, commandName, parser.ToArray(replies))
+}
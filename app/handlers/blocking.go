@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// BlockingManager tracks clients blocked on keys (XREAD BLOCK and friends)
+// and wakes them up when one of those keys receives new data
+type BlockingManager struct {
+	waiters map[string]map[chan struct{}]struct{}
+	mu      sync.Mutex
+}
+
+// NewBlockingManager creates a new blocking manager
+func NewBlockingManager() *BlockingManager {
+	return &BlockingManager{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// Register returns a channel that is signalled when any of keys becomes ready
+func (bm *BlockingManager) Register(keys []string) chan struct{} {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	for _, key := range keys {
+		if bm.waiters[key] == nil {
+			bm.waiters[key] = make(map[chan struct{}]struct{})
+		}
+		bm.waiters[key][ch] = struct{}{}
+	}
+	return ch
+}
+
+// Unregister removes a channel previously returned by Register
+func (bm *BlockingManager) Unregister(keys []string, ch chan struct{}) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	for _, key := range keys {
+		delete(bm.waiters[key], ch)
+		if len(bm.waiters[key]) == 0 {
+			delete(bm.waiters, key)
+		}
+	}
+}
+
+// SignalKey wakes up every client blocked on key
+func (bm *BlockingManager) SignalKey(key string) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	for ch := range bm.waiters[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Wait releases the command execution lock while blocking on ch until it is
+// signalled or the timeout expires (0 means no timeout). Returns false on
+// timeout.
+func (bm *BlockingManager) Wait(exec sync.Locker, ch chan struct{}, timeout time.Duration) bool {
+	exec.Unlock()
+	defer exec.Lock()
+
+	if timeout == 0 {
+		<-ch
+		return true
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
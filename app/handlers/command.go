@@ -5,7 +5,9 @@ import (
 	"net"
 	"strings"
 
+	"github.com/codecrafters-io/redis-starter-go/app/config"
 	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/replication"
 )
 
 // Command represents a parsed Redis command
@@ -40,36 +42,55 @@ func ParseCommand(respData string) (*Command, error) {
 	}, nil
 }
 
-// HandleCommand routes commands to appropriate handlers
-func HandleCommand(conn net.Conn, respData string) {
-	cmd, err := ParseCommand(respData)
+// writeResponse writes an encoded RESP response to the client connection
+func writeResponse(conn net.Conn, commandName, response string) {
+	_, err := conn.Write([]byte(response))
 	if err != nil {
-		response := "-ERR " + err.Error() + "\r\n"
-		conn.Write([]byte(response))
-		fmt.Printf("Command parse error: %v\n", err)
-		return
+		fmt.Printf("Failed to write %s response\n", commandName)
 	}
+}
 
-	fmt.Printf("Command: %s, Args: %v\n", cmd.Name, cmd.Args)
+// writeCommands are the commands that may modify the dataset. A read-only
+// replica refuses them from clients, and applies them when its master
+// sends them. Their handlers report every change with propagateCommand,
+// in a deterministic form when needed (XADD with the ID it generated for
+// instance), so replicas and the append-only file end up with the same
+// dataset.
+var writeCommands = map[string]bool{
+	"SET": true, "DEL": true, "RESTORE": true, "MIGRATE": true,
+	"SETBIT": true, "BITOP": true, "BITFIELD": true,
+	"PFADD": true, "PFMERGE": true,
+	"GEOADD": true, "GEOSEARCHSTORE": true,
+	"XADD": true, "XTRIM": true, "XDEL": true, "XGROUP": true,
+	"XREADGROUP": true, "XACK": true, "XCLAIM": true, "XAUTOCLAIM": true,
+}
 
-	switch cmd.Name {
-	case "PING":
-		HandlePing(conn, cmd)
-	case "ECHO":
-		HandleEcho(conn, cmd)
-	case "SET":
-		HandleSet(conn, cmd)
-	case "GET":
-		HandleGet(conn, cmd)
-	case "INFO":
-		HandleInfo(conn, cmd)
-	case "REPLCONF":
-		HandleReplconf(conn, cmd)
-	case "PSYNC":
-		HandlePsync(conn, cmd)
-	default:
-		response := "-ERR unknown command '" + cmd.Name + "'\r\n"
-		conn.Write([]byte(response))
-		fmt.Printf("Unknown command: %s\n", cmd.Name)
+// propagateCommand records a write command for the save points, logs it to
+// the append-only file when enabled and forwards it to replicas if this
+// server is a master. It is the single hook through which every write
+// leaves the server, and handlers call it before replying: with appendfsync
+// always, a client is only acknowledged once its write is on disk. The
+// error returned is then the failure to log it, which the client is to get
+// instead of the reply, as aofErrorReply formats it. With the other
+// policies a failure is only reported in the log.
+func propagateCommand(commandName string, args []string) error {
+	markDirty()
+	var logErr error
+	if appendOnly != nil {
+		if err := appendOnly.Append(commandName, args); err != nil {
+			fmt.Printf("Failed to write %s to the append only file: %v\n", commandName, err)
+			if config.Server.AppendFsync == "always" {
+				logErr = err
+			}
+		}
+	}
+	if config.IsServerMaster() {
+		replication.Manager.PropagateCommand(commandName, args)
 	}
+	return logErr
+}
+
+// aofErrorReply is the reply to a write the append-only file failed to log
+func aofErrorReply(err error) string {
+	return parser.ToError("MISCONF Errors writing to the AOF file: " + err.Error())
 }
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/glob"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+)
+
+// configParameter is a parameter exposed through CONFIG GET
+type configParameter struct {
+	name string
+	get  func() string
+}
+
+// configParameters lists the readable parameters in the order they are returned
+var configParameters = []configParameter{
+	{"dir", func() string { return config.Server.Dir }},
+	{"dbfilename", func() string { return config.Server.DBFilename }},
+	{"save", func() string { return config.FormatSaveRules(config.Server.SaveRules) }},
+	{"appendonly", func() string { return yesNo(config.Server.AppendOnly) }},
+	{"appendfilename", func() string { return config.Server.AppendFilename }},
+	{"appenddirname", func() string { return config.Server.AppendDirname }},
+	{"appendfsync", func() string { return config.Server.AppendFsync }},
+	{"aof-load-truncated", func() string { return yesNo(config.Server.AOFLoadTruncated) }},
+	{"repl-backlog-size", func() string { return strconv.Itoa(config.Server.ReplBacklogSize) }},
+	{"repl-backlog-ttl", func() string { return strconv.Itoa(config.Server.ReplBacklogTTL) }},
+	{"repl-timeout", func() string { return strconv.Itoa(config.Server.ReplTimeout) }},
+	{"repl-ping-replica-period", func() string { return strconv.Itoa(config.Server.ReplPingReplicaPeriod) }},
+	{"replica-read-only", func() string { return yesNo(config.Server.ReplicaReadOnly) }},
+	{"repl-diskless-sync", func() string { return yesNo(config.Server.ReplDisklessSync) }},
+	{"repl-diskless-sync-delay", func() string { return strconv.Itoa(config.Server.ReplDisklessSyncDelay) }},
+	{"repl-diskless-load", func() string { return config.Server.ReplDisklessLoad }},
+	{"client-output-buffer-limit", func() string {
+		return config.FormatReplicaOutputBufferLimit(config.Server.ReplicaOutputBufferLimit)
+	}},
+}
+
+// HandleConfig handles the CONFIG command. Only the GET subcommand is
+// supported; every argument is a glob-style pattern.
+func HandleConfig(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 1 {
+		writeResponse(conn, "CONFIG", "-ERR wrong number of arguments for 'config' command\r\n")
+		return
+	}
+
+	switch strings.ToUpper(cmd.Args[0]) {
+	case "GET":
+		if len(cmd.Args) < 2 {
+			writeResponse(conn, "CONFIG", "-ERR wrong number of arguments for 'config|get' command\r\n")
+			return
+		}
+
+		var items []string
+		for _, param := range configParameters {
+			for _, pattern := range cmd.Args[1:] {
+				if glob.Match(pattern, param.name, true) {
+					items = append(items, param.name, param.get())
+					break
+				}
+			}
+		}
+		writeResponse(conn, "CONFIG", parser.ToBulkStringArray(items))
+	default:
+		writeResponse(conn, "CONFIG", parser.ToError("ERR unknown subcommand '"+cmd.Args[0]+"'. Try CONFIG HELP."))
+	}
+}
+
+// yesNo formats a boolean parameter the way Redis does
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
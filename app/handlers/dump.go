@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// HandleDump handles DUMP key, returning the value serialized in the RDB
+// format used by Redis so it can be restored on either server
+func (h *DataHandler) HandleDump(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 1 {
+		writeResponse(conn, "DUMP", "-ERR wrong number of arguments for 'dump' command\r\n")
+		return
+	}
+
+	kv, exists := h.repo.Lookup(cmd.Args[0])
+	if !exists {
+		writeResponse(conn, "DUMP", parser.ToNullBulkString())
+		return
+	}
+	payload, err := rdb.Dump(kv.Value)
+	if err != nil {
+		writeResponse(conn, "DUMP", parser.ToError("ERR "+err.Error()))
+		return
+	}
+	writeResponse(conn, "DUMP", parser.ToBulkString(string(payload)))
+}
+
+// HandleRestore handles RESTORE key ttl payload [REPLACE] [ABSTTL]
+// [IDLETIME seconds] [FREQ frequency]. The server keeps no LRU or LFU
+// data, so IDLETIME and FREQ are validated and ignored.
+func (h *DataHandler) HandleRestore(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 3 {
+		writeResponse(conn, "RESTORE", "-ERR wrong number of arguments for 'restore' command\r\n")
+		return
+	}
+	key, payload := cmd.Args[0], cmd.Args[2]
+
+	replace, absTTL := false, false
+	idle, freq := int64(-1), int64(-1)
+	for i := 3; i < len(cmd.Args); i++ {
+		switch option := strings.ToUpper(cmd.Args[i]); {
+		case option == "REPLACE":
+			replace = true
+		case option == "ABSTTL":
+			absTTL = true
+		case option == "IDLETIME" && i+1 < len(cmd.Args) && freq == -1:
+			i++
+			value, err := strconv.ParseInt(cmd.Args[i], 10, 64)
+			if err != nil {
+				writeResponse(conn, "RESTORE", parser.ToError("ERR value is not an integer or out of range"))
+				return
+			}
+			if value < 0 {
+				writeResponse(conn, "RESTORE", parser.ToError("ERR Invalid IDLETIME value, must be >= 0"))
+				return
+			}
+			idle = value
+		case option == "FREQ" && i+1 < len(cmd.Args) && idle == -1:
+			i++
+			value, err := strconv.ParseInt(cmd.Args[i], 10, 64)
+			if err != nil {
+				writeResponse(conn, "RESTORE", parser.ToError("ERR value is not an integer or out of range"))
+				return
+			}
+			if value < 0 || value > 255 {
+				writeResponse(conn, "RESTORE", parser.ToError("ERR Invalid FREQ value, must be >= 0 and <= 255"))
+				return
+			}
+			freq = value
+		default:
+			writeResponse(conn, "RESTORE", parser.ToError("ERR syntax error"))
+			return
+		}
+	}
+
+	ttl, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil {
+		writeResponse(conn, "RESTORE", parser.ToError("ERR value is not an integer or out of range"))
+		return
+	}
+	if ttl < 0 {
+		writeResponse(conn, "RESTORE", parser.ToError("ERR Invalid TTL value, must be >= 0"))
+		return
+	}
+
+	if !replace && h.repo.Exists(key) {
+		writeResponse(conn, "RESTORE", parser.ToError("BUSYKEY Target key name already exists."))
+		return
+	}
+
+	value, err := rdb.Undump([]byte(payload))
+	if err == rdb.ErrDumpPayload {
+		writeResponse(conn, "RESTORE", parser.ToError("ERR "+err.Error()))
+		return
+	}
+	if err != nil {
+		fmt.Printf("RESTORE: invalid payload for %q: %v\n", key, err)
+		writeResponse(conn, "RESTORE", parser.ToError("ERR Bad data format"))
+		return
+	}
+	switch value.(type) {
+	case string, *storage.SortedSet, *storage.Stream:
+	default:
+		writeResponse(conn, "RESTORE", parser.ToError(fmt.Sprintf("ERR Bad data format: values of type %T are not supported", value)))
+		return
+	}
+
+	var expiresAt *time.Time
+	if ttl > 0 {
+		at := time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		if absTTL {
+			at = time.UnixMilli(ttl)
+		}
+		expiresAt = &at
+	}
+
+	// A key restored already expired is not stored, like in Redis, but it
+	// still replaces the previous value
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		if h.repo.Exists(key) {
+			h.repo.Delete(key)
+		} else {
+			writeResponse(conn, "RESTORE", parser.ToSimpleString("OK"))
+			return
+		}
+	} else if err := h.repo.Restore(key, value, expiresAt); err != nil {
+		writeResponse(conn, "RESTORE", parser.ToError("ERR "+err.Error()))
+		return
+	}
+	// Replicas and the append-only file get the absolute expiration, so a
+	// replayed RESTORE doesn't extend the key's life
+	expireAt := "0"
+	if expiresAt != nil {
+		expireAt = strconv.FormatInt(expiresAt.UnixMilli(), 10)
+	}
+	if err := propagateCommand("RESTORE", []string{key, expireAt, payload, "REPLACE", "ABSTTL"}); err != nil {
+		writeResponse(conn, "RESTORE", aofErrorReply(err))
+		return
+	}
+	writeResponse(conn, "RESTORE", parser.ToSimpleString("OK"))
+}
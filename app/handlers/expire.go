@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// The active expire cycle runs every expireCycleInterval. It checks
+// samples of expireCycleSample keys having an expiration and goes on while
+// more than a quarter of them expired, for at most expireCycleBudget.
+const (
+	expireCycleInterval = 100 * time.Millisecond
+	expireCycleSample   = 20
+	expireCycleBudget   = 25 * time.Millisecond
+)
+
+// applyingMaster is set while a replica applies a command received from
+// its master, under the execution lock
+var applyingMaster bool
+
+// expireKey decides the fate of a key found expired, by a command or by
+// the active expire cycle. A master deletes it and propagates DEL, so the
+// replicas and the append-only file delete it at the same point of the
+// stream. A replica only hides it from its clients until the DEL of its
+// master arrives, so both never disagree on the dataset: the commands of
+// its master still see the key, whatever the clock of the replica says.
+// The caller holds the execution lock.
+func expireKey(key string) storage.ExpireAction {
+	if !config.IsServerMaster() {
+		if applyingMaster {
+			return storage.ExpireKeep
+		}
+		return storage.ExpireHide
+	}
+	propagateCommand("DEL", []string{key})
+	return storage.ExpireDelete
+}
+
+// RunExpireCycle deletes expired keys nobody accesses in the background,
+// like Redis' active expiration. Replicas leave it to their master. It
+// never returns.
+func (hm *HandlerManager) RunExpireCycle() {
+	ticker := time.NewTicker(expireCycleInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		hm.mu.Lock()
+		if config.IsServerMaster() {
+			start := time.Now()
+			for time.Since(start) < expireCycleBudget {
+				checked, expired := hm.dataHandler.repo.ExpireKeys(expireCycleSample)
+				if checked == 0 || expired*4 <= checked {
+					break
+				}
+			}
+		}
+		hm.mu.Unlock()
+	}
+}
@@ -0,0 +1,528 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/geo"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/repository"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// GeoHandler handles the geospatial commands. Positions are stored in sorted
+// sets with their 52 bit geohash as score.
+type GeoHandler struct {
+	repo repository.KeyValueRepository
+}
+
+// NewGeoHandler creates a new geo handler
+func NewGeoHandler(repo repository.KeyValueRepository) *GeoHandler {
+	return &GeoHandler{
+		repo: repo,
+	}
+}
+
+// parseFloatArg parses a floating point argument, rejecting NaN
+func parseFloatArg(arg, errMsg string) (float64, error) {
+	value, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(value) {
+		return 0, fmt.Errorf("%s", errMsg)
+	}
+	return value, nil
+}
+
+// parseLonLat parses a longitude, latitude pair and validates its range
+func parseLonLat(lonArg, latArg string) (float64, float64, error) {
+	longitude, err := parseFloatArg(lonArg, "ERR value is not a valid float")
+	if err != nil {
+		return 0, 0, err
+	}
+	latitude, err := parseFloatArg(latArg, "ERR value is not a valid float")
+	if err != nil {
+		return 0, 0, err
+	}
+	if !geo.ValidCoordinates(longitude, latitude) {
+		return 0, 0, fmt.Errorf("ERR invalid longitude,latitude pair %s,%s",
+			strconv.FormatFloat(longitude, 'f', 6, 64), strconv.FormatFloat(latitude, 'f', 6, 64))
+	}
+	return longitude, latitude, nil
+}
+
+// parseUnit returns the number of meters in a distance unit
+func parseUnit(arg string) (float64, error) {
+	switch strings.ToLower(arg) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, fmt.Errorf("ERR unsupported unit provided. please use M, KM, FT, MI")
+}
+
+// formatCoordinate formats a coordinate like Redis' human friendly long
+// double replies: 17 decimals without trailing zeros
+func formatCoordinate(value float64) string {
+	s := strconv.FormatFloat(value, 'f', 17, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// formatDistance formats a distance with 4 decimals
+func formatDistance(value float64) string {
+	return strconv.FormatFloat(value, 'f', 4, 64)
+}
+
+// HandleGeoAdd handles GEOADD key [NX|XX] [CH] longitude latitude member [...]
+func (h *GeoHandler) HandleGeoAdd(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 4 {
+		writeResponse(conn, "GEOADD", "-ERR wrong number of arguments for 'geoadd' command\r\n")
+		return
+	}
+
+	var nx, xx, ch bool
+	i := 1
+	for ; i < len(cmd.Args); i++ {
+		switch strings.ToUpper(cmd.Args[i]) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		case "CH":
+			ch = true
+			continue
+		}
+		break
+	}
+	if (len(cmd.Args)-i)%3 != 0 || i == len(cmd.Args) {
+		writeResponse(conn, "GEOADD", parser.ToError("ERR syntax error"))
+		return
+	}
+	if nx && xx {
+		writeResponse(conn, "GEOADD", parser.ToError("ERR XX and NX options at the same time are not compatible"))
+		return
+	}
+
+	type position struct {
+		member string
+		score  float64
+	}
+	positions := make([]position, 0, (len(cmd.Args)-i)/3)
+	for ; i < len(cmd.Args); i += 3 {
+		longitude, latitude, err := parseLonLat(cmd.Args[i], cmd.Args[i+1])
+		if err != nil {
+			writeResponse(conn, "GEOADD", parser.ToError(err.Error()))
+			return
+		}
+		score, _ := geo.Encode(longitude, latitude)
+		positions = append(positions, position{member: cmd.Args[i+2], score: score})
+	}
+
+	zset, err := h.repo.GetSortedSet(cmd.Args[0], false)
+	if err != nil {
+		writeResponse(conn, "GEOADD", parser.ToError(err.Error()))
+		return
+	}
+	if zset == nil && xx {
+		writeResponse(conn, "GEOADD", parser.ToInteger(0))
+		return
+	}
+	if zset == nil {
+		zset, _ = h.repo.GetSortedSet(cmd.Args[0], true)
+	}
+
+	added, changed := 0, 0
+	for _, pos := range positions {
+		old, exists := zset.Score(pos.member)
+		if (exists && nx) || (!exists && xx) {
+			continue
+		}
+		if !exists {
+			added++
+		} else if old != pos.score {
+			changed++
+		}
+		zset.Add(pos.member, pos.score)
+	}
+
+	if added+changed > 0 {
+		if err := propagateCommand("GEOADD", cmd.Args); err != nil {
+			writeResponse(conn, "GEOADD", aofErrorReply(err))
+			return
+		}
+	}
+	if ch {
+		writeResponse(conn, "GEOADD", parser.ToInteger(added+changed))
+	} else {
+		writeResponse(conn, "GEOADD", parser.ToInteger(added))
+	}
+}
+
+// HandleGeoPos handles GEOPOS key [member ...]
+func (h *GeoHandler) HandleGeoPos(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 1 {
+		writeResponse(conn, "GEOPOS", "-ERR wrong number of arguments for 'geopos' command\r\n")
+		return
+	}
+
+	zset, err := h.repo.GetSortedSet(cmd.Args[0], false)
+	if err != nil {
+		writeResponse(conn, "GEOPOS", parser.ToError(err.Error()))
+		return
+	}
+
+	replies := make([]string, 0, len(cmd.Args)-1)
+	for _, member := range cmd.Args[1:] {
+		score, exists := lookupScore(zset, member)
+		if !exists {
+			replies = append(replies, parser.ToNullArray())
+			continue
+		}
+		longitude, latitude := geo.Decode(score)
+		replies = append(replies, parser.ToBulkStringArray([]string{
+			formatCoordinate(longitude), formatCoordinate(latitude),
+		}))
+	}
+	writeResponse(conn, "GEOPOS", parser.ToArray(replies))
+}
+
+// lookupScore returns the score of member, treating a nil set as empty
+func lookupScore(zset *storage.SortedSet, member string) (float64, bool) {
+	if zset == nil {
+		return 0, false
+	}
+	return zset.Score(member)
+}
+
+// HandleGeoDist handles GEODIST key member1 member2 [M|KM|FT|MI]
+func (h *GeoHandler) HandleGeoDist(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 3 || len(cmd.Args) > 4 {
+		writeResponse(conn, "GEODIST", "-ERR wrong number of arguments for 'geodist' command\r\n")
+		return
+	}
+
+	conversion := 1.0
+	if len(cmd.Args) == 4 {
+		var err error
+		if conversion, err = parseUnit(cmd.Args[3]); err != nil {
+			writeResponse(conn, "GEODIST", parser.ToError(err.Error()))
+			return
+		}
+	}
+
+	zset, err := h.repo.GetSortedSet(cmd.Args[0], false)
+	if err != nil {
+		writeResponse(conn, "GEODIST", parser.ToError(err.Error()))
+		return
+	}
+	score1, exists1 := lookupScore(zset, cmd.Args[1])
+	score2, exists2 := lookupScore(zset, cmd.Args[2])
+	if !exists1 || !exists2 {
+		writeResponse(conn, "GEODIST", parser.ToNullBulkString())
+		return
+	}
+
+	lon1, lat1 := geo.Decode(score1)
+	lon2, lat2 := geo.Decode(score2)
+	distance := geo.Distance(lon1, lat1, lon2, lat2) / conversion
+	writeResponse(conn, "GEODIST", parser.ToBulkString(formatDistance(distance)))
+}
+
+// HandleGeoHash handles GEOHASH key [member ...]
+func (h *GeoHandler) HandleGeoHash(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 1 {
+		writeResponse(conn, "GEOHASH", "-ERR wrong number of arguments for 'geohash' command\r\n")
+		return
+	}
+
+	zset, err := h.repo.GetSortedSet(cmd.Args[0], false)
+	if err != nil {
+		writeResponse(conn, "GEOHASH", parser.ToError(err.Error()))
+		return
+	}
+
+	replies := make([]string, 0, len(cmd.Args)-1)
+	for _, member := range cmd.Args[1:] {
+		score, exists := lookupScore(zset, member)
+		if !exists {
+			replies = append(replies, parser.ToNullBulkString())
+			continue
+		}
+		replies = append(replies, parser.ToBulkString(geo.StandardHash(score)))
+	}
+	writeResponse(conn, "GEOHASH", parser.ToArray(replies))
+}
+
+// geoSearchArgs holds the options of GEOSEARCH and GEOSEARCHSTORE
+type geoSearchArgs struct {
+	fromMember string
+	shape      geo.Shape
+	frommember bool
+	fromlonlat bool
+	byradius   bool
+	bybox      bool
+	sort       int // 0 unsorted, 1 ascending, -1 descending
+	count      int64
+	any        bool
+	withDist   bool
+	withHash   bool
+	withCoord  bool
+	storeDist  bool
+}
+
+// parseGeoSearchArgs parses the options following the source key
+func parseGeoSearchArgs(commandName string, args []string, store bool) (*geoSearchArgs, error) {
+	sa := &geoSearchArgs{}
+	syntaxErr := fmt.Errorf("ERR syntax error")
+
+	for i := 0; i < len(args); i++ {
+		remaining := len(args) - i - 1
+		switch arg := strings.ToUpper(args[i]); {
+		case arg == "WITHDIST" && !store:
+			sa.withDist = true
+		case arg == "WITHHASH" && !store:
+			sa.withHash = true
+		case arg == "WITHCOORD" && !store:
+			sa.withCoord = true
+		case arg == "STOREDIST" && store:
+			sa.storeDist = true
+		case arg == "ANY":
+			sa.any = true
+		case arg == "ASC":
+			sa.sort = 1
+		case arg == "DESC":
+			sa.sort = -1
+		case arg == "COUNT" && remaining >= 1:
+			count, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("ERR value is not an integer or out of range")
+			}
+			if count <= 0 {
+				return nil, fmt.Errorf("ERR COUNT must be > 0")
+			}
+			sa.count = count
+			i++
+		case arg == "FROMMEMBER" && remaining >= 1 && !sa.fromlonlat:
+			sa.fromMember = args[i+1]
+			sa.frommember = true
+			i++
+		case arg == "FROMLONLAT" && remaining >= 2 && !sa.frommember:
+			longitude, latitude, err := parseLonLat(args[i+1], args[i+2])
+			if err != nil {
+				return nil, err
+			}
+			sa.shape.Longitude, sa.shape.Latitude = longitude, latitude
+			sa.fromlonlat = true
+			i += 2
+		case arg == "BYRADIUS" && remaining >= 2 && !sa.bybox:
+			radius, err := parseFloatArg(args[i+1], "ERR need numeric radius")
+			if err != nil {
+				return nil, err
+			}
+			if radius < 0 {
+				return nil, fmt.Errorf("ERR radius cannot be negative")
+			}
+			if sa.shape.Conversion, err = parseUnit(args[i+2]); err != nil {
+				return nil, err
+			}
+			sa.shape.Radius = radius
+			sa.byradius = true
+			i += 2
+		case arg == "BYBOX" && remaining >= 3 && !sa.byradius:
+			width, err := parseFloatArg(args[i+1], "ERR need numeric width")
+			if err != nil {
+				return nil, err
+			}
+			height, err := parseFloatArg(args[i+2], "ERR need numeric height")
+			if err != nil {
+				return nil, err
+			}
+			if width < 0 || height < 0 {
+				return nil, fmt.Errorf("ERR height or width cannot be negative")
+			}
+			if sa.shape.Conversion, err = parseUnit(args[i+3]); err != nil {
+				return nil, err
+			}
+			sa.shape.Box = true
+			sa.shape.Width, sa.shape.Height = width, height
+			sa.bybox = true
+			i += 3
+		default:
+			return nil, syntaxErr
+		}
+	}
+
+	if !sa.frommember && !sa.fromlonlat {
+		return nil, fmt.Errorf("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for %s", strings.ToLower(commandName))
+	}
+	if !sa.byradius && !sa.bybox {
+		return nil, fmt.Errorf("ERR exactly one of BYRADIUS and BYBOX can be specified for %s", strings.ToLower(commandName))
+	}
+	if sa.any && sa.count == 0 {
+		return nil, fmt.Errorf("ERR the ANY argument requires COUNT argument")
+	}
+	// Returning the closest N members requires sorting them
+	if sa.count != 0 && sa.sort == 0 && !sa.any {
+		sa.sort = 1
+	}
+	return sa, nil
+}
+
+// geoPoint is a member matched by a search
+type geoPoint struct {
+	member    string
+	score     float64
+	distance  float64
+	longitude float64
+	latitude  float64
+}
+
+// search returns the members of zset inside the shape, scanning the cells
+// covering it like Redis. With ANY the scan stops after count matches.
+func (sa *geoSearchArgs) search(zset *storage.SortedSet) []geoPoint {
+	var limit int
+	if sa.any {
+		limit = int(sa.count)
+	}
+
+	var points []geoPoint
+	for _, r := range sa.shape.SearchRanges() {
+		if limit > 0 && len(points) >= limit {
+			break
+		}
+		zset.RangeByScore(r.Min, r.Max, false, func(member string, score float64) bool {
+			longitude, latitude := geo.Decode(score)
+			if distance, ok := sa.shape.Contains(longitude, latitude); ok {
+				points = append(points, geoPoint{member, score, distance, longitude, latitude})
+			}
+			return limit == 0 || len(points) < limit
+		})
+	}
+
+	switch sa.sort {
+	case 1:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].distance < points[j].distance })
+	case -1:
+		sort.SliceStable(points, func(i, j int) bool { return points[i].distance > points[j].distance })
+	}
+	if sa.count > 0 && int64(len(points)) > sa.count {
+		points = points[:sa.count]
+	}
+	return points
+}
+
+// HandleGeoSearch handles GEOSEARCH key FROMMEMBER member|FROMLONLAT lon lat
+// BYRADIUS radius unit|BYBOX width height unit [ASC|DESC] [COUNT count [ANY]]
+// [WITHCOORD] [WITHDIST] [WITHHASH]
+func (h *GeoHandler) HandleGeoSearch(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 6 {
+		writeResponse(conn, "GEOSEARCH", "-ERR wrong number of arguments for 'geosearch' command\r\n")
+		return
+	}
+	h.geoSearch(conn, cmd, "GEOSEARCH", "", cmd.Args[0], cmd.Args[1:])
+}
+
+// HandleGeoSearchStore handles GEOSEARCHSTORE destination source ... [STOREDIST]
+func (h *GeoHandler) HandleGeoSearchStore(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 7 {
+		writeResponse(conn, "GEOSEARCHSTORE", "-ERR wrong number of arguments for 'geosearchstore' command\r\n")
+		return
+	}
+	h.geoSearch(conn, cmd, "GEOSEARCHSTORE", cmd.Args[0], cmd.Args[1], cmd.Args[2:])
+}
+
+// geoSearch runs a search and either replies with the matches or stores them
+// in destKey when it is not empty
+func (h *GeoHandler) geoSearch(conn net.Conn, cmd *Command, commandName, destKey, srcKey string, args []string) {
+	store := commandName == "GEOSEARCHSTORE"
+
+	zset, err := h.repo.GetSortedSet(srcKey, false)
+	if err != nil {
+		writeResponse(conn, commandName, parser.ToError(err.Error()))
+		return
+	}
+	sa, err := parseGeoSearchArgs(commandName, args, store)
+	if err != nil {
+		writeResponse(conn, commandName, parser.ToError(err.Error()))
+		return
+	}
+
+	if zset == nil {
+		if store {
+			h.repo.Delete(destKey)
+			if err := propagateCommand(commandName, cmd.Args); err != nil {
+				writeResponse(conn, commandName, aofErrorReply(err))
+				return
+			}
+			writeResponse(conn, commandName, parser.ToInteger(0))
+		} else {
+			writeResponse(conn, commandName, parser.ToArray(nil))
+		}
+		return
+	}
+
+	if sa.frommember {
+		score, exists := zset.Score(sa.fromMember)
+		if !exists {
+			writeResponse(conn, commandName, parser.ToError("ERR could not decode requested zset member"))
+			return
+		}
+		sa.shape.Longitude, sa.shape.Latitude = geo.Decode(score)
+	}
+
+	points := sa.search(zset)
+
+	if store {
+		if len(points) == 0 {
+			h.repo.Delete(destKey)
+		} else {
+			result := storage.NewSortedSet()
+			for _, p := range points {
+				score := p.score
+				if sa.storeDist {
+					score = p.distance / sa.shape.Conversion
+				}
+				result.Add(p.member, score)
+			}
+			h.repo.SetSortedSet(destKey, result)
+		}
+		if err := propagateCommand(commandName, cmd.Args); err != nil {
+			writeResponse(conn, commandName, aofErrorReply(err))
+			return
+		}
+		writeResponse(conn, commandName, parser.ToInteger(len(points)))
+		return
+	}
+
+	replies := make([]string, 0, len(points))
+	for _, p := range points {
+		if !sa.withDist && !sa.withHash && !sa.withCoord {
+			replies = append(replies, parser.ToBulkString(p.member))
+			continue
+		}
+		item := []string{parser.ToBulkString(p.member)}
+		if sa.withDist {
+			item = append(item, parser.ToBulkString(formatDistance(p.distance/sa.shape.Conversion)))
+		}
+		if sa.withHash {
+			item = append(item, parser.ToInteger(int(p.score)))
+		}
+		if sa.withCoord {
+			item = append(item, parser.ToBulkStringArray([]string{
+				formatCoordinate(p.longitude), formatCoordinate(p.latitude),
+			}))
+		}
+		replies = append(replies, parser.ToArray(item))
+	}
+	writeResponse(conn, commandName, parser.ToArray(replies))
+}
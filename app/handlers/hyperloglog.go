@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"net"
+
+	"github.com/codecrafters-io/redis-starter-go/app/hyperloglog"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/repository"
+)
+
+// HyperLogLogHandler handles the PF* commands operating on HyperLogLog strings
+type HyperLogLogHandler struct {
+	repo repository.KeyValueRepository
+}
+
+// NewHyperLogLogHandler creates a new HyperLogLog handler
+func NewHyperLogLogHandler(repo repository.KeyValueRepository) *HyperLogLogHandler {
+	return &HyperLogLogHandler{
+		repo: repo,
+	}
+}
+
+// lookupRegisters returns the registers of the HyperLogLog stored at key.
+// dense reports whether it uses the dense representation.
+func (h *HyperLogLogHandler) lookupRegisters(key string) (regs []uint8, dense, exists bool, err error) {
+	buf, exists, err := h.repo.GetBytes(key)
+	if err != nil || !exists {
+		return nil, false, exists, err
+	}
+	regs, err = hyperloglog.Decode(buf)
+	if err != nil {
+		return nil, false, true, err
+	}
+	return regs, buf[4] == hyperloglog.EncodingDense, true, nil
+}
+
+// HandlePFAdd handles PFADD key [element [element ...]]
+func (h *HyperLogLogHandler) HandlePFAdd(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 1 {
+		writeResponse(conn, "PFADD", "-ERR wrong number of arguments for 'pfadd' command\r\n")
+		return
+	}
+
+	var changed bool
+	err := h.repo.UpdateString(cmd.Args[0], func(buf []byte, exists bool) ([]byte, error) {
+		if !exists {
+			buf = hyperloglog.New()
+		}
+		buf, added, err := hyperloglog.Add(buf, cmd.Args[1:])
+		changed = added || !exists
+		return buf, err
+	})
+	if err != nil {
+		writeResponse(conn, "PFADD", parser.ToError(err.Error()))
+		return
+	}
+
+	if !changed {
+		writeResponse(conn, "PFADD", parser.ToInteger(0))
+		return
+	}
+	if err := propagateCommand("PFADD", cmd.Args); err != nil {
+		writeResponse(conn, "PFADD", aofErrorReply(err))
+		return
+	}
+	writeResponse(conn, "PFADD", parser.ToInteger(1))
+}
+
+// HandlePFCount handles PFCOUNT key [key ...]. Multiple keys are counted as
+// the union of their HyperLogLogs.
+func (h *HyperLogLogHandler) HandlePFCount(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 1 {
+		writeResponse(conn, "PFCOUNT", "-ERR wrong number of arguments for 'pfcount' command\r\n")
+		return
+	}
+
+	if len(cmd.Args) > 1 {
+		union := make([]uint8, hyperloglog.Registers)
+		for _, key := range cmd.Args {
+			regs, _, exists, err := h.lookupRegisters(key)
+			if err != nil {
+				writeResponse(conn, "PFCOUNT", parser.ToError(err.Error()))
+				return
+			}
+			if exists {
+				hyperloglog.Merge(union, regs)
+			}
+		}
+		writeResponse(conn, "PFCOUNT", parser.ToInteger(int(hyperloglog.Estimate(union))))
+		return
+	}
+
+	key := cmd.Args[0]
+	if _, exists, err := h.repo.GetBytes(key); err != nil || !exists {
+		if err != nil {
+			writeResponse(conn, "PFCOUNT", parser.ToError(err.Error()))
+		} else {
+			writeResponse(conn, "PFCOUNT", parser.ToInteger(0))
+		}
+		return
+	}
+
+	// Counting a single key refreshes the cardinality cached in its header
+	var count uint64
+	err := h.repo.UpdateString(key, func(buf []byte, exists bool) ([]byte, error) {
+		var err error
+		count, err = hyperloglog.Count(buf)
+		return buf, err
+	})
+	if err != nil {
+		writeResponse(conn, "PFCOUNT", parser.ToError(err.Error()))
+		return
+	}
+	writeResponse(conn, "PFCOUNT", parser.ToInteger(int(count)))
+}
+
+// HandlePFMerge handles PFMERGE destkey [sourcekey [sourcekey ...]]
+func (h *HyperLogLogHandler) HandlePFMerge(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 1 {
+		writeResponse(conn, "PFMERGE", "-ERR wrong number of arguments for 'pfmerge' command\r\n")
+		return
+	}
+
+	// The destination takes part in the union and the result stays dense
+	// when any of the inputs is
+	merged := make([]uint8, hyperloglog.Registers)
+	useDense := false
+	for _, key := range cmd.Args {
+		regs, dense, exists, err := h.lookupRegisters(key)
+		if err != nil {
+			writeResponse(conn, "PFMERGE", parser.ToError(err.Error()))
+			return
+		}
+		if exists {
+			hyperloglog.Merge(merged, regs)
+			useDense = useDense || dense
+		}
+	}
+
+	err := h.repo.UpdateString(cmd.Args[0], func(buf []byte, exists bool) ([]byte, error) {
+		return hyperloglog.Encode(merged, useDense), nil
+	})
+	if err != nil {
+		writeResponse(conn, "PFMERGE", parser.ToError(err.Error()))
+		return
+	}
+
+	if err := propagateCommand("PFMERGE", cmd.Args); err != nil {
+		writeResponse(conn, "PFMERGE", aofErrorReply(err))
+		return
+	}
+	writeResponse(conn, "PFMERGE", parser.ToSimpleString("OK"))
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/codecrafters-io/redis-starter-go/app/config"
 	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/replication"
 )
 
 // HandleInfo handles the INFO command
@@ -22,23 +23,11 @@ func HandleInfo(conn net.Conn, cmd *Command) {
 
 	switch section {
 	case "replication":
-		// Return replication information with master_replid and master_repl_offset
-		if config.IsServerMaster() {
-			infoContent = fmt.Sprintf("role:%s\nmaster_replid:%s\nmaster_repl_offset:%d",
-				role, config.Server.MasterReplId, config.Server.MasterReplOffset)
-		} else {
-			// For slaves, just return the role for now
-			infoContent = "role:" + role
-		}
+		infoContent = replicationInfo()
 	case "all":
 		// For now, just return replication info for "all" as well
 		// In a full implementation, this would include all sections
-		if config.IsServerMaster() {
-			infoContent = fmt.Sprintf("# Replication\nrole:%s\nmaster_replid:%s\nmaster_repl_offset:%d",
-				role, config.Server.MasterReplId, config.Server.MasterReplOffset)
-		} else {
-			infoContent = "# Replication\nrole:" + role
-		}
+		infoContent = "# Replication\n" + replicationInfo()
 	default:
 		// Unknown section, return empty (Redis behavior)
 		infoContent = ""
@@ -54,3 +43,50 @@ func HandleInfo(conn net.Conn, cmd *Command) {
 
 	fmt.Printf("INFO: section=%s, role=%s\n", section, role)
 }
+
+// replicationInfo returns the fields of the replication section, in the
+// order Redis prints them
+func replicationInfo() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "role:%s\n", config.GetServerRole())
+
+	if !config.IsServerMaster() {
+		up, syncing, lastIO := replication.Link.Status()
+		status := "down"
+		if up {
+			status = "up"
+		}
+		fmt.Fprintf(&b, "master_host:%s\n", config.Server.MasterHost)
+		fmt.Fprintf(&b, "master_port:%s\n", config.Server.MasterPort)
+		fmt.Fprintf(&b, "master_link_status:%s\n", status)
+		fmt.Fprintf(&b, "master_last_io_seconds_ago:%d\n", lastIO)
+		fmt.Fprintf(&b, "master_sync_in_progress:%d\n", boolToInt(syncing))
+		fmt.Fprintf(&b, "slave_repl_offset:%d\n", config.Server.MasterReplOffset)
+	}
+
+	replicas := replication.Manager.Replicas()
+	fmt.Fprintf(&b, "connected_slaves:%d\n", len(replicas))
+	for i, replica := range replicas {
+		fmt.Fprintf(&b, "slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d,omem=%d\n",
+			i, replica.IP, replica.Port, replica.State, replica.Offset, replica.Lag, replica.OutputBuffer)
+	}
+
+	backlog := replication.Manager.BacklogInfo(config.Server.ReplBacklogSize)
+	fmt.Fprintf(&b, "master_replid:%s\n", config.Server.MasterReplId)
+	fmt.Fprintf(&b, "master_replid2:%s\n", config.Server.MasterReplId2)
+	fmt.Fprintf(&b, "master_repl_offset:%d\n", config.Server.MasterReplOffset)
+	fmt.Fprintf(&b, "second_repl_offset:%d\n", config.Server.SecondReplOffset)
+	fmt.Fprintf(&b, "repl_backlog_active:%d\n", boolToInt(backlog.Active))
+	fmt.Fprintf(&b, "repl_backlog_size:%d\n", backlog.Size)
+	fmt.Fprintf(&b, "repl_backlog_first_byte_offset:%d\n", backlog.FirstByteOffset)
+	fmt.Fprintf(&b, "repl_backlog_histlen:%d", backlog.Histlen)
+	return b.String()
+}
+
+// boolToInt returns 1 for true and 0 for false, as INFO prints flags
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -3,20 +3,110 @@ package handlers
 import (
 	"fmt"
 	"net"
+	"os"
+	"strings"
+	"sync"
 
+	"github.com/codecrafters-io/redis-starter-go/app/aof"
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/replication"
 	"github.com/codecrafters-io/redis-starter-go/app/repository"
 )
 
 // HandlerManager manages all command handlers with their dependencies
 type HandlerManager struct {
-	dataHandler *DataHandler
+	dataHandler    *DataHandler
+	streamHandler  *StreamHandler
+	bitmapHandler  *BitmapHandler
+	hllHandler     *HyperLogLogHandler
+	geoHandler     *GeoHandler
+	persistence    *PersistenceHandler
+	replHandler    *ReplicationHandler
+	migrateHandler *MigrateHandler
+	blocking       *BlockingManager
+
+	// Link with master while the server is a replica
+	replica       *ReplicaCommandHandler
+	replicaClient *replication.ReplicaClient
+
+	// mu serializes command execution the way Redis' single thread does.
+	// Blocking commands release it while they wait.
+	mu sync.Mutex
 }
 
 // NewHandlerManager creates a new handler manager with all dependencies
 func NewHandlerManager(repo repository.KeyValueRepository) *HandlerManager {
-	return &HandlerManager{
-		dataHandler: NewDataHandler(repo),
+	hm := &HandlerManager{
+		dataHandler:    NewDataHandler(repo),
+		bitmapHandler:  NewBitmapHandler(repo),
+		hllHandler:     NewHyperLogLogHandler(repo),
+		geoHandler:     NewGeoHandler(repo),
+		migrateHandler: NewMigrateHandler(repo),
+		blocking:       NewBlockingManager(),
 	}
+	hm.streamHandler = NewStreamHandler(repo, hm.blocking, &hm.mu)
+	hm.persistence = NewPersistenceHandler(repo, &hm.mu)
+	hm.replHandler = NewReplicationHandler(repo, &hm.mu)
+	repo.SetExpireHandler(expireKey)
+	return hm
+}
+
+// StartSaveScheduler starts the goroutine triggering automatic saves
+func (hm *HandlerManager) StartSaveScheduler() {
+	go hm.persistence.RunSaveScheduler()
+}
+
+// StartExpireCycle starts the goroutine deleting expired keys
+func (hm *HandlerManager) StartExpireCycle() {
+	go hm.RunExpireCycle()
+}
+
+// StartMigrateCron starts the goroutine closing idle MIGRATE connections
+func (hm *HandlerManager) StartMigrateCron() {
+	go hm.RunMigrateCron()
+}
+
+// StartReplicationCron starts the goroutine running the periodic
+// replication tasks
+func (hm *HandlerManager) StartReplicationCron() {
+	go hm.replHandler.RunCron(hm.ackMaster)
+}
+
+// LoadAppendOnly rebuilds the dataset by replaying the append-only file.
+// Replayed commands run through the regular handlers with their replies
+// discarded. A single-file log of an older version is first upgraded to the
+// multi-part layout. Returns false if there is no log to load.
+func (hm *HandlerManager) LoadAppendOnly() (aof.LoadStats, bool, error) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	dir, name := config.AOFDir(), config.Server.AppendFilename
+	if !aof.Exists(dir, name) {
+		if _, err := os.Stat(config.AOFPath()); err != nil {
+			return aof.LoadStats{}, false, nil
+		}
+		fmt.Printf("Upgrading %s to a multi-part append only file in %s\n", config.AOFPath(), dir)
+		if err := aof.Upgrade(config.AOFPath(), dir, name); err != nil {
+			return aof.LoadStats{}, true, err
+		}
+	}
+
+	repo := hm.dataHandler.repo
+	stats, err := aof.Load(dir, name, repo, func(args []string) {
+		hm.dispatch(discardConn{}, &Command{Name: strings.ToUpper(args[0]), Args: args[1:]})
+	}, config.Server.AOFLoadTruncated, config.Server.EncryptionKey)
+
+	// The loaded data is already on disk
+	dirty.Store(0)
+	return stats, true, err
+}
+
+// EnableAppendOnly starts logging writes to the append-only file
+func (hm *HandlerManager) EnableAppendOnly() error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	return hm.persistence.EnableAppendOnly()
 }
 
 // HandleCommand routes commands to appropriate handlers with dependency injection
@@ -31,21 +121,123 @@ func (hm *HandlerManager) HandleCommand(conn net.Conn, respData string) {
 
 	fmt.Printf("Command: %s, Args: %v\n", cmd.Name, cmd.Args)
 
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	// Writes reach a replica only through its master, which applies them
+	// with dispatch directly
+	if writeCommands[cmd.Name] && !config.IsServerMaster() && config.Server.ReplicaReadOnly {
+		writeResponse(conn, cmd.Name, parser.ToError("READONLY You can't write against a read only replica."))
+		return
+	}
+	hm.dispatch(conn, cmd)
+}
+
+// dispatch executes a parsed command; the caller must hold hm.mu
+func (hm *HandlerManager) dispatch(conn net.Conn, cmd *Command) {
 	switch cmd.Name {
 	case "PING":
 		HandlePing(conn, cmd)
 	case "ECHO":
 		HandleEcho(conn, cmd)
+	case "SELECT":
+		HandleSelect(conn, cmd)
 	case "SET":
 		hm.dataHandler.HandleSet(conn, cmd)
 	case "GET":
 		hm.dataHandler.HandleGet(conn, cmd)
+	case "TYPE":
+		hm.dataHandler.HandleType(conn, cmd)
+	case "KEYS":
+		hm.dataHandler.HandleKeys(conn, cmd)
+	case "DEL":
+		hm.dataHandler.HandleDel(conn, cmd)
+	case "MIGRATE":
+		hm.migrateHandler.HandleMigrate(conn, cmd)
+	case "DUMP":
+		hm.dataHandler.HandleDump(conn, cmd)
+	case "RESTORE":
+		hm.dataHandler.HandleRestore(conn, cmd)
+	case "SETBIT":
+		hm.bitmapHandler.HandleSetBit(conn, cmd)
+	case "GETBIT":
+		hm.bitmapHandler.HandleGetBit(conn, cmd)
+	case "BITCOUNT":
+		hm.bitmapHandler.HandleBitCount(conn, cmd)
+	case "BITPOS":
+		hm.bitmapHandler.HandleBitPos(conn, cmd)
+	case "BITOP":
+		hm.bitmapHandler.HandleBitOp(conn, cmd)
+	case "BITFIELD":
+		hm.bitmapHandler.HandleBitField(conn, cmd)
+	case "BITFIELD_RO":
+		hm.bitmapHandler.HandleBitFieldRO(conn, cmd)
+	case "PFADD":
+		hm.hllHandler.HandlePFAdd(conn, cmd)
+	case "PFCOUNT":
+		hm.hllHandler.HandlePFCount(conn, cmd)
+	case "PFMERGE":
+		hm.hllHandler.HandlePFMerge(conn, cmd)
+	case "GEOADD":
+		hm.geoHandler.HandleGeoAdd(conn, cmd)
+	case "GEOPOS":
+		hm.geoHandler.HandleGeoPos(conn, cmd)
+	case "GEODIST":
+		hm.geoHandler.HandleGeoDist(conn, cmd)
+	case "GEOHASH":
+		hm.geoHandler.HandleGeoHash(conn, cmd)
+	case "GEOSEARCH":
+		hm.geoHandler.HandleGeoSearch(conn, cmd)
+	case "GEOSEARCHSTORE":
+		hm.geoHandler.HandleGeoSearchStore(conn, cmd)
+	case "XADD":
+		hm.streamHandler.HandleXAdd(conn, cmd)
+	case "XLEN":
+		hm.streamHandler.HandleXLen(conn, cmd)
+	case "XRANGE":
+		hm.streamHandler.HandleXRange(conn, cmd)
+	case "XREVRANGE":
+		hm.streamHandler.HandleXRevRange(conn, cmd)
+	case "XTRIM":
+		hm.streamHandler.HandleXTrim(conn, cmd)
+	case "XDEL":
+		hm.streamHandler.HandleXDel(conn, cmd)
+	case "XREAD":
+		hm.streamHandler.HandleXRead(conn, cmd)
+	case "XGROUP":
+		hm.streamHandler.HandleXGroup(conn, cmd)
+	case "XREADGROUP":
+		hm.streamHandler.HandleXReadGroup(conn, cmd)
+	case "XACK":
+		hm.streamHandler.HandleXAck(conn, cmd)
+	case "XPENDING":
+		hm.streamHandler.HandleXPending(conn, cmd)
+	case "XCLAIM":
+		hm.streamHandler.HandleXClaim(conn, cmd)
+	case "XAUTOCLAIM":
+		hm.streamHandler.HandleXAutoClaim(conn, cmd)
+	case "XINFO":
+		hm.streamHandler.HandleXInfo(conn, cmd)
+	case "SAVE":
+		hm.persistence.HandleSave(conn, cmd)
+	case "BGSAVE":
+		hm.persistence.HandleBgSave(conn, cmd)
+	case "LASTSAVE":
+		hm.persistence.HandleLastSave(conn, cmd)
+	case "BGREWRITEAOF":
+		hm.persistence.HandleBgRewriteAof(conn, cmd)
 	case "INFO":
 		HandleInfo(conn, cmd)
+	case "CONFIG":
+		HandleConfig(conn, cmd)
 	case "REPLCONF":
 		HandleReplconf(conn, cmd)
 	case "PSYNC":
-		HandlePsync(conn, cmd)
+		hm.replHandler.HandlePsync(conn, cmd)
+	case "WAIT":
+		hm.replHandler.HandleWait(conn, cmd)
+	case "REPLICAOF", "SLAVEOF":
+		hm.HandleReplicaOf(conn, cmd)
 	default:
 		response := "-ERR unknown command '" + cmd.Name + "'\r\n"
 		conn.Write([]byte(response))
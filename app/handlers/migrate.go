@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/repository"
+)
+
+// Connections to MIGRATE targets are cached like in Redis: up to
+// migrateCacheSize of them, closed after migrateCacheTTL without use
+const (
+	migrateCacheSize = 64
+	migrateCacheTTL  = 10 * time.Second
+)
+
+// migrateConn is a cached connection to a MIGRATE target
+type migrateConn struct {
+	conn    net.Conn
+	reader  *parser.Reader
+	db      int // Database selected on the target
+	lastUse time.Time
+}
+
+// MigrateHandler handles MIGRATE. Commands run under the execution lock,
+// which also guards the connection cache.
+type MigrateHandler struct {
+	repo  repository.KeyValueRepository
+	conns map[string]*migrateConn
+}
+
+// NewMigrateHandler creates a migrate handler
+func NewMigrateHandler(repo repository.KeyValueRepository) *MigrateHandler {
+	return &MigrateHandler{
+		repo:  repo,
+		conns: make(map[string]*migrateConn),
+	}
+}
+
+// migrateError is a MIGRATE failure, replied as is
+type migrateError string
+
+func (e migrateError) Error() string {
+	return string(e)
+}
+
+// migrateIOError is a network error talking to the target. op is how Redis
+// names the operation in its reply ("writing to", "reading to").
+type migrateIOError struct {
+	op  string
+	err error
+}
+
+func (e *migrateIOError) Error() string {
+	return "error or timeout " + e.op + " target instance: " + e.err.Error()
+}
+
+// HandleMigrate handles MIGRATE host port key|"" destination-db timeout
+// [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key ...].
+// The keys are sent as RESTORE commands over a cached connection while the
+// execution lock is held, so no command sees them half moved, and deleted
+// locally once the target accepted them unless COPY is given.
+func (h *MigrateHandler) HandleMigrate(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 5 {
+		writeResponse(conn, "MIGRATE", "-ERR wrong number of arguments for 'migrate' command\r\n")
+		return
+	}
+	host, port := cmd.Args[0], cmd.Args[1]
+
+	copyKeys, replace := false, false
+	var auth []string
+	keys := []string{cmd.Args[2]}
+	for i := 5; i < len(cmd.Args); i++ {
+		switch strings.ToUpper(cmd.Args[i]) {
+		case "COPY":
+			copyKeys = true
+		case "REPLACE":
+			replace = true
+		case "AUTH":
+			if i+1 >= len(cmd.Args) {
+				writeResponse(conn, "MIGRATE", parser.ToError("ERR syntax error"))
+				return
+			}
+			auth = []string{cmd.Args[i+1]}
+			i++
+		case "AUTH2":
+			if i+2 >= len(cmd.Args) {
+				writeResponse(conn, "MIGRATE", parser.ToError("ERR syntax error"))
+				return
+			}
+			auth = []string{cmd.Args[i+1], cmd.Args[i+2]}
+			i += 2
+		case "KEYS":
+			if cmd.Args[2] != "" {
+				writeResponse(conn, "MIGRATE", parser.ToError("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string"))
+				return
+			}
+			keys = cmd.Args[i+1:]
+			i = len(cmd.Args)
+		default:
+			writeResponse(conn, "MIGRATE", parser.ToError("ERR syntax error"))
+			return
+		}
+	}
+
+	db, err := strconv.Atoi(cmd.Args[3])
+	if err != nil || db < 0 {
+		writeResponse(conn, "MIGRATE", parser.ToError("ERR value is not an integer or out of range"))
+		return
+	}
+	timeoutMs, err := strconv.Atoi(cmd.Args[4])
+	if err != nil {
+		writeResponse(conn, "MIGRATE", parser.ToError("ERR value is not an integer or out of range"))
+		return
+	}
+	if timeoutMs <= 0 {
+		timeoutMs = 1000
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+
+	// Serialize the keys that exist; missing keys are ignored
+	var restores [][]string
+	var found []string
+	now := time.Now()
+	for _, key := range keys {
+		kv, exists := h.repo.Lookup(key)
+		if !exists {
+			continue
+		}
+		payload, err := rdb.Dump(kv.Value)
+		if err != nil {
+			writeResponse(conn, "MIGRATE", parser.ToError("ERR "+err.Error()))
+			return
+		}
+		ttl := int64(0)
+		if kv.ExpiresAt != nil {
+			ttl = max(kv.ExpiresAt.Sub(now).Milliseconds(), 1)
+		}
+		restore := []string{"RESTORE", key, strconv.FormatInt(ttl, 10), string(payload)}
+		if replace {
+			restore = append(restore, "REPLACE")
+		}
+		restores = append(restores, restore)
+		found = append(found, key)
+	}
+	if len(found) == 0 {
+		writeResponse(conn, "MIGRATE", parser.ToSimpleString("NOKEY"))
+		return
+	}
+
+	moved, err := h.migrate(net.JoinHostPort(host, port), db, auth, restores, found, timeout)
+	if !copyKeys && len(moved) > 0 {
+		for _, key := range moved {
+			h.repo.Delete(key)
+		}
+		if logErr := propagateCommand("DEL", moved); logErr != nil {
+			writeResponse(conn, "MIGRATE", aofErrorReply(logErr))
+			return
+		}
+	}
+	if err != nil {
+		writeResponse(conn, "MIGRATE", parser.ToError(err.Error()))
+		return
+	}
+	writeResponse(conn, "MIGRATE", parser.ToSimpleString("OK"))
+}
+
+// migrate sends the RESTORE commands to the target and returns the keys it
+// accepted. A cached connection may have been closed by the target in the
+// meantime, so an IO error on one is retried once on a new connection.
+func (h *MigrateHandler) migrate(addr string, db int, auth []string, restores [][]string, keys []string, timeout time.Duration) ([]string, error) {
+	for attempt := 0; ; attempt++ {
+		mc, cached, err := h.connect(addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		moved, err := h.send(mc, db, auth, restores, keys, timeout)
+		var ioErr *migrateIOError
+		if errors.As(err, &ioErr) {
+			h.closeConn(addr)
+			if cached && attempt == 0 && len(moved) == 0 && !isTimeout(ioErr.err) {
+				continue
+			}
+			fmt.Printf("MIGRATE to %s failed: %v\n", addr, ioErr.err)
+			return moved, migrateError("IOERR error or timeout " + ioErr.op + " target instance")
+		}
+		mc.lastUse = time.Now()
+		return moved, err
+	}
+}
+
+// send authenticates and selects the database when needed, then pipelines
+// the RESTORE commands and reads their replies. The RESTOREs are only sent
+// once AUTH and SELECT succeeded, so they never land in the wrong database.
+func (h *MigrateHandler) send(mc *migrateConn, db int, auth []string, restores [][]string, keys []string, timeout time.Duration) ([]string, error) {
+	mc.conn.SetDeadline(time.Now().Add(timeout))
+	defer mc.conn.SetDeadline(time.Time{})
+
+	var preamble [][]string
+	if auth != nil {
+		preamble = append(preamble, append([]string{"AUTH"}, auth...))
+	}
+	if db != mc.db {
+		preamble = append(preamble, []string{"SELECT", strconv.Itoa(db)})
+	}
+	if len(preamble) > 0 {
+		replies, err := h.roundTrip(mc, preamble)
+		if err != nil {
+			return nil, err
+		}
+		for _, reply := range replies {
+			if reply[0] == '-' {
+				// The target's database is unknown after an error
+				mc.db = -1
+				return nil, migrateError("ERR Target instance replied with error: " + strings.TrimSpace(reply[1:]))
+			}
+		}
+		mc.db = db
+	}
+
+	replies, err := h.roundTrip(mc, restores)
+	var moved []string
+	var firstErr error
+	for i, reply := range replies {
+		if reply[0] == '-' {
+			if firstErr == nil {
+				firstErr = migrateError("ERR Target instance replied with error: " + strings.TrimSpace(reply[1:]))
+			}
+			continue
+		}
+		moved = append(moved, keys[i])
+	}
+	if err != nil {
+		return moved, err
+	}
+	return moved, firstErr
+}
+
+// roundTrip writes commands in a single batch and reads one reply for each.
+// On a read error it returns the replies read so far.
+func (h *MigrateHandler) roundTrip(mc *migrateConn, commands [][]string) ([]string, error) {
+	var out strings.Builder
+	for _, command := range commands {
+		out.WriteString(parser.ToBulkStringArray(command))
+	}
+	if _, err := mc.conn.Write([]byte(out.String())); err != nil {
+		return nil, &migrateIOError{"writing to", err}
+	}
+
+	replies := make([]string, 0, len(commands))
+	for range commands {
+		reply, err := mc.reader.ReadMessage()
+		if err != nil {
+			return replies, &migrateIOError{"reading to", err}
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// connect returns a connection to addr, from the cache when possible
+func (h *MigrateHandler) connect(addr string, timeout time.Duration) (*migrateConn, bool, error) {
+	h.closeIdleConns()
+	if mc, ok := h.conns[addr]; ok {
+		return mc, true, nil
+	}
+
+	if len(h.conns) >= migrateCacheSize {
+		// Make room by closing a random connection, like Redis
+		for other := range h.conns {
+			h.closeConn(other)
+			break
+		}
+	}
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		fmt.Printf("MIGRATE cannot connect to %s: %v\n", addr, err)
+		return nil, false, migrateError("IOERR error or timeout connecting to the client")
+	}
+	mc := &migrateConn{conn: conn, reader: parser.NewReader(conn), lastUse: time.Now()}
+	h.conns[addr] = mc
+	return mc, false, nil
+}
+
+// closeConn closes and forgets the cached connection to addr
+func (h *MigrateHandler) closeConn(addr string) {
+	if mc, ok := h.conns[addr]; ok {
+		mc.conn.Close()
+		delete(h.conns, addr)
+	}
+}
+
+// closeIdleConns closes the cached connections unused for migrateCacheTTL
+func (h *MigrateHandler) closeIdleConns() {
+	for addr, mc := range h.conns {
+		if time.Since(mc.lastUse) > migrateCacheTTL {
+			h.closeConn(addr)
+		}
+	}
+}
+
+// RunMigrateCron closes the cached connections left idle every second, so
+// they don't wait for the next MIGRATE, like Redis does from its server
+// cron. It never returns.
+func (hm *HandlerManager) RunMigrateCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		hm.mu.Lock()
+		hm.migrateHandler.closeIdleConns()
+		hm.mu.Unlock()
+	}
+}
+
+// isTimeout reports whether err is a network timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/aof"
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/repository"
+)
+
+// dirty counts the writes since the last successful save
+var dirty atomic.Int64
+
+// markDirty records a write for the automatic save points
+func markDirty() {
+	dirty.Add(1)
+}
+
+// appendOnly is the append-only file write commands are logged to, nil
+// when appendonly is off. It is only changed under the execution lock.
+var appendOnly *aof.File
+
+// saveRetryDelay is how long automatic saves wait after a failed one
+const saveRetryDelay = 5 * time.Second
+
+// PersistenceHandler handles RDB snapshots: SAVE, BGSAVE, LASTSAVE and the
+// automatic background saves configured with save rules, and the rewrites
+// of the append-only file
+type PersistenceHandler struct {
+	repo repository.KeyValueRepository
+	lock *sync.Mutex // Execution lock, held while the dataset is copied
+
+	mu          sync.Mutex
+	saving      bool // A background save is running
+	scheduled   bool // BGSAVE SCHEDULE is waiting for the running save
+	lastSave    time.Time
+	lastAttempt time.Time
+	lastOK      bool
+	rewriting   bool // BGREWRITEAOF is running
+	rewriteNext bool // Another rewrite starts when the running one ends
+}
+
+// NewPersistenceHandler creates a persistence handler. lock is the
+// execution lock of the handler manager.
+func NewPersistenceHandler(repo repository.KeyValueRepository, lock *sync.Mutex) *PersistenceHandler {
+	return &PersistenceHandler{
+		repo:     repo,
+		lock:     lock,
+		lastSave: time.Now(),
+		lastOK:   true,
+	}
+}
+
+// HandleSave handles the SAVE command, writing the snapshot synchronously
+func (h *PersistenceHandler) HandleSave(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 0 {
+		writeResponse(conn, "SAVE", "-ERR wrong number of arguments for 'save' command\r\n")
+		return
+	}
+
+	h.mu.Lock()
+	saving := h.saving
+	h.mu.Unlock()
+	if saving {
+		writeResponse(conn, "SAVE", parser.ToError("ERR Background save already in progress"))
+		return
+	}
+
+	changes := dirty.Load()
+	err := rdb.SaveFile(config.RDBPath(), h.repo.Snapshot(), replicationAux(), config.Server.EncryptionKey)
+	h.finishSave(changes, err)
+	if err != nil {
+		fmt.Printf("SAVE failed: %v\n", err)
+		writeResponse(conn, "SAVE", parser.ToError("ERR "+err.Error()))
+		return
+	}
+	writeResponse(conn, "SAVE", parser.ToSimpleString("OK"))
+}
+
+// HandleBgSave handles BGSAVE [SCHEDULE]
+func (h *PersistenceHandler) HandleBgSave(conn net.Conn, cmd *Command) {
+	schedule := false
+	if len(cmd.Args) > 1 {
+		writeResponse(conn, "BGSAVE", "-ERR wrong number of arguments for 'bgsave' command\r\n")
+		return
+	}
+	if len(cmd.Args) == 1 {
+		if strings.ToUpper(cmd.Args[0]) != "SCHEDULE" {
+			writeResponse(conn, "BGSAVE", parser.ToError("ERR syntax error"))
+			return
+		}
+		schedule = true
+	}
+
+	if !h.startBackgroundSave() {
+		if !schedule {
+			writeResponse(conn, "BGSAVE", parser.ToError("ERR Background save already in progress"))
+			return
+		}
+		h.mu.Lock()
+		h.scheduled = true
+		h.mu.Unlock()
+		writeResponse(conn, "BGSAVE", parser.ToSimpleString("Background saving scheduled"))
+		return
+	}
+	writeResponse(conn, "BGSAVE", parser.ToSimpleString("Background saving started"))
+}
+
+// HandleLastSave handles the LASTSAVE command
+func (h *PersistenceHandler) HandleLastSave(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 0 {
+		writeResponse(conn, "LASTSAVE", "-ERR wrong number of arguments for 'lastsave' command\r\n")
+		return
+	}
+
+	h.mu.Lock()
+	lastSave := h.lastSave
+	h.mu.Unlock()
+	writeResponse(conn, "LASTSAVE", parser.ToInteger(int(lastSave.Unix())))
+}
+
+// startBackgroundSave copies the dataset and writes it from a goroutine,
+// so commands only wait for the copy and not for the encoding and disk
+// writes. The caller must hold the execution lock. Returns false if a
+// save is already running.
+func (h *PersistenceHandler) startBackgroundSave() bool {
+	h.mu.Lock()
+	if h.saving {
+		h.mu.Unlock()
+		return false
+	}
+	h.saving = true
+	h.lastAttempt = time.Now()
+	h.mu.Unlock()
+
+	changes := dirty.Load()
+	snapshot := h.repo.Snapshot()
+	aux := replicationAux()
+	path := config.RDBPath()
+	fmt.Printf("Background saving started (%d keys)\n", len(snapshot))
+
+	go func() {
+		err := rdb.SaveFile(path, snapshot, aux, config.Server.EncryptionKey)
+		if err != nil {
+			fmt.Printf("Background saving error: %v\n", err)
+		} else {
+			fmt.Println("Background saving terminated with success")
+		}
+
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		h.finishSave(changes, err)
+
+		h.mu.Lock()
+		h.saving = false
+		scheduled := h.scheduled
+		h.scheduled = false
+		h.mu.Unlock()
+		if scheduled {
+			h.startBackgroundSave()
+		}
+	}()
+	return true
+}
+
+// finishSave records the outcome of a save. changes is the dirty counter
+// when the snapshot was taken: writes made since then are still unsaved.
+func (h *PersistenceHandler) finishSave(changes int64, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastOK = err == nil
+	if err == nil {
+		dirty.Add(-changes)
+		h.lastSave = time.Now()
+	}
+}
+
+// RunSaveScheduler checks the save rules every second and starts a
+// background save when one of them is met. It never returns.
+func (h *PersistenceHandler) RunSaveScheduler() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		h.lock.Lock()
+		if h.shouldSave(now) {
+			h.startBackgroundSave()
+		}
+		h.lock.Unlock()
+	}
+}
+
+// shouldSave reports whether a save rule is met
+func (h *PersistenceHandler) shouldSave(now time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.saving {
+		return false
+	}
+	// After a failure wait a bit before retrying, like Redis
+	if !h.lastOK && now.Sub(h.lastAttempt) < saveRetryDelay {
+		return false
+	}
+
+	changes := dirty.Load()
+	for _, rule := range config.Server.SaveRules {
+		if changes >= int64(rule.Changes) && now.Sub(h.lastSave) >= time.Duration(rule.Seconds)*time.Second {
+			fmt.Printf("%d changes in %d seconds. Saving...\n", rule.Changes, rule.Seconds)
+			return true
+		}
+	}
+	return false
+}
+
+// errRewriteInProgress is returned when BGREWRITEAOF is already running
+var errRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+
+// EnableAppendOnly opens the append-only file and starts logging writes to
+// it. When there is no log yet one is created from the current dataset, so
+// the log alone is enough to rebuild it. The caller must hold the execution
+// lock.
+func (h *PersistenceHandler) EnableAppendOnly() error {
+	policy, err := aof.ParseFsyncPolicy(config.Server.AppendFsync)
+	if err != nil {
+		return err
+	}
+
+	dir := config.AOFDir()
+	if !aof.Exists(dir, config.Server.AppendFilename) {
+		if err := aof.Create(dir, config.Server.AppendFilename, h.repo.Snapshot(), config.Server.EncryptionKey); err != nil {
+			return err
+		}
+	}
+
+	file, err := aof.Open(dir, config.Server.AppendFilename, policy, config.Server.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	appendOnly = file
+
+	// Files written before encryption was turned on or off only change
+	// with a rewrite
+	if file.NeedsRewrite() {
+		fmt.Println("The append only file was written with another encryption setting, rewriting it")
+		h.scheduleRewrite()
+	}
+	return nil
+}
+
+// HandleBgRewriteAof handles BGREWRITEAOF
+func (h *PersistenceHandler) HandleBgRewriteAof(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 0 {
+		writeResponse(conn, "BGREWRITEAOF", "-ERR wrong number of arguments for 'bgrewriteaof' command\r\n")
+		return
+	}
+
+	if err := h.startRewrite(); err != nil {
+		writeResponse(conn, "BGREWRITEAOF", parser.ToError("ERR "+err.Error()))
+		return
+	}
+	writeResponse(conn, "BGREWRITEAOF", parser.ToSimpleString("Background append only file rewriting started"))
+}
+
+// startRewrite writes a new base file from the current dataset. Appends
+// switch to a new incremental file while the copied dataset is written
+// from a goroutine; the manifest then moves to the new base and that file,
+// dropping everything before them. The caller must hold the execution
+// lock.
+func (h *PersistenceHandler) startRewrite() error {
+	h.mu.Lock()
+	if h.rewriting {
+		h.mu.Unlock()
+		return errRewriteInProgress
+	}
+	h.rewriting = true
+	h.mu.Unlock()
+
+	file := appendOnly
+	if file != nil {
+		if err := file.StartRewrite(); err != nil {
+			fmt.Printf("Can't start the append only file rewrite: %v\n", err)
+			h.mu.Lock()
+			h.rewriting = false
+			h.mu.Unlock()
+			return err
+		}
+	}
+	snapshot := h.repo.Snapshot()
+	dir := config.AOFDir()
+	fmt.Printf("Background append only file rewriting started (%d keys)\n", len(snapshot))
+
+	go func() {
+		var err error
+		if file != nil {
+			var tmp string
+			if tmp, err = aof.WriteBase(dir, snapshot, config.Server.EncryptionKey); err == nil {
+				h.lock.Lock()
+				err = file.FinishRewrite(tmp)
+				h.lock.Unlock()
+				if err != nil {
+					os.Remove(tmp)
+				}
+			} else {
+				file.AbortRewrite()
+			}
+		} else {
+			// Not logging: the new base is the whole log
+			err = aof.Create(dir, config.Server.AppendFilename, snapshot, config.Server.EncryptionKey)
+		}
+		if err != nil {
+			fmt.Printf("Background append only file rewriting error: %v\n", err)
+		} else {
+			fmt.Println("Background AOF rewrite finished successfully")
+		}
+
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		h.mu.Lock()
+		h.rewriting = false
+		next := h.rewriteNext
+		h.rewriteNext = false
+		h.mu.Unlock()
+		if next {
+			h.startRewrite()
+		}
+	}()
+	return nil
+}
+
+// scheduleRewrite starts a rewrite, or schedules one after the running
+// rewrite when its snapshot is already outdated. The caller must hold the
+// execution lock.
+func (h *PersistenceHandler) scheduleRewrite() {
+	if errors.Is(h.startRewrite(), errRewriteInProgress) {
+		h.mu.Lock()
+		h.rewriteNext = true
+		h.mu.Unlock()
+	}
+}
@@ -1,32 +1,64 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/codecrafters-io/redis-starter-go/app/config"
 	"github.com/codecrafters-io/redis-starter-go/app/parser"
-	"github.com/codecrafters-io/redis-starter-go/app/repository"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/replication"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
 )
 
-// ReplicaCommandHandler handles commands from master without sending responses
+// ReplicaCommandHandler handles commands from master without sending
+// responses. The bytes of commands processed are counted in
+// config.Server.MasterReplOffset, under the execution lock, so the offset
+// always matches the dataset.
 type ReplicaCommandHandler struct {
+	manager     *HandlerManager
 	dataHandler *DataHandler
 	conn        net.Conn // Connection to master for sending ACK responses
-	offset      int      // Tracks total bytes of commands processed from master
+
+	// Set once the link is closed: data it still delivers is dropped
+	detached bool
 }
 
-// NewReplicaCommandHandler creates a new replica command handler with repository dependency
-func NewReplicaCommandHandler(repo repository.KeyValueRepository) *ReplicaCommandHandler {
+// NewReplicaCommandHandler creates a new replica command handler sharing the
+// handlers (and execution lock) used for client commands
+func NewReplicaCommandHandler(manager *HandlerManager) *ReplicaCommandHandler {
 	return &ReplicaCommandHandler{
-		dataHandler: NewDataHandler(repo),
+		manager:     manager,
+		dataHandler: manager.dataHandler,
 		conn:        nil, // Will be set later via SetConnection
-		offset:      0,   // Start with 0 offset
 	}
 }
 
+// errDetached is returned for data received after the link was closed
+var errDetached = errors.New("replication link closed")
+
+// detach drops whatever the link still delivers. The caller must hold the
+// execution lock.
+func (rch *ReplicaCommandHandler) detach() {
+	rch.detached = true
+}
+
+// discardConn is a connection whose writes are dropped. It lets the replica
+// run client handlers for commands received from master without replying.
+type discardConn struct {
+	net.Conn
+}
+
+// Write discards the data
+func (discardConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
 // SetConnection sets the connection to master (used for sending ACK responses)
 func (rch *ReplicaCommandHandler) SetConnection(conn net.Conn) {
 	rch.conn = conn
@@ -42,35 +74,36 @@ func (rch *ReplicaCommandHandler) ProcessCommand(respData string) error {
 
 	fmt.Printf("Replica processing command: %s, Args: %v\n", cmd.Name, cmd.Args)
 
-	// Handle REPLCONF GETACK specially - respond with current offset before updating
+	rch.manager.mu.Lock()
+	defer rch.manager.mu.Unlock()
+	if rch.detached {
+		return errDetached
+	}
+
+	// Handle REPLCONF GETACK specially - respond with the offset before it
 	if cmd.Name == "REPLCONF" && len(cmd.Args) > 0 && strings.ToUpper(cmd.Args[0]) == "GETACK" {
-		// Send ACK with current offset (before processing this command)
 		err := rch.sendAck()
-		if err != nil {
-			return err
-		}
-		// Update offset after sending ACK
-		rch.offset += len(respData)
-		return nil
+		rch.advance(respData)
+		return err
 	}
 
 	// For all other commands, update offset first, then process
-	rch.offset += len(respData)
-
-	// Process commands without sending responses back
-	switch cmd.Name {
-	case "SET":
-		return rch.processSilentSet(cmd)
-	case "PING":
+	rch.advance(respData)
+
+	// Process commands without sending responses back. Writes run through
+	// the same handlers as client commands.
+	switch {
+	case writeCommands[cmd.Name] || cmd.Name == "SELECT":
+		applyingMaster = true
+		rch.manager.dispatch(discardConn{}, cmd)
+		applyingMaster = false
+	case cmd.Name == "PING":
 		// Process PING silently (just for logging)
 		fmt.Printf("Replica processed PING command\n")
 		return nil
-	case "REPLCONF":
+	case cmd.Name == "REPLCONF":
 		// Handle other REPLCONF commands (this shouldn't happen after the check above)
 		return rch.processReplconf(cmd)
-	case "DEL":
-		// Could add delete command handling here
-		fmt.Printf("Replica processed DEL command (not yet implemented)\n")
 	default:
 		fmt.Printf("Replica received unknown command: %s\n", cmd.Name)
 	}
@@ -78,57 +111,78 @@ func (rch *ReplicaCommandHandler) ProcessCommand(respData string) error {
 	return nil
 }
 
-// processSilentSet processes a SET command without sending any response
-func (rch *ReplicaCommandHandler) processSilentSet(cmd *Command) error {
-	if len(cmd.Args) < 2 {
-		return fmt.Errorf("wrong number of arguments for 'set' command")
-	}
-
-	key := cmd.Args[0]
-	value := cmd.Args[1]
-
-	// Parse expiration options if present (same logic as regular SET)
-	var expiration *time.Duration
-	for i := 2; i < len(cmd.Args); i += 2 {
-		if i+1 >= len(cmd.Args) {
-			return fmt.Errorf("syntax error in SET command")
-		}
-
-		option := strings.ToUpper(cmd.Args[i])
-		timeStr := cmd.Args[i+1]
-
-		timeVal, err := strconv.Atoi(timeStr)
-		if err != nil {
-			return fmt.Errorf("invalid expiration time: %v", err)
-		}
+// advance counts data received from master in the offset and forwards it
+// as is to the replicas of this server. The caller must hold the execution
+// lock.
+func (rch *ReplicaCommandHandler) advance(respData string) {
+	config.Server.MasterReplOffset += len(respData)
+	replication.Manager.FeedFromMaster([]byte(respData))
+}
 
-		switch option {
-		case "EX":
-			// Expiration in seconds
-			duration := time.Duration(timeVal) * time.Second
-			expiration = &duration
-		case "PX":
-			// Expiration in milliseconds
-			duration := time.Duration(timeVal) * time.Millisecond
-			expiration = &duration
-		default:
-			return fmt.Errorf("unknown SET option: %s", option)
+// LoadSnapshot replaces the dataset with the RDB payload received from
+// master on a full resync at replication ID replId and offset. Unless
+// repl-diskless-load allows decoding it straight from the link, the payload
+// is first saved as the RDB file, like Redis does, and loaded from there.
+// It is fully decoded before anything is replaced, and the swap happens
+// under the execution lock so clients see either the old or the new
+// dataset. The replicas of this server then need a full resync too.
+func (rch *ReplicaCommandHandler) LoadSnapshot(replId string, offset int, payload io.Reader) error {
+	var snapshot map[string]storage.KeyValue
+	var stats rdb.LoadStats
+	var err error
+	switch mode := config.Server.ReplDisklessLoad; {
+	case mode == config.DisklessLoadSwapDB,
+		mode == config.DisklessLoadOnEmptyDB && rch.dataHandler.repo.Size() == 0:
+		snapshot, stats, err = rdb.ReadSnapshot(payload)
+	default:
+		path := config.RDBPath()
+		if err := rdb.SaveStream(path, payload, config.Server.EncryptionKey); err != nil {
+			return fmt.Errorf("failed to save %s: %v", path, err)
 		}
+		snapshot, stats, err = rdb.ReadFile(path, config.Server.EncryptionKey)
 	}
-
-	err := rch.dataHandler.repo.Set(key, value, expiration)
 	if err != nil {
-		return fmt.Errorf("failed to set key in replica: %v", err)
+		return err
 	}
 
-	if expiration != nil {
-		fmt.Printf("Replica SET: %s = %s (expires in %v)\n", key, value, *expiration)
-	} else {
-		fmt.Printf("Replica SET: %s = %s (no expiration)\n", key, value)
+	rch.manager.mu.Lock()
+	defer rch.manager.mu.Unlock()
+	if rch.detached {
+		return errDetached
+	}
+	replication.Manager.Reset()
+	rch.dataHandler.repo.Replace(snapshot)
+	config.Server.MasterReplId = replId
+	config.Server.MasterReplOffset = offset
+	config.Server.MasterCached = true
+	fmt.Printf("Replica loaded %d keys from master (%d expired, %d skipped)\n", stats.Loaded, stats.Expired, stats.Skipped)
+
+	// The log doesn't contain the new dataset: rebuild it from scratch
+	if appendOnly != nil {
+		rch.manager.persistence.scheduleRewrite()
 	}
 	return nil
 }
 
+// ContinueSync is called when master accepts a partial resync: the stream
+// continues from the current offset, with the replication ID master sent
+func (rch *ReplicaCommandHandler) ContinueSync(replId string) {
+	rch.manager.mu.Lock()
+	defer rch.manager.mu.Unlock()
+	if rch.detached {
+		return
+	}
+	if replId != "" && replId != config.Server.MasterReplId {
+		// Master was promoted: the history up to here is shared with the
+		// former one
+		config.Server.MasterReplId2 = config.Server.MasterReplId
+		config.Server.SecondReplOffset = config.Server.MasterReplOffset + 1
+		config.Server.MasterReplId = replId
+		replication.Manager.DisconnectReplicas()
+	}
+	fmt.Printf("Replica continuing replication from offset %d\n", config.Server.MasterReplOffset)
+}
+
 // processReplconf handles REPLCONF commands from master
 func (rch *ReplicaCommandHandler) processReplconf(cmd *Command) error {
 	if len(cmd.Args) < 1 {
@@ -156,7 +210,7 @@ func (rch *ReplicaCommandHandler) sendAck() error {
 	}
 
 	// Use current offset
-	offset := strconv.Itoa(rch.offset)
+	offset := strconv.Itoa(config.Server.MasterReplOffset)
 	
 	// Create REPLCONF ACK response: *3\r\n$8\r\nREPLCONF\r\n$3\r\nACK\r\n$<len>\r\n<offset>\r\n
 	ackCommand := parser.RESPValue{
@@ -171,7 +225,9 @@ func (rch *ReplicaCommandHandler) sendAck() error {
 	// Encode to RESP format
 	respData := parser.EncodeRESP(ackCommand)
 
-	// Send to master
+	// Send to master, giving up on a master not reading anymore rather
+	// than holding the execution lock
+	rch.conn.SetWriteDeadline(time.Now().Add(time.Duration(config.Server.ReplTimeout) * time.Second))
 	_, err := rch.conn.Write([]byte(respData))
 	if err != nil {
 		return fmt.Errorf("failed to write REPLCONF ACK response: %v", err)
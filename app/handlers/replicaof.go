@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/replication"
+)
+
+// HandleReplicaOf handles REPLICAOF host port and REPLICAOF NO ONE, also
+// available as SLAVEOF. The first makes the server a replica of host:port,
+// the second promotes a replica to master keeping its dataset.
+func (hm *HandlerManager) HandleReplicaOf(conn net.Conn, cmd *Command) {
+	name := strings.ToLower(cmd.Name)
+	if len(cmd.Args) != 2 {
+		writeResponse(conn, cmd.Name, "-ERR wrong number of arguments for '"+name+"' command\r\n")
+		return
+	}
+
+	if strings.EqualFold(cmd.Args[0], "no") && strings.EqualFold(cmd.Args[1], "one") {
+		if !config.IsServerMaster() {
+			hm.promote()
+		}
+		writeResponse(conn, cmd.Name, parser.ToSimpleString("OK"))
+		return
+	}
+
+	host, port := cmd.Args[0], cmd.Args[1]
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		writeResponse(conn, cmd.Name, parser.ToError("ERR Invalid master port"))
+		return
+	}
+	if !config.IsServerMaster() && config.Server.MasterHost == host && config.Server.MasterPort == port {
+		writeResponse(conn, cmd.Name, parser.ToSimpleString("OK Already connected to specified master"))
+		return
+	}
+	hm.replicaOf(host, port)
+	writeResponse(conn, cmd.Name, parser.ToSimpleString("OK"))
+}
+
+// ReplicaOf makes the server a replica of host:port at startup
+func (hm *HandlerManager) ReplicaOf(host, port string) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	hm.replicaOf(host, port)
+}
+
+// replicaOf makes the server a replica of host:port, dropping the link
+// with its current master if any. A master first disconnects its own
+// replicas. Its dataset then stands for its own history, so the new master
+// can partially resynchronize it if it was promoted from the same one;
+// otherwise the dataset is replaced on the full resync. The caller must
+// hold the execution lock.
+func (hm *HandlerManager) replicaOf(host, port string) {
+	if config.IsServerMaster() {
+		replication.Manager.Reset()
+		config.Server.MasterCached = true
+	}
+	hm.stopReplication()
+	config.SetReplicaConfig(host, port)
+	fmt.Printf("Configured as replica of %s:%s\n", host, port)
+
+	hm.replica = NewReplicaCommandHandler(hm)
+	hm.replicaClient = replication.NewReplicaClient(host, port, config.Server.Port,
+		hm.replica.ProcessCommand, hm.replica.SetConnection, hm.replica.LoadSnapshot, hm.replica.ContinueSync)
+
+	// Stay connected to master, reconnecting whenever the link fails
+	go hm.replicaClient.Run()
+}
+
+// promote turns a replica into a master. The replication ID changes as
+// the history diverges from the former master's, which is kept as the
+// second ID so the other replicas of that master, and those of this
+// server, disconnected to learn the new ID, can partially resynchronize
+// from this server. The caller must hold the execution lock.
+func (hm *HandlerManager) promote() {
+	hm.stopReplication()
+	config.SetMasterConfig()
+	config.ShiftReplId()
+	config.Server.MasterCached = false
+	replication.Manager.DisconnectReplicas()
+	replication.Manager.CreateBacklog(config.Server.ReplBacklogSize)
+	fmt.Printf("Promoted to master, new replication ID %s (previous one valid up to offset %d)\n",
+		config.Server.MasterReplId, config.Server.SecondReplOffset)
+}
+
+// stopReplication closes the link with master, if any. Data the link
+// already received is dropped. The caller must hold the execution lock.
+func (hm *HandlerManager) stopReplication() {
+	if hm.replicaClient == nil {
+		return
+	}
+	hm.replica.detach()
+	hm.replicaClient.Stop()
+	hm.replica, hm.replicaClient = nil, nil
+}
+
+// ackMaster sends REPLCONF ACK with the offset processed to master while
+// the link streams commands, telling master the replica is alive and how
+// far it got. The caller must hold the execution lock.
+func (hm *HandlerManager) ackMaster() {
+	if hm.replica == nil {
+		return
+	}
+	if up, _, _ := replication.Link.Status(); !up {
+		return
+	}
+	if err := hm.replica.sendAck(); err != nil {
+		fmt.Printf("Failed to acknowledge the offset to master: %v\n", err)
+	}
+}
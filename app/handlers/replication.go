@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/replication"
+	"github.com/codecrafters-io/redis-starter-go/app/repository"
+)
+
+// ReplicationHandler handles the master side of replication
+type ReplicationHandler struct {
+	repo repository.KeyValueRepository
+	lock *sync.Mutex // Execution lock, held by the periodic tasks
+
+	// Replicas waiting for the next diskless transfer, which starts when
+	// disklessTimer fires
+	disklessWaiting []*replication.ReplicaConnection
+	disklessTimer   *time.Timer
+}
+
+// NewReplicationHandler creates a new replication handler with repository
+// dependency. lock is the execution lock of the handler manager.
+func NewReplicationHandler(repo repository.KeyValueRepository, lock *sync.Mutex) *ReplicationHandler {
+	return &ReplicationHandler{
+		repo: repo,
+		lock: lock,
+	}
+}
+
+// HandlePsync handles PSYNC replid offset. A replica asking to continue
+// this server's stream from an offset the backlog still holds gets
+// +CONTINUE followed by the commands it missed. Any other replica gets a
+// full resynchronization: an RDB snapshot of the dataset, then every
+// command propagated since the snapshot was taken. With repl-diskless-sync,
+// replicas supporting it get the snapshot streamed as it is generated.
+// A replica serves its own replicas the same way, with the replication ID
+// and offsets of its master, once it is synchronized with it.
+func (h *ReplicationHandler) HandlePsync(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 2 {
+		writeResponse(conn, "PSYNC", "-ERR wrong number of arguments for 'psync' command\r\n")
+		return
+	}
+	if up, _, _ := replication.Link.Status(); !config.IsServerMaster() && !up {
+		writeResponse(conn, "PSYNC", parser.ToError("NOMASTERLINK Can't SYNC while not connected with my master"))
+		return
+	}
+	replication.Manager.CreateBacklog(config.Server.ReplBacklogSize)
+
+	// Get the replication ID and offset from server configuration
+	replId := config.Server.MasterReplId
+	replOffset := config.Server.MasterReplOffset
+
+	// The history of a promoted replica's former master is shared up to
+	// SecondReplOffset
+	if psyncOffset, err := strconv.ParseInt(cmd.Args[1], 10, 64); err == nil &&
+		(cmd.Args[0] == replId || (cmd.Args[0] == config.Server.MasterReplId2 && psyncOffset <= int64(config.Server.SecondReplOffset))) {
+		if replica := replication.Manager.PartialResync(conn, psyncOffset); replica != nil {
+			h.continueSync(conn, replica, psyncOffset)
+			return
+		}
+	}
+
+	if config.Server.ReplDisklessSync && replication.Manager.SupportsDiskless(conn) {
+		h.queueDisklessSync(conn)
+		return
+	}
+
+	// Respond with FULLRESYNC using the actual server configuration
+	response := fmt.Sprintf("+FULLRESYNC %s %d\r\n", replId, replOffset)
+	_, err := conn.Write([]byte(response))
+	if err != nil {
+		fmt.Println("Failed to write PSYNC response")
+		return
+	}
+	fmt.Printf("PSYNC: %v -> FULLRESYNC %s %d\n", cmd.Args, replId, replOffset)
+
+	// The execution lock is held: the snapshot and the registration happen
+	// before any other write, so the buffered commands start exactly where
+	// the snapshot ends
+	snapshot := h.repo.Snapshot()
+	aux := replicationAux()
+	replica := replication.Manager.AddSyncingReplica(conn)
+
+	go func() {
+		var payload bytes.Buffer
+		if err := rdb.WriteSnapshot(&payload, snapshot, aux); err != nil {
+			fmt.Printf("Failed to generate RDB for replica %s: %v\n", replica.ID, err)
+			conn.Close()
+			return
+		}
+		if err := replica.FinishSync(payload.Bytes()); err != nil {
+			fmt.Printf("Failed to transfer RDB to replica %s: %v\n", replica.ID, err)
+			conn.Close()
+			return
+		}
+		fmt.Printf("Sent RDB snapshot to replica %s (%d keys, %d bytes)\n", replica.ID, len(snapshot), payload.Len())
+	}()
+}
+
+// queueDisklessSync registers a replica for the next diskless transfer.
+// The transfer starts after repl-diskless-sync-delay seconds, so the
+// replicas asking for a full resync meanwhile share it.
+func (h *ReplicationHandler) queueDisklessSync(conn net.Conn) {
+	replica := replication.Manager.AddWaitingReplica(conn)
+	h.disklessWaiting = append(h.disklessWaiting, replica)
+
+	delay := time.Duration(config.Server.ReplDisklessSyncDelay) * time.Second
+	if delay == 0 {
+		h.startDisklessSync()
+		return
+	}
+	if h.disklessTimer == nil {
+		fmt.Printf("PSYNC: diskless full resync starting in %v\n", delay)
+		h.disklessTimer = time.AfterFunc(delay, func() {
+			h.lock.Lock()
+			defer h.lock.Unlock()
+			h.startDisklessSync()
+		})
+	}
+}
+
+// startDisklessSync starts the full resync of the replicas waiting for a
+// diskless transfer and streams the snapshot to them. The caller must
+// hold the execution lock.
+func (h *ReplicationHandler) startDisklessSync() {
+	waiting := h.disklessWaiting
+	h.disklessWaiting, h.disklessTimer = nil, nil
+
+	replId := config.Server.MasterReplId
+	replOffset := config.Server.MasterReplOffset
+	response := fmt.Sprintf("+FULLRESYNC %s %d\r\n", replId, replOffset)
+	var replicas []*replication.ReplicaConnection
+	for _, replica := range waiting {
+		if err := replica.StartSync(response); err != nil {
+			fmt.Printf("Failed to start the full resync of replica %s: %v\n", replica.ID, err)
+			continue
+		}
+		replicas = append(replicas, replica)
+	}
+	if len(replicas) == 0 {
+		return
+	}
+	fmt.Printf("PSYNC: diskless full resync of %d replicas at %s %d\n", len(replicas), replId, replOffset)
+
+	snapshot := h.repo.Snapshot()
+	aux := replicationAux()
+	go func() {
+		err := replication.SendDisklessSnapshot(replicas, func(w io.Writer) error {
+			return rdb.WriteSnapshot(w, snapshot, aux)
+		})
+		if err != nil {
+			fmt.Printf("Diskless transfer failed: %v\n", err)
+			return
+		}
+		fmt.Printf("Streamed RDB snapshot to %d replicas (%d keys)\n", len(replicas), len(snapshot))
+	}()
+}
+
+// continueSync accepts a partial resynchronization. The replication ID is
+// sent along, as for replicas supporting psync2, then the data buffered by
+// PartialResync.
+func (h *ReplicationHandler) continueSync(conn net.Conn, replica *replication.ReplicaConnection, psyncOffset int64) {
+	if _, err := conn.Write([]byte("+CONTINUE " + config.Server.MasterReplId + "\r\n")); err != nil {
+		fmt.Println("Failed to write PSYNC response")
+		conn.Close()
+		return
+	}
+	fmt.Printf("PSYNC: partial resync of replica %s from offset %d\n", replica.ID, psyncOffset)
+
+	go func() {
+		if err := replica.StartStreaming(); err != nil {
+			fmt.Printf("Failed to send the backlog to replica %s: %v\n", replica.ID, err)
+			conn.Close()
+		}
+	}()
+}
+
+// HandleWait handles WAIT numreplicas timeout. It replies with the number
+// of replicas that acknowledged every write made so far, once numreplicas
+// of them did or after timeout milliseconds (0 blocks forever). Replicas
+// are asked for their offset with REPLCONF GETACK *, sent through the
+// replication stream, and the execution lock is released while waiting
+// for their REPLCONF ACK.
+func (h *ReplicationHandler) HandleWait(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 2 {
+		writeResponse(conn, "WAIT", "-ERR wrong number of arguments for 'wait' command\r\n")
+		return
+	}
+	if !config.IsServerMaster() {
+		writeResponse(conn, "WAIT", parser.ToError("ERR WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated."))
+		return
+	}
+	numReplicas, err := strconv.Atoi(cmd.Args[0])
+	if err != nil {
+		writeResponse(conn, "WAIT", parser.ToError("ERR value is not an integer or out of range"))
+		return
+	}
+	timeoutMs, err := strconv.ParseInt(cmd.Args[1], 10, 64)
+	if err != nil {
+		writeResponse(conn, "WAIT", parser.ToError("ERR timeout is not an integer or out of range"))
+		return
+	}
+	if timeoutMs < 0 {
+		writeResponse(conn, "WAIT", parser.ToError("ERR timeout is negative"))
+		return
+	}
+
+	target := int64(config.Server.MasterReplOffset)
+	acked := replication.Manager.AckedCount(target)
+	if acked >= numReplicas {
+		writeResponse(conn, "WAIT", parser.ToInteger(acked))
+		return
+	}
+
+	ch := replication.Manager.RegisterAckWaiter()
+	defer replication.Manager.UnregisterAckWaiter(ch)
+	replication.Manager.PropagateCommand("REPLCONF", []string{"GETACK", "*"})
+
+	var timer <-chan time.Time
+	if timeoutMs > 0 {
+		t := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+		defer t.Stop()
+		timer = t.C
+	}
+	for acked < numReplicas {
+		timedOut := false
+		h.lock.Unlock()
+		select {
+		case <-ch:
+		case <-timer:
+			timedOut = true
+		}
+		h.lock.Lock()
+		acked = replication.Manager.AckedCount(target)
+		if timedOut {
+			break
+		}
+	}
+	writeResponse(conn, "WAIT", parser.ToInteger(acked))
+}
+
+// replicationAux returns the AUX fields recording the replication ID and
+// offset of the dataset in an RDB file, so a replica restarted from it can
+// partially resynchronize. The caller must hold the execution lock.
+func replicationAux() map[string]string {
+	return map[string]string{
+		"repl-stream-db": "0",
+		"repl-id":        config.Server.MasterReplId,
+		"repl-offset":    strconv.Itoa(config.Server.MasterReplOffset),
+	}
+}
+
+// RunCron runs the periodic replication tasks every second. A master
+// PINGs its replicas every repl-ping-replica-period seconds, and a replica
+// acknowledges its offset with ackMaster, so both ends of an idle link
+// hear from each other well within repl-timeout. It never returns.
+func (h *ReplicationHandler) RunCron(ackMaster func()) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for loops := 1; ; loops++ {
+		<-ticker.C
+		h.lock.Lock()
+		if config.IsServerMaster() {
+			period := max(config.Server.ReplPingReplicaPeriod, 1)
+			if loops%period == 0 && replication.Manager.GetReplicaCount() > 0 {
+				replication.Manager.PropagateCommand("PING", nil)
+			}
+		} else {
+			ackMaster()
+		}
+
+		// Writes made once the backlog is gone are not streamed, so the
+		// offsets of the old stream must not be accepted anymore
+		ttl := time.Duration(config.Server.ReplBacklogTTL) * time.Second
+		if config.IsServerMaster() && replication.Manager.FreeIdleBacklog(ttl) {
+			config.Server.MasterReplId = config.NewReplId()
+			config.ClearReplId2()
+		}
+		h.lock.Unlock()
+	}
+}
@@ -7,9 +7,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/codecrafters-io/redis-starter-go/app/config"
 	"github.com/codecrafters-io/redis-starter-go/app/parser"
-	"github.com/codecrafters-io/redis-starter-go/app/replication"
 	"github.com/codecrafters-io/redis-starter-go/app/repository"
 )
 
@@ -36,9 +34,11 @@ func (h *DataHandler) HandleSet(conn net.Conn, cmd *Command) {
 	key := cmd.Args[0]
 	value := cmd.Args[1]
 
-	var expiration *time.Duration
-
-	// Parse expiration options: EX seconds or PX milliseconds
+	// Parse expiration options: EX seconds, PX milliseconds, or the absolute
+	// EXAT and PXAT Unix times. The deadline is computed once, so the key
+	// expires at the same time here, on replicas and in the append-only file.
+	var expiresAt *time.Time
+	now := time.Now()
 	for i := 2; i < len(cmd.Args); i += 2 {
 		if i+1 >= len(cmd.Args) {
 			response := "-ERR syntax error\r\n"
@@ -59,12 +59,20 @@ func (h *DataHandler) HandleSet(conn net.Conn, cmd *Command) {
 		switch option {
 		case "EX":
 			// Expiration in seconds
-			duration := time.Duration(timeVal) * time.Second
-			expiration = &duration
+			deadline := now.Add(time.Duration(timeVal) * time.Second)
+			expiresAt = &deadline
 		case "PX":
 			// Expiration in milliseconds
-			duration := time.Duration(timeVal) * time.Millisecond
-			expiration = &duration
+			deadline := now.Add(time.Duration(timeVal) * time.Millisecond)
+			expiresAt = &deadline
+		case "EXAT":
+			// Absolute expiration as a Unix time in seconds
+			deadline := time.Unix(int64(timeVal), 0)
+			expiresAt = &deadline
+		case "PXAT":
+			// Absolute expiration as a Unix time in milliseconds
+			deadline := time.UnixMilli(int64(timeVal))
+			expiresAt = &deadline
 		default:
 			response := "-ERR syntax error\r\n"
 			conn.Write([]byte(response))
@@ -73,13 +81,25 @@ func (h *DataHandler) HandleSet(conn net.Conn, cmd *Command) {
 	}
 
 	// Store using repository
-	err := h.repo.Set(key, value, expiration)
+	err := h.repo.Restore(key, value, expiresAt)
 	if err != nil {
 		response := "-ERR failed to set key\r\n"
 		conn.Write([]byte(response))
 		return
 	}
 
+	// Propagate command to replicas if this is a master server. Relative
+	// expirations are logged as an absolute time so replaying the command
+	// later doesn't extend the key's life.
+	args := cmd.Args
+	if expiresAt != nil {
+		args = []string{key, value, "PXAT", strconv.FormatInt(expiresAt.UnixMilli(), 10)}
+	}
+	if err := propagateCommand("SET", args); err != nil {
+		writeResponse(conn, "SET", aofErrorReply(err))
+		return
+	}
+
 	response := "+OK\r\n"
 	_, err = conn.Write([]byte(response))
 	if err != nil {
@@ -87,13 +107,8 @@ func (h *DataHandler) HandleSet(conn net.Conn, cmd *Command) {
 		return
 	}
 
-	// Propagate command to replicas if this is a master server
-	if config.IsServerMaster() {
-		replication.Manager.PropagateCommand("SET", cmd.Args)
-	}
-
-	if expiration != nil {
-		fmt.Printf("SET: %s = %s (expires in %v)\n", key, value, *expiration)
+	if expiresAt != nil {
+		fmt.Printf("SET: %s = %s (expires in %v)\n", key, value, expiresAt.Sub(now))
 	} else {
 		fmt.Printf("SET: %s = %s (no expiration)\n", key, value)
 	}
@@ -108,6 +123,10 @@ func (h *DataHandler) HandleGet(conn net.Conn, cmd *Command) {
 	}
 
 	key := cmd.Args[0]
+	if keyType := h.repo.Type(key); keyType != "string" && keyType != "none" {
+		writeResponse(conn, "GET", parser.ToError(repository.ErrWrongType.Error()))
+		return
+	}
 	value, exists := h.repo.Get(key)
 
 	var response string
@@ -125,3 +144,53 @@ func (h *DataHandler) HandleGet(conn net.Conn, cmd *Command) {
 		return
 	}
 }
+
+// HandleType handles the TYPE command
+func (h *DataHandler) HandleType(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 1 {
+		writeResponse(conn, "TYPE", "-ERR wrong number of arguments for 'type' command\r\n")
+		return
+	}
+
+	writeResponse(conn, "TYPE", parser.ToSimpleString(h.repo.Type(cmd.Args[0])))
+}
+
+// HandleKeys handles the KEYS command
+func (h *DataHandler) HandleKeys(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 1 {
+		writeResponse(conn, "KEYS", "-ERR wrong number of arguments for 'keys' command\r\n")
+		return
+	}
+
+	keys, err := h.repo.Keys(cmd.Args[0])
+	if err != nil {
+		writeResponse(conn, "KEYS", parser.ToError(err.Error()))
+		return
+	}
+	writeResponse(conn, "KEYS", parser.ToBulkStringArray(keys))
+}
+
+// HandleDel handles DEL key [key ...]
+func (h *DataHandler) HandleDel(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 1 {
+		writeResponse(conn, "DEL", "-ERR wrong number of arguments for 'del' command\r\n")
+		return
+	}
+
+	// Keys are deleted even when they are hidden because they expired: a
+	// replica receives DEL from its master for those
+	deleted := 0
+	for _, key := range cmd.Args {
+		if h.repo.Exists(key) {
+			deleted++
+		}
+		h.repo.Delete(key)
+	}
+	if deleted > 0 {
+		if err := propagateCommand("DEL", cmd.Args); err != nil {
+			writeResponse(conn, "DEL", aofErrorReply(err))
+			return
+		}
+	}
+	writeResponse(conn, "DEL", parser.ToInteger(deleted))
+}
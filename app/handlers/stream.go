@@ -0,0 +1,608 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/repository"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// StreamHandler handles stream commands with repository dependency injection
+type StreamHandler struct {
+	repo     repository.KeyValueRepository
+	blocking *BlockingManager
+	exec     sync.Locker // Command execution lock, released while blocked
+}
+
+// NewStreamHandler creates a new stream handler
+func NewStreamHandler(repo repository.KeyValueRepository, blocking *BlockingManager, exec sync.Locker) *StreamHandler {
+	return &StreamHandler{
+		repo:     repo,
+		blocking: blocking,
+		exec:     exec,
+	}
+}
+
+// streamTrimArgs holds the MAXLEN/MINID options shared by XADD and XTRIM
+type streamTrimArgs struct {
+	strategy string // "MAXLEN" or "MINID", empty when no trimming was requested
+	approx   bool
+	maxLen   uint64
+	minID    storage.StreamID
+	limit    int64
+	limitSet bool
+}
+
+// parseTrimArgs parses "MAXLEN|MINID [=|~] threshold [LIMIT count]" starting
+// at args[i] and returns the index of the first unconsumed argument
+func parseTrimArgs(args []string, i int, trim *streamTrimArgs) (int, error) {
+	trim.strategy = strings.ToUpper(args[i])
+	i++
+	if i < len(args) && (args[i] == "~" || args[i] == "=") {
+		trim.approx = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return i, fmt.Errorf("ERR syntax error")
+	}
+
+	if trim.strategy == "MAXLEN" {
+		maxLen, err := strconv.ParseInt(args[i], 10, 64)
+		if err != nil {
+			return i, fmt.Errorf("ERR value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return i, fmt.Errorf("ERR The MAXLEN argument must be >= 0.")
+		}
+		trim.maxLen = uint64(maxLen)
+	} else {
+		minID, err := storage.ParseStreamID(args[i], 0)
+		if err != nil {
+			return i, err
+		}
+		trim.minID = minID
+	}
+	i++
+
+	if i+1 < len(args) && strings.ToUpper(args[i]) == "LIMIT" {
+		limit, err := strconv.ParseInt(args[i+1], 10, 64)
+		if err != nil || limit < 0 {
+			return i, fmt.Errorf("ERR The LIMIT argument must be >= 0.")
+		}
+		trim.limit = limit
+		trim.limitSet = true
+		i += 2
+	}
+
+	if trim.limitSet && !trim.approx {
+		return i, fmt.Errorf("ERR syntax error, LIMIT cannot be used without the special ~ option")
+	}
+	if trim.approx && !trim.limitSet {
+		trim.limit = 100 * storage.StreamNodeMaxEntries
+	}
+	return i, nil
+}
+
+// apply trims the stream and returns the number of evicted entries
+func (trim *streamTrimArgs) apply(stream *storage.Stream) int64 {
+	switch trim.strategy {
+	case "MAXLEN":
+		return stream.TrimByLength(trim.maxLen, trim.approx, trim.limit)
+	case "MINID":
+		return stream.TrimByMinID(trim.minID, trim.approx, trim.limit)
+	}
+	return 0
+}
+
+// effectiveArgs returns exact trimming arguments that reproduce the state of
+// the stream after trimming, so replicas end up with the same entries even
+// when the master trimmed approximately
+func (trim *streamTrimArgs) effectiveArgs(stream *storage.Stream) []string {
+	if trim.strategy == "MAXLEN" {
+		return []string{"MAXLEN", "=", strconv.FormatUint(stream.Len(), 10)}
+	}
+	threshold := storage.MaxStreamID
+	if stream.Len() > 0 {
+		threshold = stream.FirstID()
+	}
+	return []string{"MINID", "=", threshold.String()}
+}
+
+// HandleXAdd handles XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func (h *StreamHandler) HandleXAdd(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 4 {
+		writeResponse(conn, "XADD", "-ERR wrong number of arguments for 'xadd' command\r\n")
+		return
+	}
+
+	key := cmd.Args[0]
+	noMkStream := false
+	var trim streamTrimArgs
+
+	i := 1
+	for i < len(cmd.Args) {
+		option := strings.ToUpper(cmd.Args[i])
+		if option == "NOMKSTREAM" {
+			noMkStream = true
+			i++
+			continue
+		}
+		if option != "MAXLEN" && option != "MINID" {
+			break
+		}
+		next, err := parseTrimArgs(cmd.Args, i, &trim)
+		if err != nil {
+			writeResponse(conn, "XADD", parser.ToError(err.Error()))
+			return
+		}
+		i = next
+	}
+
+	fields := cmd.Args[min(i+1, len(cmd.Args)):]
+	if i >= len(cmd.Args) || len(fields) == 0 || len(fields)%2 != 0 {
+		writeResponse(conn, "XADD", "-ERR wrong number of arguments for 'xadd' command\r\n")
+		return
+	}
+
+	stream, err := h.repo.GetStream(key, false)
+	if err != nil {
+		writeResponse(conn, "XADD", parser.ToError(err.Error()))
+		return
+	}
+	if stream == nil && noMkStream {
+		writeResponse(conn, "XADD", parser.ToNullBulkString())
+		return
+	}
+
+	current := stream
+	if current == nil {
+		current = storage.NewStream()
+	}
+	id, err := nextStreamID(current, cmd.Args[i])
+	if err != nil {
+		writeResponse(conn, "XADD", parser.ToError(err.Error()))
+		return
+	}
+
+	if stream == nil {
+		if stream, err = h.repo.GetStream(key, true); err != nil {
+			writeResponse(conn, "XADD", parser.ToError(err.Error()))
+			return
+		}
+	}
+	stream.Add(id, fields)
+	trim.apply(stream)
+	h.blocking.SignalKey(key)
+
+	// Propagate with the generated ID and exact trimming so replicas converge
+	args := []string{key}
+	if trim.strategy != "" {
+		args = append(args, trim.effectiveArgs(stream)...)
+	}
+	args = append(args, id.String())
+	args = append(args, fields...)
+	if err := propagateCommand("XADD", args); err != nil {
+		writeResponse(conn, "XADD", aofErrorReply(err))
+		return
+	}
+	writeResponse(conn, "XADD", parser.ToBulkString(id.String()))
+
+	fmt.Printf("XADD: %s %s (%d fields)\n", key, id, len(fields)/2)
+}
+
+// nextStreamID resolves the ID argument of XADD ("*", "<ms>-*" or explicit)
+func nextStreamID(stream *storage.Stream, arg string) (storage.StreamID, error) {
+	var (
+		ms       uint64
+		seq      uint64
+		seqGiven bool
+	)
+
+	switch {
+	case arg == "*":
+		ms = uint64(time.Now().UnixMilli())
+		if last := stream.LastID(); last.Ms > ms {
+			ms = last.Ms
+		}
+	case strings.HasSuffix(arg, "-*"):
+		parsed, err := strconv.ParseUint(strings.TrimSuffix(arg, "-*"), 10, 64)
+		if err != nil {
+			return storage.StreamID{}, storage.ErrInvalidStreamID
+		}
+		ms = parsed
+	default:
+		id, err := storage.ParseStreamID(arg, 0)
+		if err != nil {
+			return storage.StreamID{}, err
+		}
+		if id.IsZero() {
+			return storage.StreamID{}, fmt.Errorf("ERR The ID specified in XADD must be greater than 0-0")
+		}
+		ms, seq, seqGiven = id.Ms, id.Seq, true
+	}
+
+	id, ok := stream.NextID(ms, seq, seqGiven)
+	if !ok {
+		if arg == "*" {
+			return storage.StreamID{}, fmt.Errorf("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return storage.StreamID{}, fmt.Errorf("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+	}
+	return id, nil
+}
+
+// HandleXLen handles XLEN key
+func (h *StreamHandler) HandleXLen(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) != 1 {
+		writeResponse(conn, "XLEN", "-ERR wrong number of arguments for 'xlen' command\r\n")
+		return
+	}
+
+	stream, err := h.repo.GetStream(cmd.Args[0], false)
+	if err != nil {
+		writeResponse(conn, "XLEN", parser.ToError(err.Error()))
+		return
+	}
+
+	length := 0
+	if stream != nil {
+		length = int(stream.Len())
+	}
+	writeResponse(conn, "XLEN", parser.ToInteger(length))
+}
+
+// HandleXRange handles XRANGE key start end [COUNT count]
+func (h *StreamHandler) HandleXRange(conn net.Conn, cmd *Command) {
+	h.handleRange(conn, cmd, "xrange", false)
+}
+
+// HandleXRevRange handles XREVRANGE key end start [COUNT count]
+func (h *StreamHandler) HandleXRevRange(conn net.Conn, cmd *Command) {
+	h.handleRange(conn, cmd, "xrevrange", true)
+}
+
+// handleRange implements XRANGE and XREVRANGE
+func (h *StreamHandler) handleRange(conn net.Conn, cmd *Command, name string, reverse bool) {
+	if len(cmd.Args) != 3 && len(cmd.Args) != 5 {
+		writeResponse(conn, strings.ToUpper(name), "-ERR wrong number of arguments for '"+name+"' command\r\n")
+		return
+	}
+
+	startArg, endArg := cmd.Args[1], cmd.Args[2]
+	if reverse {
+		startArg, endArg = endArg, startArg
+	}
+
+	start, err := parseRangeID(startArg, true)
+	if err != nil {
+		writeResponse(conn, strings.ToUpper(name), parser.ToError(err.Error()))
+		return
+	}
+	end, err := parseRangeID(endArg, false)
+	if err != nil {
+		writeResponse(conn, strings.ToUpper(name), parser.ToError(err.Error()))
+		return
+	}
+
+	count := -1
+	if len(cmd.Args) == 5 {
+		if strings.ToUpper(cmd.Args[3]) != "COUNT" {
+			writeResponse(conn, strings.ToUpper(name), "-ERR syntax error\r\n")
+			return
+		}
+		count, err = strconv.Atoi(cmd.Args[4])
+		if err != nil {
+			writeResponse(conn, strings.ToUpper(name), "-ERR value is not an integer or out of range\r\n")
+			return
+		}
+		if count <= 0 {
+			writeResponse(conn, strings.ToUpper(name), parser.ToArray(nil))
+			return
+		}
+	}
+
+	stream, err := h.repo.GetStream(cmd.Args[0], false)
+	if err != nil {
+		writeResponse(conn, strings.ToUpper(name), parser.ToError(err.Error()))
+		return
+	}
+
+	var entries []storage.StreamEntry
+	if stream != nil {
+		entries = stream.Range(start, end, count, reverse)
+	}
+	writeResponse(conn, strings.ToUpper(name), encodeStreamEntries(entries))
+}
+
+// parseRangeID parses an XRANGE boundary: "-", "+", an exclusive "(id" or
+// an incomplete "<ms>" which expands to the lowest or highest sequence
+func parseRangeID(arg string, isStart bool) (storage.StreamID, error) {
+	exclusive := strings.HasPrefix(arg, "(")
+	if exclusive {
+		arg = arg[1:]
+	}
+
+	var id storage.StreamID
+	switch arg {
+	case "-":
+		id = storage.StreamID{}
+	case "+":
+		id = storage.MaxStreamID
+	default:
+		missingSeq := uint64(0)
+		if !isStart {
+			missingSeq = storage.MaxStreamID.Seq
+		}
+		parsed, err := storage.ParseStreamID(arg, missingSeq)
+		if err != nil {
+			return storage.StreamID{}, err
+		}
+		id = parsed
+	}
+
+	if !exclusive {
+		return id, nil
+	}
+	var ok bool
+	if isStart {
+		if id, ok = id.Incr(); !ok {
+			return storage.StreamID{}, fmt.Errorf("ERR invalid start ID for the interval")
+		}
+	} else {
+		if id, ok = id.Decr(); !ok {
+			return storage.StreamID{}, fmt.Errorf("ERR invalid end ID for the interval")
+		}
+	}
+	return id, nil
+}
+
+// HandleXTrim handles XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func (h *StreamHandler) HandleXTrim(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 3 {
+		writeResponse(conn, "XTRIM", "-ERR wrong number of arguments for 'xtrim' command\r\n")
+		return
+	}
+
+	key := cmd.Args[0]
+	strategy := strings.ToUpper(cmd.Args[1])
+	if strategy != "MAXLEN" && strategy != "MINID" {
+		writeResponse(conn, "XTRIM", "-ERR syntax error\r\n")
+		return
+	}
+
+	var trim streamTrimArgs
+	next, err := parseTrimArgs(cmd.Args, 1, &trim)
+	if err != nil {
+		writeResponse(conn, "XTRIM", parser.ToError(err.Error()))
+		return
+	}
+	if next != len(cmd.Args) {
+		writeResponse(conn, "XTRIM", "-ERR syntax error\r\n")
+		return
+	}
+
+	stream, err := h.repo.GetStream(key, false)
+	if err != nil {
+		writeResponse(conn, "XTRIM", parser.ToError(err.Error()))
+		return
+	}
+	if stream == nil {
+		writeResponse(conn, "XTRIM", parser.ToInteger(0))
+		return
+	}
+
+	removed := trim.apply(stream)
+	if removed > 0 {
+		if err := propagateCommand("XTRIM", append([]string{key}, trim.effectiveArgs(stream)...)); err != nil {
+			writeResponse(conn, "XTRIM", aofErrorReply(err))
+			return
+		}
+	}
+	writeResponse(conn, "XTRIM", parser.ToInteger(int(removed)))
+	fmt.Printf("XTRIM: %s removed %d entries\n", key, removed)
+}
+
+// HandleXDel handles XDEL key id [id ...]
+func (h *StreamHandler) HandleXDel(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 2 {
+		writeResponse(conn, "XDEL", "-ERR wrong number of arguments for 'xdel' command\r\n")
+		return
+	}
+
+	key := cmd.Args[0]
+	ids := make([]storage.StreamID, 0, len(cmd.Args)-1)
+	for _, arg := range cmd.Args[1:] {
+		id, err := storage.ParseStreamID(arg, 0)
+		if err != nil {
+			writeResponse(conn, "XDEL", parser.ToError(err.Error()))
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	stream, err := h.repo.GetStream(key, false)
+	if err != nil {
+		writeResponse(conn, "XDEL", parser.ToError(err.Error()))
+		return
+	}
+
+	deleted := 0
+	if stream != nil {
+		for _, id := range ids {
+			if stream.Delete(id) {
+				deleted++
+			}
+		}
+	}
+	if deleted > 0 {
+		if err := propagateCommand("XDEL", cmd.Args); err != nil {
+			writeResponse(conn, "XDEL", aofErrorReply(err))
+			return
+		}
+	}
+	writeResponse(conn, "XDEL", parser.ToInteger(deleted))
+}
+
+// HandleXRead handles XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+func (h *StreamHandler) HandleXRead(conn net.Conn, cmd *Command) {
+	count := -1
+	block := false
+	var timeout time.Duration
+	streamsIdx := -1
+
+	for i := 0; i < len(cmd.Args) && streamsIdx < 0; i++ {
+		switch strings.ToUpper(cmd.Args[i]) {
+		case "COUNT":
+			if i+1 >= len(cmd.Args) {
+				writeResponse(conn, "XREAD", "-ERR syntax error\r\n")
+				return
+			}
+			value, err := strconv.Atoi(cmd.Args[i+1])
+			if err != nil {
+				writeResponse(conn, "XREAD", "-ERR value is not an integer or out of range\r\n")
+				return
+			}
+			count = value
+			i++
+		case "BLOCK":
+			if i+1 >= len(cmd.Args) {
+				writeResponse(conn, "XREAD", "-ERR syntax error\r\n")
+				return
+			}
+			var err error
+			if timeout, err = parseBlockTimeout(cmd.Args[i+1]); err != nil {
+				writeResponse(conn, "XREAD", parser.ToError(err.Error()))
+				return
+			}
+			block = true
+			i++
+		case "STREAMS":
+			streamsIdx = i + 1
+		default:
+			writeResponse(conn, "XREAD", "-ERR syntax error\r\n")
+			return
+		}
+	}
+
+	if streamsIdx < 0 || streamsIdx >= len(cmd.Args) || (len(cmd.Args)-streamsIdx)%2 != 0 {
+		writeResponse(conn, "XREAD", "-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n")
+		return
+	}
+
+	numKeys := (len(cmd.Args) - streamsIdx) / 2
+	keys := cmd.Args[streamsIdx : streamsIdx+numKeys]
+	ids := make([]storage.StreamID, numKeys)
+	for i, arg := range cmd.Args[streamsIdx+numKeys:] {
+		if arg == "$" {
+			stream, err := h.repo.GetStream(keys[i], false)
+			if err != nil {
+				writeResponse(conn, "XREAD", parser.ToError(err.Error()))
+				return
+			}
+			if stream != nil {
+				ids[i] = stream.LastID()
+			}
+			continue
+		}
+		id, err := storage.ParseStreamID(arg, 0)
+		if err != nil {
+			writeResponse(conn, "XREAD", parser.ToError(err.Error()))
+			return
+		}
+		ids[i] = id
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		response, found, err := h.readStreams(keys, ids, count)
+		if err != nil {
+			writeResponse(conn, "XREAD", parser.ToError(err.Error()))
+			return
+		}
+		if found {
+			writeResponse(conn, "XREAD", response)
+			return
+		}
+		if !block {
+			writeResponse(conn, "XREAD", parser.ToNullArray())
+			return
+		}
+
+		remaining := time.Duration(0)
+		if timeout > 0 {
+			if remaining = time.Until(deadline); remaining <= 0 {
+				writeResponse(conn, "XREAD", parser.ToNullArray())
+				return
+			}
+		}
+		ch := h.blocking.Register(keys)
+		signalled := h.blocking.Wait(h.exec, ch, remaining)
+		h.blocking.Unregister(keys, ch)
+		if !signalled {
+			writeResponse(conn, "XREAD", parser.ToNullArray())
+			return
+		}
+	}
+}
+
+// readStreams collects entries newer than ids from each stream in keys
+func (h *StreamHandler) readStreams(keys []string, ids []storage.StreamID, count int) (string, bool, error) {
+	var results []string
+	for i, key := range keys {
+		stream, err := h.repo.GetStream(key, false)
+		if err != nil {
+			return "", false, err
+		}
+		if stream == nil {
+			continue
+		}
+		start, ok := ids[i].Incr()
+		if !ok {
+			continue
+		}
+		entries := stream.Range(start, storage.MaxStreamID, count, false)
+		if len(entries) == 0 {
+			continue
+		}
+		results = append(results, parser.ToArray([]string{
+			parser.ToBulkString(key),
+			encodeStreamEntries(entries),
+		}))
+	}
+	return parser.ToArray(results), len(results) > 0, nil
+}
+
+// parseBlockTimeout parses the BLOCK argument in milliseconds
+func parseBlockTimeout(arg string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ERR timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return 0, fmt.Errorf("ERR timeout is negative")
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// encodeStreamEntry encodes an entry as [id, [field, value, ...]]
+func encodeStreamEntry(entry storage.StreamEntry) string {
+	return parser.ToArray([]string{
+		parser.ToBulkString(entry.ID.String()),
+		parser.ToBulkStringArray(entry.Fields),
+	})
+}
+
+// encodeStreamEntries encodes a list of entries as a RESP array
+func encodeStreamEntries(entries []storage.StreamEntry) string {
+	elements := make([]string, len(entries))
+	for i, entry := range entries {
+		elements[i] = encodeStreamEntry(entry)
+	}
+	return parser.ToArray(elements)
+}
@@ -0,0 +1,1055 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// lookupGroup returns the stream at key and its consumer group, or an error
+// reply when either does not exist
+func (h *StreamHandler) lookupGroup(key, groupName string) (*storage.Stream, *storage.StreamGroup, string) {
+	stream, err := h.repo.GetStream(key, false)
+	if err != nil {
+		return nil, nil, parser.ToError(err.Error())
+	}
+	var group *storage.StreamGroup
+	if stream != nil {
+		group = stream.Group(groupName)
+	}
+	if group == nil {
+		return nil, nil, parser.ToError(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, groupName))
+	}
+	return stream, group, ""
+}
+
+// keepFirstError records err in logErr unless an earlier failure to log a
+// write of the same command is there already. A command propagating several
+// writes goes on with the others, and replies with the first failure.
+func keepFirstError(logErr *error, err error) {
+	if *logErr == nil {
+		*logErr = err
+	}
+}
+
+// propagateXClaim replicates the state of a pending entry as an XCLAIM with
+// absolute delivery time and counter, the way Redis does for XREADGROUP
+func propagateXClaim(key string, group *storage.StreamGroup, nack *storage.StreamNACK) error {
+	return propagateCommand("XCLAIM", []string{
+		key, group.Name, nack.Consumer.Name, "0", nack.ID.String(),
+		"TIME", strconv.FormatInt(nack.DeliveryTime, 10),
+		"RETRYCOUNT", strconv.FormatUint(nack.DeliveryCount, 10),
+		"FORCE", "JUSTID",
+		"LASTID", group.LastID.String(),
+	})
+}
+
+// propagateGroupID replicates the last delivered ID of a consumer group
+func propagateGroupID(key string, group *storage.StreamGroup) error {
+	return propagateCommand("XGROUP", []string{
+		"SETID", key, group.Name, group.LastID.String(),
+		"ENTRIESREAD", strconv.FormatInt(group.EntriesRead, 10),
+	})
+}
+
+// lookupOrCreateConsumer returns the named consumer, creating (and
+// replicating the creation of) it if needed
+func lookupOrCreateConsumer(key string, group *storage.StreamGroup, name string, now int64) (*storage.StreamConsumer, error) {
+	consumer, created := group.CreateConsumer(name, now)
+	if created {
+		return consumer, propagateCommand("XGROUP", []string{"CREATECONSUMER", key, group.Name, name})
+	}
+	return consumer, nil
+}
+
+// parseEntriesRead parses the ENTRIESREAD option of XGROUP
+func parseEntriesRead(arg string) (int64, error) {
+	entriesRead, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("ERR value is not an integer or out of range")
+	}
+	if entriesRead < -1 {
+		return 0, fmt.Errorf("ERR value for ENTRIESREAD must be positive or -1")
+	}
+	return entriesRead, nil
+}
+
+// HandleXGroup handles XGROUP CREATE|SETID|DESTROY|CREATECONSUMER|DELCONSUMER
+func (h *StreamHandler) HandleXGroup(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 1 {
+		writeResponse(conn, "XGROUP", "-ERR wrong number of arguments for 'xgroup' command\r\n")
+		return
+	}
+
+	subcommand := strings.ToUpper(cmd.Args[0])
+	arityError := fmt.Sprintf("-ERR unknown subcommand or wrong number of arguments for '%s'. Try XGROUP HELP.\r\n", cmd.Args[0])
+
+	switch subcommand {
+	case "CREATE":
+		if len(cmd.Args) < 4 {
+			writeResponse(conn, "XGROUP", arityError)
+			return
+		}
+	case "SETID":
+		if len(cmd.Args) != 4 && len(cmd.Args) != 6 {
+			writeResponse(conn, "XGROUP", arityError)
+			return
+		}
+	case "DESTROY":
+		if len(cmd.Args) != 3 {
+			writeResponse(conn, "XGROUP", arityError)
+			return
+		}
+	case "CREATECONSUMER", "DELCONSUMER":
+		if len(cmd.Args) != 4 {
+			writeResponse(conn, "XGROUP", arityError)
+			return
+		}
+	default:
+		writeResponse(conn, "XGROUP", fmt.Sprintf("-ERR unknown subcommand '%s'. Try XGROUP HELP.\r\n", cmd.Args[0]))
+		return
+	}
+
+	key, groupName := cmd.Args[1], cmd.Args[2]
+
+	// Parse the options of CREATE and SETID
+	mkStream := false
+	entriesRead := int64(-1)
+	if subcommand == "CREATE" || subcommand == "SETID" {
+		for i := 4; i < len(cmd.Args); i++ {
+			switch strings.ToUpper(cmd.Args[i]) {
+			case "MKSTREAM":
+				if subcommand != "CREATE" {
+					writeResponse(conn, "XGROUP", "-ERR syntax error\r\n")
+					return
+				}
+				mkStream = true
+			case "ENTRIESREAD":
+				if i+1 >= len(cmd.Args) {
+					writeResponse(conn, "XGROUP", "-ERR syntax error\r\n")
+					return
+				}
+				value, err := parseEntriesRead(cmd.Args[i+1])
+				if err != nil {
+					writeResponse(conn, "XGROUP", parser.ToError(err.Error()))
+					return
+				}
+				entriesRead = value
+				i++
+			default:
+				writeResponse(conn, "XGROUP", "-ERR syntax error\r\n")
+				return
+			}
+		}
+	}
+
+	stream, err := h.repo.GetStream(key, false)
+	if err != nil {
+		writeResponse(conn, "XGROUP", parser.ToError(err.Error()))
+		return
+	}
+	if stream == nil && !(subcommand == "CREATE" && mkStream) {
+		writeResponse(conn, "XGROUP", "-ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.\r\n")
+		return
+	}
+
+	var group *storage.StreamGroup
+	if subcommand != "CREATE" {
+		if group = stream.Group(groupName); group == nil {
+			writeResponse(conn, "XGROUP", parser.ToError(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", groupName, key)))
+			return
+		}
+	}
+
+	// Resolve the ID argument of CREATE and SETID
+	var id storage.StreamID
+	if subcommand == "CREATE" || subcommand == "SETID" {
+		if cmd.Args[3] == "$" {
+			if stream != nil {
+				id = stream.LastID()
+			}
+		} else {
+			parsed, err := storage.ParseStreamID(cmd.Args[3], 0)
+			if err != nil {
+				writeResponse(conn, "XGROUP", parser.ToError(err.Error()))
+				return
+			}
+			id = parsed
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	var reply string
+	switch subcommand {
+	case "CREATE":
+		if stream == nil {
+			if stream, err = h.repo.GetStream(key, true); err != nil {
+				writeResponse(conn, "XGROUP", parser.ToError(err.Error()))
+				return
+			}
+		}
+		if _, created := stream.CreateGroup(groupName, id, entriesRead); !created {
+			writeResponse(conn, "XGROUP", "-BUSYGROUP Consumer Group name already exists\r\n")
+			return
+		}
+		reply = parser.ToSimpleString("OK")
+
+	case "SETID":
+		group.LastID = id
+		group.EntriesRead = entriesRead
+		reply = parser.ToSimpleString("OK")
+
+	case "DESTROY":
+		stream.DestroyGroup(groupName)
+		h.blocking.SignalKey(key)
+		reply = parser.ToInteger(1)
+
+	case "CREATECONSUMER":
+		_, created := group.CreateConsumer(cmd.Args[3], now)
+		if !created {
+			writeResponse(conn, "XGROUP", parser.ToInteger(0))
+			return
+		}
+		reply = parser.ToInteger(1)
+
+	case "DELCONSUMER":
+		pending, existed := group.DeleteConsumer(cmd.Args[3])
+		if !existed {
+			writeResponse(conn, "XGROUP", parser.ToInteger(pending))
+			return
+		}
+		reply = parser.ToInteger(pending)
+	}
+
+	if err := propagateCommand("XGROUP", cmd.Args); err != nil {
+		writeResponse(conn, "XGROUP", aofErrorReply(err))
+		return
+	}
+	writeResponse(conn, "XGROUP", reply)
+
+	fmt.Printf("XGROUP %s: %s %s\n", subcommand, key, groupName)
+}
+
+// HandleXReadGroup handles XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...]
+func (h *StreamHandler) HandleXReadGroup(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 6 || strings.ToUpper(cmd.Args[0]) != "GROUP" {
+		writeResponse(conn, "XREADGROUP", "-ERR syntax error\r\n")
+		return
+	}
+
+	groupName, consumerName := cmd.Args[1], cmd.Args[2]
+	count := -1
+	block := false
+	noAck := false
+	var timeout time.Duration
+	streamsIdx := -1
+
+	for i := 3; i < len(cmd.Args) && streamsIdx < 0; i++ {
+		switch strings.ToUpper(cmd.Args[i]) {
+		case "COUNT":
+			if i+1 >= len(cmd.Args) {
+				writeResponse(conn, "XREADGROUP", "-ERR syntax error\r\n")
+				return
+			}
+			value, err := strconv.Atoi(cmd.Args[i+1])
+			if err != nil {
+				writeResponse(conn, "XREADGROUP", "-ERR value is not an integer or out of range\r\n")
+				return
+			}
+			count = value
+			i++
+		case "BLOCK":
+			if i+1 >= len(cmd.Args) {
+				writeResponse(conn, "XREADGROUP", "-ERR syntax error\r\n")
+				return
+			}
+			var err error
+			if timeout, err = parseBlockTimeout(cmd.Args[i+1]); err != nil {
+				writeResponse(conn, "XREADGROUP", parser.ToError(err.Error()))
+				return
+			}
+			block = true
+			i++
+		case "NOACK":
+			noAck = true
+		case "STREAMS":
+			streamsIdx = i + 1
+		default:
+			writeResponse(conn, "XREADGROUP", "-ERR syntax error\r\n")
+			return
+		}
+	}
+
+	if streamsIdx < 0 || streamsIdx >= len(cmd.Args) || (len(cmd.Args)-streamsIdx)%2 != 0 {
+		writeResponse(conn, "XREADGROUP", "-ERR Unbalanced 'xreadgroup' list of streams: for each stream key an ID or '>' must be specified.\r\n")
+		return
+	}
+
+	numKeys := (len(cmd.Args) - streamsIdx) / 2
+	keys := cmd.Args[streamsIdx : streamsIdx+numKeys]
+	ids := make([]storage.StreamID, numKeys)
+	newOnly := make([]bool, numKeys)
+	for i, arg := range cmd.Args[streamsIdx+numKeys:] {
+		switch arg {
+		case ">":
+			newOnly[i] = true
+		case "$":
+			writeResponse(conn, "XREADGROUP", "-ERR The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.\r\n")
+			return
+		default:
+			id, err := storage.ParseStreamID(arg, 0)
+			if err != nil {
+				writeResponse(conn, "XREADGROUP", parser.ToError(err.Error()))
+				return
+			}
+			ids[i] = id
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var results []string
+		var logErr error
+		found := false
+		for i, key := range keys {
+			stream, group, errReply := h.lookupGroup(key, groupName)
+			if errReply != "" {
+				writeResponse(conn, "XREADGROUP", parser.ToError(fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s' in XREADGROUP with GROUP option", key, groupName)))
+				return
+			}
+
+			now := time.Now().UnixMilli()
+			consumer, err := lookupOrCreateConsumer(key, group, consumerName, now)
+			keepFirstError(&logErr, err)
+			consumer.SeenTime = now
+
+			var reply string
+			if newOnly[i] {
+				entries := stream.ReadGroup(group, consumer, count, noAck, now)
+				if len(entries) == 0 {
+					continue
+				}
+				for _, entry := range entries {
+					if nack := group.NACK(entry.ID); nack != nil && !noAck {
+						keepFirstError(&logErr, propagateXClaim(key, group, nack))
+					}
+				}
+				keepFirstError(&logErr, propagateGroupID(key, group))
+				reply = encodeStreamEntries(entries)
+			} else {
+				reply, err = h.readConsumerHistory(key, stream, group, consumer, ids[i], count, now)
+				keepFirstError(&logErr, err)
+			}
+
+			found = true
+			results = append(results, parser.ToArray([]string{parser.ToBulkString(key), reply}))
+		}
+
+		if logErr != nil {
+			writeResponse(conn, "XREADGROUP", aofErrorReply(logErr))
+			return
+		}
+		if found {
+			writeResponse(conn, "XREADGROUP", parser.ToArray(results))
+			return
+		}
+		if !block {
+			writeResponse(conn, "XREADGROUP", parser.ToNullArray())
+			return
+		}
+
+		remaining := time.Duration(0)
+		if timeout > 0 {
+			if remaining = time.Until(deadline); remaining <= 0 {
+				writeResponse(conn, "XREADGROUP", parser.ToNullArray())
+				return
+			}
+		}
+		ch := h.blocking.Register(keys)
+		signalled := h.blocking.Wait(h.exec, ch, remaining)
+		h.blocking.Unregister(keys, ch)
+		if !signalled {
+			writeResponse(conn, "XREADGROUP", parser.ToNullArray())
+			return
+		}
+	}
+}
+
+// readConsumerHistory re-delivers entries from the consumer's pending list
+// with IDs greater than after. Deleted entries are reported with nil fields.
+// The error is the first failure to log the new delivery of an entry.
+func (h *StreamHandler) readConsumerHistory(key string, stream *storage.Stream, group *storage.StreamGroup, consumer *storage.StreamConsumer, after storage.StreamID, count int, now int64) (string, error) {
+	start, ok := after.Incr()
+	if !ok {
+		return parser.ToArray(nil), nil
+	}
+
+	var nacks []*storage.StreamNACK
+	consumer.PEL.Ascend(start.Bytes(), func(_ []byte, nack *storage.StreamNACK) bool {
+		nacks = append(nacks, nack)
+		return count <= 0 || len(nacks) < count
+	})
+
+	var logErr error
+	elements := make([]string, 0, len(nacks))
+	for _, nack := range nacks {
+		entry, exists := stream.Get(nack.ID)
+		if exists {
+			elements = append(elements, encodeStreamEntry(entry))
+		} else {
+			elements = append(elements, parser.ToArray([]string{parser.ToBulkString(nack.ID.String()), parser.ToNullArray()}))
+		}
+		nack.DeliveryTime = now
+		nack.DeliveryCount++
+		keepFirstError(&logErr, propagateXClaim(key, group, nack))
+	}
+	return parser.ToArray(elements), logErr
+}
+
+// HandleXAck handles XACK key group id [id ...]
+func (h *StreamHandler) HandleXAck(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 3 {
+		writeResponse(conn, "XACK", "-ERR wrong number of arguments for 'xack' command\r\n")
+		return
+	}
+
+	ids := make([]storage.StreamID, 0, len(cmd.Args)-2)
+	for _, arg := range cmd.Args[2:] {
+		id, err := storage.ParseStreamID(arg, 0)
+		if err != nil {
+			writeResponse(conn, "XACK", parser.ToError(err.Error()))
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	stream, err := h.repo.GetStream(cmd.Args[0], false)
+	if err != nil {
+		writeResponse(conn, "XACK", parser.ToError(err.Error()))
+		return
+	}
+
+	acked := 0
+	if stream != nil {
+		if group := stream.Group(cmd.Args[1]); group != nil {
+			for _, id := range ids {
+				if group.Ack(id) {
+					acked++
+				}
+			}
+		}
+	}
+	if acked > 0 {
+		if err := propagateCommand("XACK", cmd.Args); err != nil {
+			writeResponse(conn, "XACK", aofErrorReply(err))
+			return
+		}
+	}
+	writeResponse(conn, "XACK", parser.ToInteger(acked))
+}
+
+// HandleXPending handles XPENDING key group [[IDLE min-idle-time] start end count [consumer]]
+func (h *StreamHandler) HandleXPending(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 2 {
+		writeResponse(conn, "XPENDING", "-ERR wrong number of arguments for 'xpending' command\r\n")
+		return
+	}
+
+	key, groupName := cmd.Args[0], cmd.Args[1]
+
+	// Parse the extended form arguments
+	extended := len(cmd.Args) > 2
+	var (
+		minIdle      int64
+		start, end   storage.StreamID
+		count        int
+		consumerName string
+	)
+	if extended {
+		i := 2
+		if strings.ToUpper(cmd.Args[i]) == "IDLE" {
+			if i+1 >= len(cmd.Args) {
+				writeResponse(conn, "XPENDING", "-ERR syntax error\r\n")
+				return
+			}
+			value, err := strconv.ParseInt(cmd.Args[i+1], 10, 64)
+			if err != nil {
+				writeResponse(conn, "XPENDING", "-ERR value is not an integer or out of range\r\n")
+				return
+			}
+			minIdle = value
+			i += 2
+		}
+		if remaining := len(cmd.Args) - i; remaining != 3 && remaining != 4 {
+			writeResponse(conn, "XPENDING", "-ERR syntax error\r\n")
+			return
+		}
+
+		var err error
+		if start, err = parseRangeID(cmd.Args[i], true); err != nil {
+			writeResponse(conn, "XPENDING", parser.ToError(err.Error()))
+			return
+		}
+		if end, err = parseRangeID(cmd.Args[i+1], false); err != nil {
+			writeResponse(conn, "XPENDING", parser.ToError(err.Error()))
+			return
+		}
+		if count, err = strconv.Atoi(cmd.Args[i+2]); err != nil {
+			writeResponse(conn, "XPENDING", "-ERR value is not an integer or out of range\r\n")
+			return
+		}
+		if i+3 < len(cmd.Args) {
+			consumerName = cmd.Args[i+3]
+		}
+	}
+
+	_, group, errReply := h.lookupGroup(key, groupName)
+	if errReply != "" {
+		writeResponse(conn, "XPENDING", errReply)
+		return
+	}
+
+	if !extended {
+		writeResponse(conn, "XPENDING", encodePendingSummary(group))
+		return
+	}
+
+	pel := &group.PEL
+	if consumerName != "" {
+		consumer := group.Consumer(consumerName)
+		if consumer == nil {
+			writeResponse(conn, "XPENDING", parser.ToArray(nil))
+			return
+		}
+		pel = &consumer.PEL
+	}
+
+	now := time.Now().UnixMilli()
+	var elements []string
+	if count > 0 && start.Compare(end) <= 0 {
+		pel.Ascend(start.Bytes(), func(_ []byte, nack *storage.StreamNACK) bool {
+			if nack.ID.Compare(end) > 0 {
+				return false
+			}
+			idle := now - nack.DeliveryTime
+			if idle < minIdle {
+				return true
+			}
+			elements = append(elements, parser.ToArray([]string{
+				parser.ToBulkString(nack.ID.String()),
+				parser.ToBulkString(nack.Consumer.Name),
+				parser.ToInteger(int(idle)),
+				parser.ToInteger(int(nack.DeliveryCount)),
+			}))
+			return len(elements) < count
+		})
+	}
+	writeResponse(conn, "XPENDING", parser.ToArray(elements))
+}
+
+// encodePendingSummary encodes the summary form of XPENDING
+func encodePendingSummary(group *storage.StreamGroup) string {
+	if group.PEL.Len() == 0 {
+		return parser.ToArray([]string{
+			parser.ToInteger(0),
+			parser.ToNullBulkString(),
+			parser.ToNullBulkString(),
+			parser.ToNullArray(),
+		})
+	}
+
+	firstKey, _, _ := group.PEL.First()
+	lastKey, _, _ := group.PEL.Last()
+
+	var consumers []string
+	for _, consumer := range group.Consumers() {
+		if consumer.PEL.Len() == 0 {
+			continue
+		}
+		consumers = append(consumers, parser.ToBulkStringArray([]string{
+			consumer.Name,
+			strconv.Itoa(consumer.PEL.Len()),
+		}))
+	}
+
+	return parser.ToArray([]string{
+		parser.ToInteger(group.PEL.Len()),
+		parser.ToBulkString(storage.StreamIDFromBytes(firstKey).String()),
+		parser.ToBulkString(storage.StreamIDFromBytes(lastKey).String()),
+		parser.ToArray(consumers),
+	})
+}
+
+// HandleXClaim handles XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID lastid]
+func (h *StreamHandler) HandleXClaim(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 5 {
+		writeResponse(conn, "XCLAIM", "-ERR wrong number of arguments for 'xclaim' command\r\n")
+		return
+	}
+
+	key, groupName, consumerName := cmd.Args[0], cmd.Args[1], cmd.Args[2]
+	minIdle, err := strconv.ParseInt(cmd.Args[3], 10, 64)
+	if err != nil {
+		writeResponse(conn, "XCLAIM", "-ERR Invalid min-idle-time argument for XCLAIM\r\n")
+		return
+	}
+	if minIdle < 0 {
+		minIdle = 0
+	}
+
+	// IDs come first, options start at the first argument that is not an ID
+	i := 4
+	var ids []storage.StreamID
+	for ; i < len(cmd.Args); i++ {
+		id, err := storage.ParseStreamID(cmd.Args[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		writeResponse(conn, "XCLAIM", parser.ToError(storage.ErrInvalidStreamID.Error()))
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	deliveryTime := int64(-1)
+	retryCount := int64(-1)
+	force, justID := false, false
+	var lastID storage.StreamID
+	for ; i < len(cmd.Args); i++ {
+		option := strings.ToUpper(cmd.Args[i])
+		hasValue := i+1 < len(cmd.Args)
+		switch {
+		case option == "FORCE":
+			force = true
+		case option == "JUSTID":
+			justID = true
+		case option == "IDLE" && hasValue, option == "TIME" && hasValue, option == "RETRYCOUNT" && hasValue:
+			value, err := strconv.ParseInt(cmd.Args[i+1], 10, 64)
+			if err != nil {
+				writeResponse(conn, "XCLAIM", "-ERR value is not an integer or out of range\r\n")
+				return
+			}
+			switch option {
+			case "IDLE":
+				deliveryTime = now - value
+			case "TIME":
+				deliveryTime = value
+			case "RETRYCOUNT":
+				retryCount = value
+			}
+			i++
+		case option == "LASTID" && hasValue:
+			id, err := storage.ParseStreamID(cmd.Args[i+1], 0)
+			if err != nil {
+				writeResponse(conn, "XCLAIM", parser.ToError(err.Error()))
+				return
+			}
+			lastID = id
+			i++
+		default:
+			writeResponse(conn, "XCLAIM", fmt.Sprintf("-ERR Unrecognized XCLAIM option '%s'\r\n", cmd.Args[i]))
+			return
+		}
+	}
+
+	// Bogus delivery times (e.g. computed with a skewed clock) fall back to now
+	if deliveryTime < 0 || deliveryTime > now {
+		deliveryTime = now
+	}
+
+	stream, group, errReply := h.lookupGroup(key, groupName)
+	if errReply != "" {
+		writeResponse(conn, "XCLAIM", errReply)
+		return
+	}
+
+	propagateLastID := false
+	if lastID.Compare(group.LastID) > 0 {
+		group.LastID = lastID
+		propagateLastID = true
+	}
+
+	var consumer *storage.StreamConsumer
+	var elements []string
+	var logErr error
+	for _, id := range ids {
+		nack := group.NACK(id)
+
+		// The entry must still exist to be claimed; drop stale pending entries
+		if !stream.Contains(id) {
+			if nack != nil {
+				keepFirstError(&logErr, propagateXClaim(key, group, nack))
+				propagateLastID = false
+				group.Ack(id)
+			}
+			continue
+		}
+
+		// FORCE creates the pending entry if it is missing
+		if force && nack == nil {
+			nack = &storage.StreamNACK{ID: id}
+			group.PEL.Insert(id.Bytes(), nack)
+		}
+		if nack == nil {
+			continue
+		}
+
+		if nack.Consumer != nil && minIdle > 0 && now-nack.DeliveryTime < minIdle {
+			continue
+		}
+
+		if consumer == nil {
+			consumer, err = lookupOrCreateConsumer(key, group, consumerName, now)
+			keepFirstError(&logErr, err)
+		}
+		group.Assign(nack, consumer)
+		nack.DeliveryTime = deliveryTime
+		if retryCount >= 0 {
+			nack.DeliveryCount = uint64(retryCount)
+		} else if !justID {
+			nack.DeliveryCount++
+		}
+		consumer.ActiveTime = now
+
+		if justID {
+			elements = append(elements, parser.ToBulkString(id.String()))
+		} else {
+			entry, _ := stream.Get(id)
+			elements = append(elements, encodeStreamEntry(entry))
+		}
+
+		keepFirstError(&logErr, propagateXClaim(key, group, nack))
+		propagateLastID = false
+	}
+
+	if propagateLastID {
+		keepFirstError(&logErr, propagateGroupID(key, group))
+	}
+	if logErr != nil {
+		writeResponse(conn, "XCLAIM", aofErrorReply(logErr))
+		return
+	}
+	writeResponse(conn, "XCLAIM", parser.ToArray(elements))
+}
+
+// HandleXAutoClaim handles XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID]
+func (h *StreamHandler) HandleXAutoClaim(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 5 {
+		writeResponse(conn, "XAUTOCLAIM", "-ERR wrong number of arguments for 'xautoclaim' command\r\n")
+		return
+	}
+
+	key, groupName, consumerName := cmd.Args[0], cmd.Args[1], cmd.Args[2]
+	minIdle, err := strconv.ParseInt(cmd.Args[3], 10, 64)
+	if err != nil {
+		writeResponse(conn, "XAUTOCLAIM", "-ERR Invalid min-idle-time argument for XAUTOCLAIM\r\n")
+		return
+	}
+	if minIdle < 0 {
+		minIdle = 0
+	}
+	start, err := parseRangeID(cmd.Args[4], true)
+	if err != nil {
+		writeResponse(conn, "XAUTOCLAIM", parser.ToError(err.Error()))
+		return
+	}
+
+	count := 100
+	justID := false
+	for i := 5; i < len(cmd.Args); i++ {
+		switch option := strings.ToUpper(cmd.Args[i]); {
+		case option == "COUNT" && i+1 < len(cmd.Args):
+			value, err := strconv.Atoi(cmd.Args[i+1])
+			if err != nil {
+				writeResponse(conn, "XAUTOCLAIM", "-ERR value is not an integer or out of range\r\n")
+				return
+			}
+			if value < 1 || value > 1<<50 {
+				writeResponse(conn, "XAUTOCLAIM", "-ERR COUNT must be > 0\r\n")
+				return
+			}
+			count = value
+			i++
+		case option == "JUSTID":
+			justID = true
+		default:
+			writeResponse(conn, "XAUTOCLAIM", "-ERR syntax error\r\n")
+			return
+		}
+	}
+
+	stream, group, errReply := h.lookupGroup(key, groupName)
+	if errReply != "" {
+		writeResponse(conn, "XAUTOCLAIM", errReply)
+		return
+	}
+
+	// Scan at most count*10 pending entries; one more is looked up to be
+	// returned as the cursor for the next call
+	attempts := count * 10
+	var candidates []*storage.StreamNACK
+	group.PEL.Ascend(start.Bytes(), func(_ []byte, nack *storage.StreamNACK) bool {
+		candidates = append(candidates, nack)
+		return len(candidates) <= attempts
+	})
+
+	now := time.Now().UnixMilli()
+	var consumer *storage.StreamConsumer
+	var claimed, deleted []string
+	var logErr error
+	scanned := 0
+	for scanned < len(candidates) && scanned < attempts && count > 0 {
+		nack := candidates[scanned]
+		scanned++
+
+		if !stream.Contains(nack.ID) {
+			keepFirstError(&logErr, propagateXClaim(key, group, nack))
+			group.Ack(nack.ID)
+			deleted = append(deleted, parser.ToBulkString(nack.ID.String()))
+			count--
+			continue
+		}
+		if minIdle > 0 && now-nack.DeliveryTime < minIdle {
+			continue
+		}
+
+		if consumer == nil {
+			consumer, err = lookupOrCreateConsumer(key, group, consumerName, now)
+			keepFirstError(&logErr, err)
+		}
+		group.Assign(nack, consumer)
+		nack.DeliveryTime = now
+		if !justID {
+			nack.DeliveryCount++
+		}
+		consumer.ActiveTime = now
+
+		if justID {
+			claimed = append(claimed, parser.ToBulkString(nack.ID.String()))
+		} else {
+			entry, _ := stream.Get(nack.ID)
+			claimed = append(claimed, encodeStreamEntry(entry))
+		}
+		count--
+		keepFirstError(&logErr, propagateXClaim(key, group, nack))
+	}
+
+	cursor := storage.StreamID{}
+	if scanned < len(candidates) {
+		cursor = candidates[scanned].ID
+	}
+
+	if logErr != nil {
+		writeResponse(conn, "XAUTOCLAIM", aofErrorReply(logErr))
+		return
+	}
+	writeResponse(conn, "XAUTOCLAIM", parser.ToArray([]string{
+		parser.ToBulkString(cursor.String()),
+		parser.ToArray(claimed),
+		parser.ToArray(deleted),
+	}))
+}
+
+// HandleXInfo handles XINFO STREAM key [FULL [COUNT count]] | GROUPS key | CONSUMERS key group
+func (h *StreamHandler) HandleXInfo(conn net.Conn, cmd *Command) {
+	if len(cmd.Args) < 2 {
+		writeResponse(conn, "XINFO", "-ERR wrong number of arguments for 'xinfo' command\r\n")
+		return
+	}
+
+	subcommand := strings.ToUpper(cmd.Args[0])
+	if subcommand != "STREAM" && subcommand != "GROUPS" && subcommand != "CONSUMERS" {
+		writeResponse(conn, "XINFO", fmt.Sprintf("-ERR unknown subcommand '%s'. Try XINFO HELP.\r\n", cmd.Args[0]))
+		return
+	}
+
+	key := cmd.Args[1]
+	stream, err := h.repo.GetStream(key, false)
+	if err != nil {
+		writeResponse(conn, "XINFO", parser.ToError(err.Error()))
+		return
+	}
+	if stream == nil {
+		writeResponse(conn, "XINFO", "-ERR no such key\r\n")
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	switch subcommand {
+	case "STREAM":
+		full := false
+		count := 10
+		for i := 2; i < len(cmd.Args); i++ {
+			switch option := strings.ToUpper(cmd.Args[i]); {
+			case option == "FULL":
+				full = true
+			case option == "COUNT" && full && i+1 < len(cmd.Args):
+				value, err := strconv.Atoi(cmd.Args[i+1])
+				if err != nil {
+					writeResponse(conn, "XINFO", "-ERR value is not an integer or out of range\r\n")
+					return
+				}
+				count = max(value, 0)
+				i++
+			default:
+				writeResponse(conn, "XINFO", "-ERR syntax error\r\n")
+				return
+			}
+		}
+		writeResponse(conn, "XINFO", encodeStreamInfo(stream, full, count))
+
+	case "GROUPS":
+		if len(cmd.Args) != 2 {
+			writeResponse(conn, "XINFO", "-ERR wrong number of arguments for 'xinfo|groups' command\r\n")
+			return
+		}
+		var groups []string
+		for _, group := range stream.Groups() {
+			groups = append(groups, parser.ToArray([]string{
+				parser.ToBulkString("name"), parser.ToBulkString(group.Name),
+				parser.ToBulkString("consumers"), parser.ToInteger(len(group.Consumers())),
+				parser.ToBulkString("pending"), parser.ToInteger(group.PEL.Len()),
+				parser.ToBulkString("last-delivered-id"), parser.ToBulkString(group.LastID.String()),
+				parser.ToBulkString("entries-read"), encodeEntriesRead(group.EntriesRead),
+				parser.ToBulkString("lag"), encodeLag(stream, group),
+			}))
+		}
+		writeResponse(conn, "XINFO", parser.ToArray(groups))
+
+	case "CONSUMERS":
+		if len(cmd.Args) != 3 {
+			writeResponse(conn, "XINFO", "-ERR wrong number of arguments for 'xinfo|consumers' command\r\n")
+			return
+		}
+		group := stream.Group(cmd.Args[2])
+		if group == nil {
+			writeResponse(conn, "XINFO", parser.ToError(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", cmd.Args[2], key)))
+			return
+		}
+		var consumers []string
+		for _, consumer := range group.Consumers() {
+			inactive := int64(-1)
+			if consumer.ActiveTime != -1 {
+				inactive = now - consumer.ActiveTime
+			}
+			consumers = append(consumers, parser.ToArray([]string{
+				parser.ToBulkString("name"), parser.ToBulkString(consumer.Name),
+				parser.ToBulkString("pending"), parser.ToInteger(consumer.PEL.Len()),
+				parser.ToBulkString("idle"), parser.ToInteger(int(now - consumer.SeenTime)),
+				parser.ToBulkString("inactive"), parser.ToInteger(int(inactive)),
+			}))
+		}
+		writeResponse(conn, "XINFO", parser.ToArray(consumers))
+	}
+}
+
+// encodeStreamInfo encodes the reply of XINFO STREAM
+func encodeStreamInfo(stream *storage.Stream, full bool, count int) string {
+	keys, nodes := stream.NodeStats()
+	elements := []string{
+		parser.ToBulkString("length"), parser.ToInteger(int(stream.Len())),
+		parser.ToBulkString("radix-tree-keys"), parser.ToInteger(keys),
+		parser.ToBulkString("radix-tree-nodes"), parser.ToInteger(nodes),
+		parser.ToBulkString("last-generated-id"), parser.ToBulkString(stream.LastID().String()),
+		parser.ToBulkString("max-deleted-entry-id"), parser.ToBulkString(stream.MaxDeletedID().String()),
+		parser.ToBulkString("entries-added"), parser.ToInteger(int(stream.EntriesAdded())),
+		parser.ToBulkString("recorded-first-entry-id"), parser.ToBulkString(stream.FirstID().String()),
+	}
+
+	if !full {
+		first := parser.ToNullBulkString()
+		if entries := stream.Range(storage.StreamID{}, storage.MaxStreamID, 1, false); len(entries) > 0 {
+			first = encodeStreamEntry(entries[0])
+		}
+		last := parser.ToNullBulkString()
+		if entries := stream.Range(storage.StreamID{}, storage.MaxStreamID, 1, true); len(entries) > 0 {
+			last = encodeStreamEntry(entries[0])
+		}
+		elements = append(elements,
+			parser.ToBulkString("groups"), parser.ToInteger(len(stream.Groups())),
+			parser.ToBulkString("first-entry"), first,
+			parser.ToBulkString("last-entry"), last,
+		)
+		return parser.ToArray(elements)
+	}
+
+	// COUNT 0 means everything
+	limit := count
+	if limit == 0 {
+		limit = -1
+	}
+	elements = append(elements,
+		parser.ToBulkString("entries"),
+		encodeStreamEntries(stream.Range(storage.StreamID{}, storage.MaxStreamID, limit, false)),
+	)
+
+	var groups []string
+	for _, group := range stream.Groups() {
+		var pending []string
+		group.PEL.Ascend(nil, func(_ []byte, nack *storage.StreamNACK) bool {
+			pending = append(pending, parser.ToArray([]string{
+				parser.ToBulkString(nack.ID.String()),
+				parser.ToBulkString(nack.Consumer.Name),
+				parser.ToInteger(int(nack.DeliveryTime)),
+				parser.ToInteger(int(nack.DeliveryCount)),
+			}))
+			return count == 0 || len(pending) < count
+		})
+
+		var consumers []string
+		for _, consumer := range group.Consumers() {
+			var consumerPending []string
+			consumer.PEL.Ascend(nil, func(_ []byte, nack *storage.StreamNACK) bool {
+				consumerPending = append(consumerPending, parser.ToArray([]string{
+					parser.ToBulkString(nack.ID.String()),
+					parser.ToInteger(int(nack.DeliveryTime)),
+					parser.ToInteger(int(nack.DeliveryCount)),
+				}))
+				return count == 0 || len(consumerPending) < count
+			})
+			consumers = append(consumers, parser.ToArray([]string{
+				parser.ToBulkString("name"), parser.ToBulkString(consumer.Name),
+				parser.ToBulkString("seen-time"), parser.ToInteger(int(consumer.SeenTime)),
+				parser.ToBulkString("active-time"), parser.ToInteger(int(consumer.ActiveTime)),
+				parser.ToBulkString("pel-count"), parser.ToInteger(consumer.PEL.Len()),
+				parser.ToBulkString("pending"), parser.ToArray(consumerPending),
+			}))
+		}
+
+		groups = append(groups, parser.ToArray([]string{
+			parser.ToBulkString("name"), parser.ToBulkString(group.Name),
+			parser.ToBulkString("last-delivered-id"), parser.ToBulkString(group.LastID.String()),
+			parser.ToBulkString("entries-read"), encodeEntriesRead(group.EntriesRead),
+			parser.ToBulkString("lag"), encodeLag(stream, group),
+			parser.ToBulkString("pel-count"), parser.ToInteger(group.PEL.Len()),
+			parser.ToBulkString("pending"), parser.ToArray(pending),
+			parser.ToBulkString("consumers"), parser.ToArray(consumers),
+		}))
+	}
+	elements = append(elements, parser.ToBulkString("groups"), parser.ToArray(groups))
+	return parser.ToArray(elements)
+}
+
+// encodeEntriesRead encodes a group's entries-read counter, nil if unknown
+func encodeEntriesRead(entriesRead int64) string {
+	if entriesRead == -1 {
+		return parser.ToNullBulkString()
+	}
+	return parser.ToInteger(int(entriesRead))
+}
+
+// encodeLag encodes a group's lag, nil if it cannot be computed
+func encodeLag(stream *storage.Stream, group *storage.StreamGroup) string {
+	lag, valid := stream.Lag(group)
+	if !valid {
+		return parser.ToNullBulkString()
+	}
+	return parser.ToInteger(int(lag))
+}
@@ -0,0 +1,384 @@
+// Package hyperloglog implements the Redis HyperLogLog string format: a
+// 16 byte "HYLL" header followed by either the sparse or the dense
+// representation of 16384 six bit registers.
+package hyperloglog
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+const (
+	P         = 14     // Bits of the hash used to select the register
+	Q         = 64 - P // Bits of the hash used to count leading zeros
+	Registers = 1 << P // Number of registers
+	RegBits   = 6      // Bits per register in the dense representation
+	RegMax    = 1<<RegBits - 1
+
+	HeaderSize = 16
+	DenseSize  = HeaderSize + (Registers*RegBits+7)/8
+
+	// SparseMaxBytes is the size above which a sparse HyperLogLog is
+	// converted to the dense representation
+	SparseMaxBytes = 3000
+
+	EncodingDense  = 0
+	EncodingSparse = 1
+
+	sparseValMax     = 32
+	sparseValMaxLen  = 4
+	sparseZeroMaxLen = 64
+	sparseXZeroMax   = 16384
+
+	alphaInf = 0.721347520444481703680 // Constant for 0.5/ln(2)
+	hashSeed = 0xadc83b19
+)
+
+var (
+	// ErrInvalid is returned for strings that are not HyperLogLogs
+	ErrInvalid = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	// ErrCorrupted is returned when the registers cannot be decoded
+	ErrCorrupted = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// New returns an empty sparse HyperLogLog
+func New() []byte {
+	regs := make([]uint8, Registers)
+	buf, _ := encodeSparse(regs)
+	buf[15] = 0 // The cached cardinality of an empty set is valid
+	return buf
+}
+
+// Check validates the header of buf
+func Check(buf []byte) error {
+	if len(buf) < HeaderSize || string(buf[:4]) != "HYLL" {
+		return ErrInvalid
+	}
+	switch buf[4] {
+	case EncodingDense:
+		if len(buf) != DenseSize {
+			return ErrInvalid
+		}
+	case EncodingSparse:
+	default:
+		return ErrInvalid
+	}
+	return nil
+}
+
+// writeHeader fills the header with the given encoding and an invalid
+// cached cardinality
+func writeHeader(buf []byte, encoding byte) {
+	copy(buf, "HYLL")
+	buf[4] = encoding
+	buf[5], buf[6], buf[7] = 0, 0, 0
+	invalidateCache(buf)
+}
+
+// invalidateCache marks the cached cardinality as stale
+func invalidateCache(buf []byte) {
+	buf[15] |= 1 << 7
+}
+
+// cachedCount returns the cached cardinality when it is valid
+func cachedCount(buf []byte) (uint64, bool) {
+	if buf[15]&(1<<7) != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(buf[8:16]), true
+}
+
+// patternLen returns the register index for element and the length of the
+// 000..1 pattern of the remaining hash bits, including the final 1
+func patternLen(element string) (int, uint8) {
+	hash := murmurHash64A([]byte(element), hashSeed)
+	index := int(hash & (Registers - 1))
+	hash >>= P
+	hash |= 1 << Q // Make sure the loop terminates
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	return index, count
+}
+
+// denseGet returns the register at index from the dense registers
+func denseGet(regs []byte, index int) uint8 {
+	byteIndex := index * RegBits / 8
+	fb := uint(index * RegBits & 7)
+	value := uint(regs[byteIndex]) >> fb
+	if byteIndex+1 < len(regs) {
+		value |= uint(regs[byteIndex+1]) << (8 - fb)
+	}
+	return uint8(value & RegMax)
+}
+
+// denseSet stores value in the register at index of the dense registers
+func denseSet(regs []byte, index int, value uint8) {
+	byteIndex := index * RegBits / 8
+	fb := uint(index * RegBits & 7)
+	v := uint(value)
+	regs[byteIndex] &^= byte(RegMax << fb)
+	regs[byteIndex] |= byte(v << fb)
+	if byteIndex+1 < len(regs) {
+		regs[byteIndex+1] &^= byte(RegMax >> (8 - fb))
+		regs[byteIndex+1] |= byte(v >> (8 - fb))
+	}
+}
+
+// Decode returns the registers of the HyperLogLog stored in buf
+func Decode(buf []byte) ([]uint8, error) {
+	if err := Check(buf); err != nil {
+		return nil, err
+	}
+
+	regs := make([]uint8, Registers)
+	if buf[4] == EncodingDense {
+		for i := range regs {
+			regs[i] = denseGet(buf[HeaderSize:], i)
+		}
+		return regs, nil
+	}
+
+	index := 0
+	for p := HeaderSize; p < len(buf); p++ {
+		op := buf[p]
+		var runLen int
+		var value uint8
+		switch {
+		case op&0xc0 == 0x00: // ZERO: 00xxxxxx
+			runLen = int(op&0x3f) + 1
+		case op&0xc0 == 0x40: // XZERO: 01xxxxxx yyyyyyyy
+			if p+1 >= len(buf) {
+				return nil, ErrCorrupted
+			}
+			runLen = (int(op&0x3f)<<8 | int(buf[p+1])) + 1
+			p++
+		default: // VAL: 1vvvvvxx
+			value = (op>>2)&0x1f + 1
+			runLen = int(op&0x03) + 1
+		}
+		if index+runLen > Registers {
+			return nil, ErrCorrupted
+		}
+		for i := 0; i < runLen; i++ {
+			regs[index+i] = value
+		}
+		index += runLen
+	}
+	if index != Registers {
+		return nil, ErrCorrupted
+	}
+	return regs, nil
+}
+
+// encodeSparse encodes registers with the sparse representation. It fails
+// when a register does not fit a VAL opcode or the result is larger than
+// SparseMaxBytes.
+func encodeSparse(regs []uint8) ([]byte, bool) {
+	buf := make([]byte, HeaderSize, HeaderSize+64)
+	for i := 0; i < len(regs); {
+		value := regs[i]
+		if value > sparseValMax {
+			return nil, false
+		}
+		run := 1
+		for i+run < len(regs) && regs[i+run] == value {
+			run++
+		}
+		i += run
+
+		for run > 0 {
+			switch {
+			case value != 0:
+				n := min(run, sparseValMaxLen)
+				buf = append(buf, 0x80|(value-1)<<2|byte(n-1))
+				run -= n
+			case run > sparseZeroMaxLen:
+				n := min(run, sparseXZeroMax)
+				buf = append(buf, 0x40|byte((n-1)>>8), byte(n-1))
+				run -= n
+			default:
+				buf = append(buf, byte(run-1))
+				run = 0
+			}
+		}
+		if len(buf) > SparseMaxBytes {
+			return nil, false
+		}
+	}
+	writeHeader(buf, EncodingSparse)
+	return buf, true
+}
+
+// encodeDense encodes registers with the dense representation
+func encodeDense(regs []uint8) []byte {
+	buf := make([]byte, DenseSize)
+	writeHeader(buf, EncodingDense)
+	for i, value := range regs {
+		denseSet(buf[HeaderSize:], i, value)
+	}
+	return buf
+}
+
+// Encode returns the smallest valid representation of registers, which is
+// dense when requested or when the sparse one would be too large
+func Encode(regs []uint8, dense bool) []byte {
+	if !dense {
+		if buf, ok := encodeSparse(regs); ok {
+			return buf
+		}
+	}
+	return encodeDense(regs)
+}
+
+// Add adds elements to the HyperLogLog in buf, which must be valid. It
+// returns the updated buffer and whether any register changed.
+func Add(buf []byte, elements []string) ([]byte, bool, error) {
+	if err := Check(buf); err != nil {
+		return nil, false, err
+	}
+
+	if buf[4] == EncodingDense {
+		changed := false
+		for _, element := range elements {
+			index, count := patternLen(element)
+			if count > denseGet(buf[HeaderSize:], index) {
+				denseSet(buf[HeaderSize:], index, count)
+				changed = true
+			}
+		}
+		if changed {
+			invalidateCache(buf)
+		}
+		return buf, changed, nil
+	}
+
+	regs, err := Decode(buf)
+	if err != nil {
+		return nil, false, err
+	}
+	changed := false
+	for _, element := range elements {
+		index, count := patternLen(element)
+		if count > regs[index] {
+			regs[index] = count
+			changed = true
+		}
+	}
+	if !changed {
+		return buf, false, nil
+	}
+	return Encode(regs, false), true, nil
+}
+
+// Count returns the estimated cardinality of the HyperLogLog in buf,
+// refreshing the cached value stored in its header
+func Count(buf []byte) (uint64, error) {
+	if err := Check(buf); err != nil {
+		return 0, err
+	}
+	if count, ok := cachedCount(buf); ok {
+		return count, nil
+	}
+
+	regs, err := Decode(buf)
+	if err != nil {
+		return 0, err
+	}
+	count := Estimate(regs)
+	binary.LittleEndian.PutUint64(buf[8:16], count)
+	return count, nil
+}
+
+// Merge sets each register of dst to the maximum of itself and src
+func Merge(dst, src []uint8) {
+	for i, value := range src {
+		if value > dst[i] {
+			dst[i] = value
+		}
+	}
+}
+
+// Estimate returns the cardinality estimated from registers using the
+// improved estimator of Otmar Ertl, like Redis does
+func Estimate(regs []uint8) uint64 {
+	var histogram [64]int
+	for _, value := range regs {
+		histogram[value]++
+	}
+
+	m := float64(Registers)
+	z := m * tau((m-float64(histogram[Q+1]))/m)
+	for j := Q; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * sigma(float64(histogram[0])/m)
+	return uint64(math.Round(alphaInf * m * m / z))
+}
+
+func tau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y := 1.0
+	z := 1 - x
+	for {
+		x = math.Sqrt(x)
+		zPrime := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if zPrime == z {
+			return z / 3
+		}
+	}
+}
+
+func sigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		zPrime := z
+		z += x * y
+		y += y
+		if zPrime == z {
+			return z
+		}
+	}
+}
+
+// murmurHash64A is the 64 bit MurmurHash2 variant used by Redis to hash
+// HyperLogLog elements
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ (uint64(len(key)) * m)
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * i)
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
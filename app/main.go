@@ -1,13 +1,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
-	"time"
+	"strconv"
 
 	"github.com/codecrafters-io/redis-starter-go/app/config"
+	"github.com/codecrafters-io/redis-starter-go/app/crypt"
 	"github.com/codecrafters-io/redis-starter-go/app/handlers"
+	"github.com/codecrafters-io/redis-starter-go/app/parser"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
 	"github.com/codecrafters-io/redis-starter-go/app/replication"
 	"github.com/codecrafters-io/redis-starter-go/app/repository"
 	"github.com/codecrafters-io/redis-starter-go/app/storage"
@@ -43,25 +47,27 @@ func (ch *ConnectionHandler) HandleConnection(conn net.Conn) {
 
 	fmt.Printf("New connection from %s\n", conn.RemoteAddr())
 
+	// Commands are read one complete RESP message at a time and executed in
+	// order, so pipelined and large commands are handled correctly
+	reader := parser.NewReader(conn)
 	for {
-		buffer := make([]byte, 1024)
-		n, err := conn.Read(buffer)
+		command, err := reader.ReadMessage()
 		if err != nil {
+			var protocolErr parser.ProtocolError
+			if errors.As(err, &protocolErr) {
+				conn.Write([]byte(parser.ToError("ERR " + protocolErr.Error())))
+			}
 			fmt.Printf("Connection closed: %s (%v)\n", conn.RemoteAddr(), err)
 			return
 		}
 
-		// Only process if we received data
-		if n > 0 {
-			command := string(buffer[:n])
-			// Use the handler manager with dependency injection
-			go ch.handlerManager.HandleCommand(conn, command)
-		}
+		// Use the handler manager with dependency injection
+		ch.handlerManager.HandleCommand(conn, command)
 	}
 }
 
 // NewRedisServer creates a new Redis server instance
-func NewRedisServer(port string) (*RedisServer, error) {
+func NewRedisServer(port string, repo repository.KeyValueRepository, handlerManager *handlers.HandlerManager) (*RedisServer, error) {
 	address := "0.0.0.0:" + port
 
 	listener, err := net.Listen("tcp", address)
@@ -69,12 +75,6 @@ func NewRedisServer(port string) (*RedisServer, error) {
 		return nil, fmt.Errorf("failed to bind to port %s: %v", port, err)
 	}
 
-	// Create repository with existing global dictionary for backward compatibility
-	repo := repository.NewMemoryRepositoryWithStorage(storage.Dictionary)
-
-	// Create handler manager with dependencies
-	handlerManager := handlers.NewHandlerManager(repo)
-
 	// Create connection handler
 	connectionHandler := NewConnectionHandler(handlerManager)
 
@@ -115,51 +115,18 @@ func (s *RedisServer) GetRepository() repository.KeyValueRepository {
 }
 
 // Initialize sets up the server configuration based on CLI args
-func Initialize(cliConfig *config.CLIConfig, repo repository.KeyValueRepository) {
+func Initialize(cliConfig *config.CLIConfig, handlerManager *handlers.HandlerManager) {
+	config.SetPort(cliConfig.Port)
 	if cliConfig.IsReplica {
-		config.SetServerRole("slave")
-		config.SetReplicaConfig(cliConfig.MasterHost, cliConfig.MasterPort)
-		fmt.Printf("Configured as replica of %s:%s\n", cliConfig.MasterHost, cliConfig.MasterPort)
-
-		// Start replication handshake in background with repository dependency
-		go startReplicationHandshake(cliConfig.MasterHost, cliConfig.MasterPort, cliConfig.Port, repo)
+		// Start replication in background, the link is kept up until
+		// REPLICAOF NO ONE
+		handlerManager.ReplicaOf(cliConfig.MasterHost, cliConfig.MasterPort)
 	} else {
 		config.SetServerRole("master")
 		fmt.Println("Configured as master")
 	}
 }
 
-// startReplicationHandshake initiates the handshake with master server
-func startReplicationHandshake(masterHost, masterPort, replicaPort string, repo repository.KeyValueRepository) {
-	// Give the server a moment to start up
-	time.Sleep(100 * time.Millisecond)
-
-	// Create a command processor for the replica
-	replicaHandler := handlers.NewReplicaCommandHandler(repo)
-	commandProcessor := func(respData string) error {
-		return replicaHandler.ProcessCommand(respData)
-	}
-
-	// Create a connection setter to pass the connection to the replica handler
-	connectionSetter := func(conn net.Conn) {
-		replicaHandler.SetConnection(conn)
-	}
-
-	client := replication.NewReplicaClient(masterHost, masterPort, replicaPort, commandProcessor, connectionSetter)
-
-	// Connect to master
-	if err := client.Connect(); err != nil {
-		fmt.Printf("Failed to connect to master: %v\n", err)
-		return
-	}
-
-	// Start handshake
-	if err := client.StartHandshake(); err != nil {
-		fmt.Printf("Failed to start handshake: %v\n", err)
-		return
-	}
-}
-
 func main() {
 	fmt.Println("Logs from your program will appear here!")
 
@@ -173,11 +140,87 @@ func main() {
 	// Create repository with existing global dictionary for backward compatibility
 	repo := repository.NewMemoryRepositoryWithStorage(storage.Dictionary)
 
+	// Create handler manager shared by clients and the replication link
+	handlerManager := handlers.NewHandlerManager(repo)
+
+	// Persistence files are encrypted when a key is given in a file or in
+	// the environment
+	key, err := crypt.ConfiguredKey(cliConfig.EncryptionKeyFile)
+	if err != nil {
+		fmt.Printf("Error loading the encryption key: %v\n", err)
+		os.Exit(1)
+	}
+	if key != nil {
+		fmt.Println("Persistence files are encrypted with AES-256-GCM")
+	}
+	config.SetEncryptionKey(key)
+	config.SetReplBacklogConfig(cliConfig.ReplBacklogSize, cliConfig.ReplBacklogTTL)
+	config.SetReplTimeout(cliConfig.ReplTimeout)
+	config.SetReplPingReplicaPeriod(cliConfig.ReplPingReplicaPeriod)
+	config.SetReplicaReadOnly(cliConfig.ReplicaReadOnly)
+	config.SetReplicaOutputBufferLimit(cliConfig.ReplicaOutputBufferLimit)
+	config.SetReplDisklessConfig(cliConfig.ReplDisklessSync, cliConfig.ReplDisklessSyncDelay, cliConfig.ReplDisklessLoad)
+
+	// Restore the dataset from the append-only file when it is enabled and
+	// exists, otherwise from the RDB file, if any
+	config.SetPersistenceConfig(cliConfig.Dir, cliConfig.DBFilename, cliConfig.SaveRules)
+	config.SetAppendOnlyConfig(cliConfig.AppendOnly, cliConfig.AppendFilename, cliConfig.AppendDirname, cliConfig.AppendFsync, cliConfig.AOFLoadTruncated)
+	loaded := false
+	if config.Server.AppendOnly {
+		stats, found, err := handlerManager.LoadAppendOnly()
+		if err != nil {
+			fmt.Printf("Error loading append only file from %s: %v\n", config.AOFDir(), err)
+			os.Exit(1)
+		}
+		if found {
+			fmt.Printf("Loaded %d keys and replayed %d commands from %d files in %s\n", stats.Keys, stats.Commands, stats.Files, config.AOFDir())
+			loaded = true
+		}
+	}
+	if !loaded {
+		stats, err := rdb.LoadFile(config.RDBPath(), repo, config.Server.EncryptionKey)
+		if err != nil {
+			fmt.Printf("Error loading RDB file %s: %v\n", config.RDBPath(), err)
+			os.Exit(1)
+		}
+		fmt.Printf("Loaded %d keys from %s (%d expired, %d skipped)\n", stats.Loaded, config.RDBPath(), stats.Expired, stats.Skipped)
+
+		// A replica saved the replication ID and offset of its dataset, so
+		// it can ask master to continue from there after a restart
+		replId, hasId := stats.Aux["repl-id"]
+		replOffset, err := strconv.Atoi(stats.Aux["repl-offset"])
+		if cliConfig.IsReplica && hasId && err == nil {
+			config.Server.MasterReplId = replId
+			config.Server.MasterReplOffset = replOffset
+			config.Server.MasterCached = true
+		}
+	}
+
+	// Log every write from now on
+	if config.Server.AppendOnly {
+		if err := handlerManager.EnableAppendOnly(); err != nil {
+			fmt.Printf("Error opening append only file in %s: %v\n", config.AOFDir(), err)
+			os.Exit(1)
+		}
+	}
+
 	// Initialize server configuration
-	Initialize(cliConfig, repo)
+	Initialize(cliConfig, handlerManager)
+
+	// Trigger background saves according to the save rules
+	handlerManager.StartSaveScheduler()
+
+	// Free the replication backlog once replicas are gone for long enough
+	handlerManager.StartReplicationCron()
+
+	// Delete expired keys that are not accessed
+	handlerManager.StartExpireCycle()
+
+	// Close the MIGRATE connections left idle
+	handlerManager.StartMigrateCron()
 
 	// Create and start the Redis server
-	redisServer, err := NewRedisServer(cliConfig.Port)
+	redisServer, err := NewRedisServer(cliConfig.Port, repo, handlerManager)
 	if err != nil {
 		fmt.Printf("Error creating server: %v\n", err)
 		os.Exit(1)
@@ -1,6 +1,9 @@
 package parser
 
-import "strconv"
+import (
+	"strconv"
+	"strings"
+)
 
 // ToBulkString formats a string as a Redis RESP bulk string
 // Format: $<length>\r\n<data>\r\n
@@ -31,3 +34,28 @@ func ToInteger(i int) string {
 func ToNullBulkString() string {
 	return "$-1\r\n"
 }
+
+// ToNullArray returns a RESP null array
+func ToNullArray() string {
+	return "*-1\r\n"
+}
+
+// ToArray wraps already encoded RESP values into a RESP array
+// Format: *<count>\r\n<element1><element2>...
+func ToArray(elements []string) string {
+	var sb strings.Builder
+	sb.WriteString("*" + strconv.Itoa(len(elements)) + "\r\n")
+	for _, element := range elements {
+		sb.WriteString(element)
+	}
+	return sb.String()
+}
+
+// ToBulkStringArray formats a list of strings as a RESP array of bulk strings
+func ToBulkStringArray(items []string) string {
+	elements := make([]string, len(items))
+	for i, item := range items {
+		elements[i] = ToBulkString(item)
+	}
+	return ToArray(elements)
+}
@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limits of a RESP message, those of Redis: a bulk string holds at most
+// proto-max-bulk-len bytes (512MB) and an array at most 1024*1024*1024
+// elements
+const (
+	maxBulkLen  = 512 * 1024 * 1024
+	maxArrayLen = 1024 * 1024 * 1024
+)
+
+// ProtocolError reports a malformed RESP message. The stream cannot be
+// resynchronized after it, so the connection is to be closed.
+type ProtocolError string
+
+func (e ProtocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// Reader reads complete RESP messages from a byte stream
+type Reader struct {
+	reader *bufio.Reader
+}
+
+// NewReader creates a RESP reader on top of the given stream
+func NewReader(r io.Reader) *Reader {
+	return &Reader{
+		reader: bufio.NewReaderSize(r, 16*1024),
+	}
+}
+
+// ReadMessage reads exactly one RESP message and returns its raw encoding.
+// The returned string can be handed to ParseRESP or ParseCommand as-is.
+func (r *Reader) ReadMessage() (string, error) {
+	var sb strings.Builder
+	if err := r.readValue(&sb); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// ReadLine reads a line and returns it without its trailing CRLF
+func (r *Reader) ReadLine() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	return line[:len(line)-2], nil
+}
+
+// Read reads raw bytes from the stream, so data that is not framed as a
+// RESP value, such as the RDB file sent to a replica, can be read after
+// the messages preceding it
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+// readValue copies one RESP value (recursively for arrays) into sb
+func (r *Reader) readValue(sb *strings.Builder) error {
+	line, err := r.readLine()
+	if err != nil {
+		return err
+	}
+	if len(line) < 3 {
+		return ProtocolError(fmt.Sprintf("invalid RESP line: %q", line))
+	}
+	sb.WriteString(line)
+
+	switch line[0] {
+	case '+', '-', ':':
+		return nil
+	case '$':
+		length, err := strconv.Atoi(line[1 : len(line)-2])
+		if err != nil || length < 0 || length > maxBulkLen {
+			return ProtocolError("invalid bulk length")
+		}
+		// Copied as it arrives: the declared length is not allocated up
+		// front, so a client cannot reserve memory it never sends
+		if _, err := io.CopyN(sb, r.reader, int64(length)); err != nil {
+			return unexpectedEOF(err)
+		}
+		var crlf [2]byte
+		if _, err := io.ReadFull(r.reader, crlf[:]); err != nil {
+			return unexpectedEOF(err)
+		}
+		if crlf[0] != '\r' || crlf[1] != '\n' {
+			return ProtocolError("bulk string is not terminated by CRLF")
+		}
+		sb.Write(crlf[:])
+		return nil
+	case '*':
+		count, err := strconv.Atoi(line[1 : len(line)-2])
+		if err != nil || count > maxArrayLen {
+			return ProtocolError("invalid multibulk length")
+		}
+		for i := 0; i < count; i++ {
+			if err := r.readValue(sb); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return ProtocolError(fmt.Sprintf("unknown RESP type: %c", line[0]))
+	}
+}
+
+// readLine reads a line including its trailing CRLF
+func (r *Reader) readLine() (string, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", ProtocolError("line is not terminated by CRLF")
+	}
+	return line, nil
+}
+
+// unexpectedEOF reports a stream ending within a value as truncated
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,839 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// ErrChecksum is returned when the CRC64 footer does not match the content
+var ErrChecksum = errors.New("wrong RDB checksum")
+
+// Decoder reads the entries of an RDB file one at a time
+type Decoder struct {
+	r       *bufio.Reader
+	offset  int64
+	crc     uint64
+	version int
+	started bool
+	done    bool
+	db      int
+
+	// Aux holds the AUX fields read so far (redis-ver, repl-id, ...)
+	Aux map[string]string
+}
+
+// NewDecoder creates a decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:   bufio.NewReaderSize(r, 64*1024),
+		Aux: make(map[string]string),
+	}
+}
+
+// Version returns the RDB version of the file, known after the first Next
+func (d *Decoder) Version() int {
+	return d.version
+}
+
+// Offset returns the number of bytes consumed so far
+func (d *Decoder) Offset() int64 {
+	return d.offset
+}
+
+// errorf returns an Error at the current offset
+func (d *Decoder) errorf(format string, args ...interface{}) error {
+	return &Error{Offset: d.offset, Err: fmt.Errorf(format, args...)}
+}
+
+// wrap attaches the current offset to err, turning a clean EOF in the
+// middle of the file into an unexpected one
+func (d *Decoder) wrap(err error) error {
+	var rdbErr *Error
+	if errors.As(err, &rdbErr) {
+		return err
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return &Error{Offset: d.offset, Err: err}
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, d.wrap(err)
+	}
+	d.crc = crcUpdate(d.crc, []byte{b})
+	d.offset++
+	return b, nil
+}
+
+// readFull reads exactly n bytes. Large sizes are read incrementally so a
+// corrupted length cannot trigger a huge allocation up front.
+func (d *Decoder) readFull(n uint64) ([]byte, error) {
+	var buf []byte
+	if n <= 1<<20 {
+		buf = make([]byte, n)
+		read, err := io.ReadFull(d.r, buf)
+		d.crc = crcUpdate(d.crc, buf[:read])
+		d.offset += int64(read)
+		if err != nil {
+			return nil, d.wrap(err)
+		}
+		return buf, nil
+	}
+
+	var b bytes.Buffer
+	read, err := io.CopyN(&b, d.r, int64(n))
+	d.crc = crcUpdate(d.crc, b.Bytes())
+	d.offset += read
+	if err != nil {
+		return nil, d.wrap(err)
+	}
+	return b.Bytes(), nil
+}
+
+// readLength reads a length. When encoded is true the value is one of the
+// special string encodings instead.
+func (d *Decoder) readLength() (length uint64, encoded bool, err error) {
+	b, err := d.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0: // 6 bit length
+		return uint64(b & 0x3f), false, nil
+	case 1: // 14 bit length
+		next, err := d.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3f)<<8 | uint64(next), false, nil
+	case 3:
+		return uint64(b & 0x3f), true, nil
+	}
+
+	switch b {
+	case 0x80:
+		buf, err := d.readFull(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(buf)), false, nil
+	case 0x81:
+		buf, err := d.readFull(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(buf), false, nil
+	}
+	return 0, false, d.errorf("unknown length encoding 0x%02x", b)
+}
+
+// readLen reads a plain length
+func (d *Decoder) readLen() (uint64, error) {
+	length, encoded, err := d.readLength()
+	if err == nil && encoded {
+		err = d.errorf("unexpected string encoding %d where a length was expected", length)
+	}
+	return length, err
+}
+
+// readString reads a string, decoding integer and LZF encodings
+func (d *Decoder) readString() ([]byte, error) {
+	length, encoded, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return d.readFull(length)
+	}
+
+	switch length {
+	case encInt8:
+		b, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int8(b)))), nil
+	case encInt16:
+		buf, err := d.readFull(2)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf))))), nil
+	case encInt32:
+		buf, err := d.readFull(4)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf))))), nil
+	case encLZF:
+		compressedLen, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		outLen, err := d.readLen()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := d.readFull(compressedLen)
+		if err != nil {
+			return nil, err
+		}
+		if outLen > 1<<32 {
+			return nil, d.errorf("LZF string too large: %d bytes", outLen)
+		}
+		out, err := lzfDecompress(compressed, int(outLen))
+		if err != nil {
+			return nil, d.wrap(err)
+		}
+		return out, nil
+	}
+	return nil, d.errorf("unknown string encoding %d", length)
+}
+
+func (d *Decoder) readStringValue() (string, error) {
+	buf, err := d.readString()
+	return string(buf), err
+}
+
+// readDouble reads a score stored as a length prefixed decimal string
+func (d *Decoder) readDouble() (float64, error) {
+	length, err := d.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := d.readFull(uint64(length))
+	if err != nil {
+		return 0, err
+	}
+	value, err := strconv.ParseFloat(string(buf), 64)
+	if err != nil {
+		return 0, d.errorf("invalid double value %q", buf)
+	}
+	return value, nil
+}
+
+// readBinaryDouble reads a little endian IEEE 754 double
+func (d *Decoder) readBinaryDouble() (float64, error) {
+	buf, err := d.readFull(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// readMillis reads a little endian 64 bit millisecond timestamp
+func (d *Decoder) readMillis() (int64, error) {
+	buf, err := d.readFull(8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(buf)), nil
+}
+
+// readHeader checks the magic string and the version
+func (d *Decoder) readHeader() error {
+	buf, err := d.readFull(9)
+	if err != nil {
+		return err
+	}
+	if string(buf[:5]) != "REDIS" {
+		return &Error{Offset: 0, Err: errors.New("wrong signature, not an RDB file")}
+	}
+	version, err := strconv.Atoi(string(buf[5:]))
+	if err != nil || version < 1 || version > maxVersion {
+		return &Error{Offset: 5, Err: fmt.Errorf("unsupported RDB version %q", buf[5:])}
+	}
+	d.version = version
+	return nil
+}
+
+// Next returns the next key of the file. It returns io.EOF once the end of
+// the file has been reached and its checksum verified.
+func (d *Decoder) Next() (*Entry, error) {
+	if d.done {
+		return nil, io.EOF
+	}
+	if !d.started {
+		d.started = true
+		if err := d.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	entry := &Entry{Idle: -1, Freq: -1, Offset: d.offset}
+	for {
+		opcode, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opExpireTime:
+			buf, err := d.readFull(4)
+			if err != nil {
+				return nil, err
+			}
+			entry.ExpireAt = int64(binary.LittleEndian.Uint32(buf)) * 1000
+		case opExpireTimeMs:
+			if entry.ExpireAt, err = d.readMillis(); err != nil {
+				return nil, err
+			}
+		case opIdle:
+			idle, err := d.readLen()
+			if err != nil {
+				return nil, err
+			}
+			entry.Idle = int64(idle)
+		case opFreq:
+			freq, err := d.readByte()
+			if err != nil {
+				return nil, err
+			}
+			entry.Freq = int(freq)
+		case opSelectDB:
+			db, err := d.readLen()
+			if err != nil {
+				return nil, err
+			}
+			d.db = int(db)
+		case opResizeDB:
+			// Table size hints, not needed by the map based keyspace
+			for i := 0; i < 2; i++ {
+				if _, err := d.readLen(); err != nil {
+					return nil, err
+				}
+			}
+		case opSlotInfo:
+			for i := 0; i < 3; i++ {
+				if _, err := d.readLen(); err != nil {
+					return nil, err
+				}
+			}
+		case opAux:
+			key, err := d.readStringValue()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.readStringValue()
+			if err != nil {
+				return nil, err
+			}
+			d.Aux[key] = value
+		case opFunction2:
+			// Function libraries are not supported, skip their code
+			if _, err := d.readString(); err != nil {
+				return nil, err
+			}
+		case opFunctionPre, opModuleAux:
+			return nil, d.errorf("unsupported opcode 0x%02x", opcode)
+		case opEOF:
+			if err := d.readChecksum(); err != nil {
+				return nil, err
+			}
+			d.done = true
+			return nil, io.EOF
+		default:
+			entry.DB = d.db
+			entry.Type = opcode
+			if entry.Key, err = d.readStringValue(); err != nil {
+				return nil, err
+			}
+			if entry.Value, err = d.readObject(opcode); err != nil {
+				return nil, err
+			}
+			return entry, nil
+		}
+
+		// Expiration and eviction info belong to the key that follows them
+		if opcode != opExpireTime && opcode != opExpireTimeMs && opcode != opIdle && opcode != opFreq {
+			entry.Offset = d.offset
+		}
+	}
+}
+
+// readChecksum verifies the CRC64 footer. A zero checksum means the file
+// was written with checksums disabled.
+func (d *Decoder) readChecksum() error {
+	if d.version < 5 {
+		return nil
+	}
+	expected := d.crc
+	buf, err := d.readFull(8)
+	if err != nil {
+		return err
+	}
+	stored := binary.LittleEndian.Uint64(buf)
+	if stored != 0 && stored != expected {
+		return &Error{Offset: d.offset - 8, Err: ErrChecksum}
+	}
+	return nil
+}
+
+// readObject reads a value of the given type
+func (d *Decoder) readObject(objType byte) (interface{}, error) {
+	switch objType {
+	case TypeString:
+		return d.readStringValue()
+	case TypeList, TypeSet:
+		elements, err := d.readStrings()
+		if err != nil {
+			return nil, err
+		}
+		if objType == TypeList {
+			return List(elements), nil
+		}
+		return Set(elements), nil
+	case TypeZSet, TypeZSet2:
+		return d.readZSet(objType == TypeZSet2)
+	case TypeHash:
+		elements, err := d.readPairs()
+		if err != nil {
+			return nil, err
+		}
+		return pairsToHash(elements), nil
+	case TypeListZiplist, TypeSetIntset, TypeZSetZiplist, TypeHashZiplist,
+		TypeHashListpack, TypeZSetListpack, TypeSetListpack:
+		return d.readPacked(objType)
+	case TypeListQuicklist, TypeListQuicklist2:
+		return d.readQuicklist(objType == TypeListQuicklist2)
+	case TypeStreamListpacks, TypeStreamListpacks2, TypeStreamListpacks3:
+		return d.readStream(objType)
+	}
+	return nil, d.errorf("unsupported object type %d", objType)
+}
+
+// readStrings reads a length followed by that many strings
+func (d *Decoder) readStrings() ([]string, error) {
+	count, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]string, 0, min(count, 1024))
+	for i := uint64(0); i < count; i++ {
+		element, err := d.readStringValue()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
+// readPairs reads a length followed by that many pairs of strings
+func (d *Decoder) readPairs() ([]string, error) {
+	count, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]string, 0, min(count*2, 1024))
+	for i := uint64(0); i < count*2; i++ {
+		element, err := d.readStringValue()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
+func pairsToHash(elements []string) Hash {
+	hash := make(Hash, 0, len(elements)/2)
+	for i := 0; i+1 < len(elements); i += 2 {
+		hash = append(hash, [2]string{elements[i], elements[i+1]})
+	}
+	return hash
+}
+
+// readZSet reads a sorted set stored as member/score pairs
+func (d *Decoder) readZSet(binaryScores bool) (*storage.SortedSet, error) {
+	count, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	zset := storage.NewSortedSet()
+	for i := uint64(0); i < count; i++ {
+		member, err := d.readStringValue()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScores {
+			score, err = d.readBinaryDouble()
+		} else {
+			score, err = d.readDouble()
+		}
+		if err != nil {
+			return nil, err
+		}
+		zset.Add(member, score)
+	}
+	return zset, nil
+}
+
+// readPacked reads a value serialized as a single ziplist, listpack or
+// intset blob
+func (d *Decoder) readPacked(objType byte) (interface{}, error) {
+	blob, err := d.readString()
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []string
+	switch objType {
+	case TypeListZiplist, TypeZSetZiplist, TypeHashZiplist:
+		elements, err = parseZiplist(blob)
+	case TypeSetIntset:
+		elements, err = parseIntset(blob)
+	default:
+		elements, err = parseListpack(blob)
+	}
+	if err != nil {
+		return nil, d.wrap(err)
+	}
+
+	switch objType {
+	case TypeListZiplist:
+		return List(elements), nil
+	case TypeSetIntset, TypeSetListpack:
+		return Set(elements), nil
+	case TypeHashZiplist, TypeHashListpack:
+		if len(elements)%2 != 0 {
+			return nil, d.errorf("odd number of hash elements")
+		}
+		return pairsToHash(elements), nil
+	}
+
+	// Sorted sets alternate members and scores
+	if len(elements)%2 != 0 {
+		return nil, d.errorf("odd number of sorted set elements")
+	}
+	zset := storage.NewSortedSet()
+	for i := 0; i < len(elements); i += 2 {
+		score, err := strconv.ParseFloat(elements[i+1], 64)
+		if err != nil {
+			return nil, d.errorf("invalid sorted set score %q", elements[i+1])
+		}
+		zset.Add(elements[i], score)
+	}
+	return zset, nil
+}
+
+// readQuicklist reads a list stored as a sequence of ziplists or, for
+// quicklist 2, of listpacks and plain nodes
+func (d *Decoder) readQuicklist(v2 bool) (List, error) {
+	count, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+
+	var list List
+	for i := uint64(0); i < count; i++ {
+		container := uint64(2) // Packed
+		if v2 {
+			if container, err = d.readLen(); err != nil {
+				return nil, err
+			}
+		}
+		blob, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+
+		if container == 1 { // Plain node holding a single large element
+			list = append(list, string(blob))
+			continue
+		}
+		var elements []string
+		if v2 {
+			elements, err = parseListpack(blob)
+		} else {
+			elements, err = parseZiplist(blob)
+		}
+		if err != nil {
+			return nil, d.wrap(err)
+		}
+		list = append(list, elements...)
+	}
+	return list, nil
+}
+
+// readStreamID reads an ID stored as two lengths
+func (d *Decoder) readStreamID() (storage.StreamID, error) {
+	ms, err := d.readLen()
+	if err != nil {
+		return storage.StreamID{}, err
+	}
+	seq, err := d.readLen()
+	if err != nil {
+		return storage.StreamID{}, err
+	}
+	return storage.StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// readRawStreamID reads an ID stored as 16 big endian bytes
+func (d *Decoder) readRawStreamID() (storage.StreamID, error) {
+	buf, err := d.readFull(16)
+	if err != nil {
+		return storage.StreamID{}, err
+	}
+	return storage.StreamIDFromBytes(buf), nil
+}
+
+// readStream reads a stream with its consumer groups
+func (d *Decoder) readStream(objType byte) (*storage.Stream, error) {
+	stream := storage.NewStream()
+
+	nodes, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		key, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, d.errorf("stream node key is not a 128 bit ID")
+		}
+		blob, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		elements, err := parseListpack(blob)
+		if err != nil {
+			return nil, d.wrap(err)
+		}
+		if err := addStreamNode(stream, storage.StreamIDFromBytes(key), elements); err != nil {
+			return nil, d.wrap(err)
+		}
+	}
+
+	length, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	lastID, err := d.readStreamID()
+	if err != nil {
+		return nil, err
+	}
+	if length != stream.Len() {
+		return nil, d.errorf("stream length %d does not match its %d entries", length, stream.Len())
+	}
+
+	entriesAdded := stream.Len()
+	var maxDeletedID storage.StreamID
+	if objType >= TypeStreamListpacks2 {
+		// The first ID is derived from the entries
+		if _, err := d.readStreamID(); err != nil {
+			return nil, err
+		}
+		if maxDeletedID, err = d.readStreamID(); err != nil {
+			return nil, err
+		}
+		if entriesAdded, err = d.readLen(); err != nil {
+			return nil, err
+		}
+	}
+	stream.SetLastID(lastID, entriesAdded, maxDeletedID)
+
+	groups, err := d.readLen()
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if err := d.readStreamGroup(stream, objType); err != nil {
+			return nil, err
+		}
+	}
+	return stream, nil
+}
+
+// addStreamNode adds the live entries of a stream listpack node
+func addStreamNode(stream *storage.Stream, master storage.StreamID, lp []string) error {
+	errNode := errors.New("invalid stream listpack node")
+	ints := func(elements []string) ([]int64, bool) {
+		values := make([]int64, len(elements))
+		for i, element := range elements {
+			v, err := strconv.ParseInt(element, 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			values[i] = v
+		}
+		return values, true
+	}
+
+	// Master entry: count, deleted, number of fields, fields..., 0
+	if len(lp) < 3 {
+		return errNode
+	}
+	header, ok := ints(lp[:3])
+	if !ok || header[2] < 0 || int64(len(lp)) < 4+header[2] {
+		return errNode
+	}
+	masterFields := lp[3 : 3+header[2]]
+	p := int(4 + header[2])
+
+	for p < len(lp) {
+		if p+3 > len(lp) {
+			return errNode
+		}
+		entryHeader, ok := ints(lp[p : p+3])
+		if !ok {
+			return errNode
+		}
+		flags, msDiff, seqDiff := entryHeader[0], entryHeader[1], entryHeader[2]
+		p += 3
+
+		var fields []string
+		if flags&2 != 0 { // Same fields as the master entry
+			if p+len(masterFields) > len(lp) {
+				return errNode
+			}
+			fields = make([]string, 0, len(masterFields)*2)
+			for i, field := range masterFields {
+				fields = append(fields, field, lp[p+i])
+			}
+			p += len(masterFields)
+		} else {
+			if p >= len(lp) {
+				return errNode
+			}
+			count, err := strconv.Atoi(lp[p])
+			if err != nil || count < 0 || p+1+count*2 > len(lp) {
+				return errNode
+			}
+			fields = append([]string(nil), lp[p+1:p+1+count*2]...)
+			p += 1 + count*2
+		}
+		p++ // lp-count of the entry
+
+		if flags&1 != 0 { // Deleted
+			continue
+		}
+		id := storage.StreamID{Ms: master.Ms + uint64(msDiff), Seq: master.Seq + uint64(seqDiff)}
+		if stream.Len() > 0 && id.Compare(stream.LastID()) <= 0 {
+			return errors.New("stream entries out of order")
+		}
+		stream.Add(id, fields)
+	}
+	return nil
+}
+
+// readStreamGroup reads a consumer group with its pending entries and consumers
+func (d *Decoder) readStreamGroup(stream *storage.Stream, objType byte) error {
+	name, err := d.readStringValue()
+	if err != nil {
+		return err
+	}
+	lastID, err := d.readStreamID()
+	if err != nil {
+		return err
+	}
+	entriesRead := int64(-1)
+	if objType >= TypeStreamListpacks2 {
+		value, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		entriesRead = int64(value)
+	} else {
+		entriesRead = stream.EstimateEntriesRead(lastID)
+	}
+
+	group, created := stream.CreateGroup(name, lastID, entriesRead)
+	if !created {
+		return d.errorf("duplicated consumer group name %q", name)
+	}
+
+	pending, err := d.readLen()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < pending; i++ {
+		id, err := d.readRawStreamID()
+		if err != nil {
+			return err
+		}
+		deliveryTime, err := d.readMillis()
+		if err != nil {
+			return err
+		}
+		deliveryCount, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		group.PEL.Insert(id.Bytes(), &storage.StreamNACK{ID: id, DeliveryTime: deliveryTime, DeliveryCount: deliveryCount})
+	}
+
+	consumers, err := d.readLen()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < consumers; i++ {
+		consumerName, err := d.readStringValue()
+		if err != nil {
+			return err
+		}
+		seenTime, err := d.readMillis()
+		if err != nil {
+			return err
+		}
+		activeTime := seenTime
+		if objType >= TypeStreamListpacks3 {
+			if activeTime, err = d.readMillis(); err != nil {
+				return err
+			}
+		}
+
+		consumer, _ := group.CreateConsumer(consumerName, seenTime)
+		consumer.ActiveTime = activeTime
+
+		owned, err := d.readLen()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < owned; j++ {
+			id, err := d.readRawStreamID()
+			if err != nil {
+				return err
+			}
+			nack := group.NACK(id)
+			if nack == nil {
+				return d.errorf("consumer %q owns unknown pending entry %s", consumerName, id)
+			}
+			group.Assign(nack, consumer)
+		}
+	}
+
+	// Every pending entry is owned by a consumer
+	orphan := false
+	group.PEL.Ascend(nil, func(_ []byte, nack *storage.StreamNACK) bool {
+		orphan = nack.Consumer == nil
+		return !orphan
+	})
+	if orphan {
+		return d.errorf("stream PEL entry without consumer")
+	}
+	return nil
+}
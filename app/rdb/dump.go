@@ -0,0 +1,68 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrDumpPayload is returned for a DUMP payload with an unsupported version
+// or a wrong checksum
+var ErrDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// Dump serializes a value the way Redis' DUMP does: the RDB object type and
+// encoding of the value, followed by a 2 byte RDB version and the CRC64 of
+// everything before it, both little endian
+func Dump(value interface{}) ([]byte, error) {
+	objType, err := ObjectType(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	e.writeByte(objType)
+	e.writeObject(value)
+	if e.err != nil {
+		return nil, e.err
+	}
+	if err := e.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	payload := binary.LittleEndian.AppendUint16(buf.Bytes(), Version)
+	return binary.LittleEndian.AppendUint64(payload, Checksum(payload)), nil
+}
+
+// Undump deserializes a payload produced by Dump or by Redis' DUMP, after
+// checking its version and checksum. The value is returned the way the
+// decoder returns it, see Entry.Value.
+func Undump(payload []byte) (interface{}, error) {
+	if len(payload) < 10 {
+		return nil, ErrDumpPayload
+	}
+	body := payload[:len(payload)-10]
+	version := binary.LittleEndian.Uint16(payload[len(payload)-10:])
+	if version < 1 || version > maxVersion {
+		return nil, ErrDumpPayload
+	}
+	if binary.LittleEndian.Uint64(payload[len(payload)-8:]) != Checksum(payload[:len(payload)-8]) {
+		return nil, ErrDumpPayload
+	}
+
+	d := NewDecoder(bytes.NewReader(body))
+	d.version = int(version)
+	d.started = true
+	objType, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	value, err := d.readObject(objType)
+	if err != nil {
+		return nil, err
+	}
+	if d.offset != int64(len(body)) {
+		return nil, d.errorf("%d unexpected bytes after the value", int64(len(body))-d.offset)
+	}
+	return value, nil
+}
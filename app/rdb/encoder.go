@@ -0,0 +1,401 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// Strings shorter than this are never compressed, like Redis
+const compressMinLen = 20
+
+// streamNodeEntries is the number of entries packed per stream node
+const streamNodeEntries = 100
+
+// Encoder writes an RDB file
+type Encoder struct {
+	w   *bufio.Writer
+	crc uint64
+	err error
+	lzf *lzfTable
+
+	// Compress enables LZF compression of long strings
+	Compress bool
+}
+
+// NewEncoder creates an encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:        bufio.NewWriterSize(w, 64*1024),
+		lzf:      new(lzfTable),
+		Compress: true,
+	}
+}
+
+// write appends p to the output, keeping the first error
+func (e *Encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc = crcUpdate(e.crc, p)
+	_, e.err = e.w.Write(p)
+}
+
+func (e *Encoder) writeByte(b byte) {
+	e.write([]byte{b})
+}
+
+// writeLength writes a length with the smallest encoding
+func (e *Encoder) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.writeByte(byte(n))
+	case n < 1<<14:
+		e.write([]byte{0x40 | byte(n>>8), byte(n)})
+	case n <= math.MaxUint32:
+		buf := []byte{0x80, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(buf[1:], uint32(n))
+		e.write(buf)
+	default:
+		buf := []byte{0x81, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(buf[1:], n)
+		e.write(buf)
+	}
+}
+
+// writeString writes a string, as an integer or LZF compressed when that
+// is smaller
+func (e *Encoder) writeString(s []byte) {
+	if len(s) <= 11 {
+		if v, ok := canonicalInt(string(s)); ok && v >= math.MinInt32 && v <= math.MaxInt32 {
+			e.writeInt(v)
+			return
+		}
+	}
+
+	if e.Compress && len(s) > compressMinLen {
+		if compressed := lzfCompress(s, len(s)-4, e.lzf); compressed != nil {
+			e.writeByte(0xc0 | encLZF)
+			e.writeLength(uint64(len(compressed)))
+			e.writeLength(uint64(len(s)))
+			e.write(compressed)
+			return
+		}
+	}
+
+	e.writeLength(uint64(len(s)))
+	e.write(s)
+}
+
+// writeInt writes an integer encoded string
+func (e *Encoder) writeInt(v int64) {
+	switch {
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		e.write([]byte{0xc0 | encInt8, byte(v)})
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		e.write([]byte{0xc0 | encInt16, byte(v), byte(v >> 8)})
+	default:
+		e.write([]byte{0xc0 | encInt32, byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)})
+	}
+}
+
+func (e *Encoder) writeStringValue(s string) {
+	e.writeString([]byte(s))
+}
+
+func (e *Encoder) writeMillis(ms int64) {
+	e.write(binary.LittleEndian.AppendUint64(nil, uint64(ms)))
+}
+
+// WriteHeader writes the magic string, the version and the AUX fields
+// identifying the server
+func (e *Encoder) WriteHeader() error {
+	e.write([]byte(fmt.Sprintf("REDIS%04d", Version)))
+	e.WriteAux("redis-ver", "7.2.0")
+	e.WriteAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	e.WriteAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	e.WriteAux("aof-base", "0")
+	return e.err
+}
+
+// WriteAux writes an AUX field
+func (e *Encoder) WriteAux(key, value string) {
+	e.writeByte(opAux)
+	e.writeStringValue(key)
+	e.writeStringValue(value)
+}
+
+// WriteDB starts a database section with its size hints
+func (e *Encoder) WriteDB(db, size, expires int) error {
+	e.writeByte(opSelectDB)
+	e.writeLength(uint64(db))
+	e.writeByte(opResizeDB)
+	e.writeLength(uint64(size))
+	e.writeLength(uint64(expires))
+	return e.err
+}
+
+// WriteEntry writes a key with its value and expiration (Unix time in
+// milliseconds, 0 for none)
+func (e *Encoder) WriteEntry(key string, value interface{}, expireAt int64) error {
+	objType, err := ObjectType(value)
+	if err != nil {
+		return err
+	}
+	if expireAt != 0 {
+		e.writeByte(opExpireTimeMs)
+		e.writeMillis(expireAt)
+	}
+	e.writeByte(objType)
+	e.writeStringValue(key)
+	e.writeObject(value)
+	return e.err
+}
+
+// Close writes the EOF opcode and the checksum, and flushes the output
+func (e *Encoder) Close() error {
+	e.writeByte(opEOF)
+	if e.err != nil {
+		return e.err
+	}
+	// The checksum covers everything before it, so it is written raw
+	_, e.err = e.w.Write(binary.LittleEndian.AppendUint64(nil, e.crc))
+	if e.err != nil {
+		return e.err
+	}
+	return e.w.Flush()
+}
+
+// ObjectType returns the RDB type a value is written with
+func ObjectType(value interface{}) (byte, error) {
+	switch value.(type) {
+	case string, []byte:
+		return TypeString, nil
+	case List:
+		return TypeList, nil
+	case Set:
+		return TypeSet, nil
+	case Hash:
+		return TypeHash, nil
+	case *storage.SortedSet:
+		return TypeZSet2, nil
+	case *storage.Stream:
+		return TypeStreamListpacks3, nil
+	}
+	return 0, fmt.Errorf("cannot serialize value of type %T", value)
+}
+
+// writeObject writes a value, its type must have been checked by ObjectType
+func (e *Encoder) writeObject(value interface{}) {
+	switch v := value.(type) {
+	case string:
+		e.writeStringValue(v)
+	case []byte:
+		e.writeString(v)
+	case List:
+		e.writeStrings(v)
+	case Set:
+		e.writeStrings(v)
+	case Hash:
+		e.writeLength(uint64(len(v)))
+		for _, pair := range v {
+			e.writeStringValue(pair[0])
+			e.writeStringValue(pair[1])
+		}
+	case *storage.SortedSet:
+		e.writeLength(uint64(v.Len()))
+		v.Ascend(func(member string, score float64) bool {
+			e.writeStringValue(member)
+			e.write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(score)))
+			return true
+		})
+	case *storage.Stream:
+		e.writeStream(v)
+	}
+}
+
+func (e *Encoder) writeStrings(elements []string) {
+	e.writeLength(uint64(len(elements)))
+	for _, element := range elements {
+		e.writeStringValue(element)
+	}
+}
+
+// writeStreamID writes an ID as two lengths
+func (e *Encoder) writeStreamID(id storage.StreamID) {
+	e.writeLength(id.Ms)
+	e.writeLength(id.Seq)
+}
+
+// writeStream writes a stream as listpack nodes followed by its metadata
+// and consumer groups
+func (e *Encoder) writeStream(stream *storage.Stream) {
+	var nodes [][]storage.StreamEntry
+	start := storage.StreamID{}
+	for {
+		entries := stream.Range(start, storage.MaxStreamID, streamNodeEntries, false)
+		if len(entries) == 0 {
+			break
+		}
+		nodes = append(nodes, entries)
+		next, ok := entries[len(entries)-1].ID.Incr()
+		if !ok {
+			break
+		}
+		start = next
+	}
+
+	e.writeLength(uint64(len(nodes)))
+	for _, entries := range nodes {
+		e.writeString(entries[0].ID.Bytes())
+		e.writeString(streamListpack(entries))
+	}
+
+	e.writeLength(stream.Len())
+	e.writeStreamID(stream.LastID())
+	e.writeStreamID(stream.FirstID())
+	e.writeStreamID(stream.MaxDeletedID())
+	e.writeLength(stream.EntriesAdded())
+
+	groups := stream.Groups()
+	e.writeLength(uint64(len(groups)))
+	for _, group := range groups {
+		e.writeStringValue(group.Name)
+		e.writeStreamID(group.LastID)
+		e.writeLength(uint64(group.EntriesRead))
+
+		e.writeLength(uint64(group.PEL.Len()))
+		group.PEL.Ascend(nil, func(key []byte, nack *storage.StreamNACK) bool {
+			e.write(key)
+			e.writeMillis(nack.DeliveryTime)
+			e.writeLength(nack.DeliveryCount)
+			return true
+		})
+
+		consumers := group.Consumers()
+		e.writeLength(uint64(len(consumers)))
+		for _, consumer := range consumers {
+			e.writeStringValue(consumer.Name)
+			e.writeMillis(consumer.SeenTime)
+			e.writeMillis(consumer.ActiveTime)
+			e.writeLength(uint64(consumer.PEL.Len()))
+			consumer.PEL.Ascend(nil, func(key []byte, _ *storage.StreamNACK) bool {
+				e.write(key)
+				return true
+			})
+		}
+	}
+}
+
+// streamListpack packs entries the way Redis does: a master entry with the
+// fields of the first entry, then every entry relative to the master ID,
+// flagged when it shares the master fields
+func streamListpack(entries []storage.StreamEntry) []byte {
+	master := entries[0].ID
+	masterFields := fieldNames(entries[0].Fields)
+
+	lp := newListpackBuilder()
+	lp.appendInt(int64(len(entries))) // Valid entries
+	lp.appendInt(0)                   // Deleted entries
+	lp.appendInt(int64(len(masterFields)))
+	for _, field := range masterFields {
+		lp.appendString(field)
+	}
+	lp.appendInt(0) // Master entry terminator
+
+	for _, entry := range entries {
+		fields := fieldNames(entry.Fields)
+		sameFields := equalFields(fields, masterFields)
+		flags := int64(0)
+		if sameFields {
+			flags = 2
+		}
+		lp.appendInt(flags)
+		lp.appendInt(int64(entry.ID.Ms - master.Ms))
+		lp.appendInt(int64(entry.ID.Seq - master.Seq))
+		if sameFields {
+			for i := 1; i < len(entry.Fields); i += 2 {
+				lp.appendString(entry.Fields[i])
+			}
+			lp.appendInt(int64(len(fields) + 3))
+		} else {
+			lp.appendInt(int64(len(fields)))
+			for _, field := range entry.Fields {
+				lp.appendString(field)
+			}
+			lp.appendInt(int64(len(fields)*2 + 4))
+		}
+	}
+	return lp.bytes()
+}
+
+func fieldNames(fields []string) []string {
+	names := make([]string, 0, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		names = append(names, fields[i])
+	}
+	return names
+}
+
+func equalFields(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteSnapshot writes a complete RDB file with the keys of snapshot,
+// sorted so that the output is deterministic. aux holds extra AUX fields,
+// such as the replication ID and offset of the dataset.
+func WriteSnapshot(w io.Writer, snapshot map[string]storage.KeyValue, aux map[string]string) error {
+	keys := make([]string, 0, len(snapshot))
+	expires := 0
+	for key, kv := range snapshot {
+		keys = append(keys, key)
+		if kv.ExpiresAt != nil {
+			expires++
+		}
+	}
+	sort.Strings(keys)
+
+	e := NewEncoder(w)
+	if err := e.WriteHeader(); err != nil {
+		return err
+	}
+	auxKeys := make([]string, 0, len(aux))
+	for key := range aux {
+		auxKeys = append(auxKeys, key)
+	}
+	sort.Strings(auxKeys)
+	for _, key := range auxKeys {
+		e.WriteAux(key, aux[key])
+	}
+	if len(keys) > 0 {
+		if err := e.WriteDB(0, len(keys), expires); err != nil {
+			return err
+		}
+	}
+	for _, key := range keys {
+		kv := snapshot[key]
+		var expireAt int64
+		if kv.ExpiresAt != nil {
+			expireAt = kv.ExpiresAt.UnixMilli()
+		}
+		if err := e.WriteEntry(key, kv.Value, expireAt); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
@@ -0,0 +1,343 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var (
+	errZiplist  = errors.New("invalid ziplist")
+	errListpack = errors.New("invalid listpack")
+	errIntset   = errors.New("invalid intset")
+)
+
+// parseZiplist returns the elements of a ziplist blob as strings
+func parseZiplist(zl []byte) ([]string, error) {
+	if len(zl) < 11 {
+		return nil, errZiplist
+	}
+	p := 10 // zlbytes, zltail and zllen
+	var elements []string
+
+	for {
+		if p >= len(zl) {
+			return nil, errZiplist
+		}
+		if zl[p] == 0xff {
+			return elements, nil
+		}
+
+		// Length of the previous entry, 1 or 5 bytes
+		if zl[p] < 254 {
+			p++
+		} else {
+			p += 5
+		}
+		if p >= len(zl) {
+			return nil, errZiplist
+		}
+
+		enc := zl[p]
+		p++
+		need := func(n int) bool { return p+n <= len(zl) }
+
+		switch {
+		case enc>>6 == 0: // 6 bit string length
+			n := int(enc & 0x3f)
+			if !need(n) {
+				return nil, errZiplist
+			}
+			elements = append(elements, string(zl[p:p+n]))
+			p += n
+		case enc>>6 == 1: // 14 bit string length
+			if !need(1) {
+				return nil, errZiplist
+			}
+			n := int(enc&0x3f)<<8 | int(zl[p])
+			p++
+			if !need(n) {
+				return nil, errZiplist
+			}
+			elements = append(elements, string(zl[p:p+n]))
+			p += n
+		case enc == 0x80: // 32 bit string length
+			if !need(4) {
+				return nil, errZiplist
+			}
+			n := int(binary.BigEndian.Uint32(zl[p:]))
+			p += 4
+			if n < 0 || !need(n) {
+				return nil, errZiplist
+			}
+			elements = append(elements, string(zl[p:p+n]))
+			p += n
+		default:
+			var value int64
+			switch {
+			case enc == 0xc0:
+				if !need(2) {
+					return nil, errZiplist
+				}
+				value = int64(int16(binary.LittleEndian.Uint16(zl[p:])))
+				p += 2
+			case enc == 0xd0:
+				if !need(4) {
+					return nil, errZiplist
+				}
+				value = int64(int32(binary.LittleEndian.Uint32(zl[p:])))
+				p += 4
+			case enc == 0xe0:
+				if !need(8) {
+					return nil, errZiplist
+				}
+				value = int64(binary.LittleEndian.Uint64(zl[p:]))
+				p += 8
+			case enc == 0xf0:
+				if !need(3) {
+					return nil, errZiplist
+				}
+				// 24 bit integer, sign extended
+				value = int64(int32(uint32(zl[p])<<8|uint32(zl[p+1])<<16|uint32(zl[p+2])<<24) >> 8)
+				p += 3
+			case enc == 0xfe:
+				if !need(1) {
+					return nil, errZiplist
+				}
+				value = int64(int8(zl[p]))
+				p++
+			case enc >= 0xf1 && enc <= 0xfd: // Immediate 0..12
+				value = int64(enc&0x0f) - 1
+			default:
+				return nil, errZiplist
+			}
+			elements = append(elements, strconv.FormatInt(value, 10))
+		}
+	}
+}
+
+// parseListpack returns the elements of a listpack blob as strings
+func parseListpack(lp []byte) ([]string, error) {
+	if len(lp) < 7 {
+		return nil, errListpack
+	}
+	p := 6 // total bytes and number of elements
+	var elements []string
+
+	for {
+		if p >= len(lp) {
+			return nil, errListpack
+		}
+		enc := lp[p]
+		if enc == 0xff {
+			return elements, nil
+		}
+
+		start := p
+		p++
+		need := func(n int) bool { return p+n <= len(lp) }
+		var element string
+
+		switch {
+		case enc>>7 == 0: // 7 bit unsigned integer
+			element = strconv.Itoa(int(enc & 0x7f))
+		case enc>>6 == 2: // 6 bit string length
+			n := int(enc & 0x3f)
+			if !need(n) {
+				return nil, errListpack
+			}
+			element = string(lp[p : p+n])
+			p += n
+		case enc>>5 == 6: // 13 bit signed integer
+			if !need(1) {
+				return nil, errListpack
+			}
+			value := int(enc&0x1f)<<8 | int(lp[p])
+			p++
+			if value >= 1<<12 {
+				value -= 1 << 13
+			}
+			element = strconv.Itoa(value)
+		case enc>>4 == 14: // 12 bit string length
+			if !need(1) {
+				return nil, errListpack
+			}
+			n := int(enc&0x0f)<<8 | int(lp[p])
+			p++
+			if !need(n) {
+				return nil, errListpack
+			}
+			element = string(lp[p : p+n])
+			p += n
+		case enc == 0xf0: // 32 bit string length
+			if !need(4) {
+				return nil, errListpack
+			}
+			n := int(binary.LittleEndian.Uint32(lp[p:]))
+			p += 4
+			if n < 0 || !need(n) {
+				return nil, errListpack
+			}
+			element = string(lp[p : p+n])
+			p += n
+		case enc >= 0xf1 && enc <= 0xf4: // 16, 24, 32 and 64 bit integers
+			size := [...]int{2, 3, 4, 8}[enc-0xf1]
+			if !need(size) {
+				return nil, errListpack
+			}
+			var raw uint64
+			for i := size - 1; i >= 0; i-- {
+				raw = raw<<8 | uint64(lp[p+i])
+			}
+			p += size
+			// Sign extend from the integer width
+			shift := uint(64 - size*8)
+			element = strconv.FormatInt(int64(raw<<shift)>>shift, 10)
+		default:
+			return nil, errListpack
+		}
+
+		// Skip the backlen, which encodes the size of the entry
+		entryLen := p - start
+		switch {
+		case entryLen <= 127:
+			p++
+		case entryLen < 16383:
+			p += 2
+		case entryLen < 2097151:
+			p += 3
+		case entryLen < 268435455:
+			p += 4
+		default:
+			p += 5
+		}
+		elements = append(elements, element)
+	}
+}
+
+// parseIntset returns the elements of an intset blob as strings
+func parseIntset(is []byte) ([]string, error) {
+	if len(is) < 8 {
+		return nil, errIntset
+	}
+	width := int(binary.LittleEndian.Uint32(is))
+	count := int(binary.LittleEndian.Uint32(is[4:]))
+	if (width != 2 && width != 4 && width != 8) || count < 0 || 8+count*width > len(is) {
+		return nil, errIntset
+	}
+
+	elements := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		p := is[8+i*width:]
+		var value int64
+		switch width {
+		case 2:
+			value = int64(int16(binary.LittleEndian.Uint16(p)))
+		case 4:
+			value = int64(int32(binary.LittleEndian.Uint32(p)))
+		case 8:
+			value = int64(binary.LittleEndian.Uint64(p))
+		}
+		elements = append(elements, strconv.FormatInt(value, 10))
+	}
+	return elements, nil
+}
+
+// listpackBuilder serializes elements in the listpack format
+type listpackBuilder struct {
+	buf   []byte
+	count int
+}
+
+func newListpackBuilder() *listpackBuilder {
+	return &listpackBuilder{buf: make([]byte, 6, 256)}
+}
+
+// appendInt adds an integer element with the smallest encoding
+func (lp *listpackBuilder) appendInt(v int64) {
+	start := len(lp.buf)
+	switch {
+	case v >= 0 && v <= 127:
+		lp.buf = append(lp.buf, byte(v))
+	case v >= -4096 && v <= 4095:
+		u := uint64(v) & 0x1fff
+		lp.buf = append(lp.buf, byte(u>>8)|0xc0, byte(u))
+	default:
+		size, enc := 8, byte(0xf4)
+		switch {
+		case v >= -1<<15 && v < 1<<15:
+			size, enc = 2, 0xf1
+		case v >= -1<<23 && v < 1<<23:
+			size, enc = 3, 0xf2
+		case v >= -1<<31 && v < 1<<31:
+			size, enc = 4, 0xf3
+		}
+		lp.buf = append(lp.buf, enc)
+		for i := 0; i < size; i++ {
+			lp.buf = append(lp.buf, byte(uint64(v)>>(8*i)))
+		}
+	}
+	lp.appendBacklen(len(lp.buf) - start)
+}
+
+// appendString adds a string element, stored as an integer when it is the
+// canonical representation of one like Redis does
+func (lp *listpackBuilder) appendString(s string) {
+	if v, ok := canonicalInt(s); ok {
+		lp.appendInt(v)
+		return
+	}
+
+	start := len(lp.buf)
+	n := len(s)
+	switch {
+	case n < 64:
+		lp.buf = append(lp.buf, 0x80|byte(n))
+	case n < 4096:
+		lp.buf = append(lp.buf, 0xe0|byte(n>>8), byte(n))
+	default:
+		lp.buf = append(lp.buf, 0xf0)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(n))
+	}
+	lp.buf = append(lp.buf, s...)
+	lp.appendBacklen(len(lp.buf) - start)
+}
+
+// appendBacklen writes the size of the entry so the listpack can be
+// walked backwards
+func (lp *listpackBuilder) appendBacklen(l int) {
+	switch {
+	case l <= 127:
+		lp.buf = append(lp.buf, byte(l))
+	case l < 16383:
+		lp.buf = append(lp.buf, byte(l>>7), byte(l&127)|128)
+	case l < 2097151:
+		lp.buf = append(lp.buf, byte(l>>14), byte((l>>7)&127)|128, byte(l&127)|128)
+	case l < 268435455:
+		lp.buf = append(lp.buf, byte(l>>21), byte((l>>14)&127)|128, byte((l>>7)&127)|128, byte(l&127)|128)
+	default:
+		lp.buf = append(lp.buf, byte(l>>28), byte((l>>21)&127)|128, byte((l>>14)&127)|128, byte((l>>7)&127)|128, byte(l&127)|128)
+	}
+	lp.count++
+}
+
+// bytes terminates the listpack and fills in its header
+func (lp *listpackBuilder) bytes() []byte {
+	lp.buf = append(lp.buf, 0xff)
+	binary.LittleEndian.PutUint32(lp.buf, uint32(len(lp.buf)))
+	binary.LittleEndian.PutUint16(lp.buf[4:], uint16(min(lp.count, 65535)))
+	return lp.buf
+}
+
+// canonicalInt parses s as an int64 only if formatting the result gives
+// back s, so the conversion never changes the stored string
+func canonicalInt(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != s {
+		return 0, false
+	}
+	return v, true
+}
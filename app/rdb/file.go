@@ -0,0 +1,185 @@
+package rdb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/crypt"
+	"github.com/codecrafters-io/redis-starter-go/app/repository"
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// LoadStats summarizes a load
+type LoadStats struct {
+	Loaded  int // Keys stored in the repository
+	Expired int // Keys skipped because they were already expired
+	Skipped int // Keys skipped because their type or database is not supported
+
+	// Bytes is the size of the RDB data, which may be followed by more data
+	// in the stream (such as the commands of an AOF with an RDB preamble)
+	Bytes int64
+
+	// Aux holds the AUX fields of the file
+	Aux map[string]string
+}
+
+// ReadSnapshot decodes an RDB stream into a keyspace. Keys of types the
+// server cannot hold yet (lists, sets, hashes) and keys of databases other
+// than 0 are skipped and counted, as are keys that already expired.
+func ReadSnapshot(r io.Reader) (map[string]storage.KeyValue, LoadStats, error) {
+	var stats LoadStats
+	snapshot := make(map[string]storage.KeyValue)
+	decoder := NewDecoder(r)
+	now := time.Now().UnixMilli()
+
+	for {
+		entry, err := decoder.Next()
+		if err == io.EOF {
+			stats.Bytes = decoder.Offset()
+			stats.Aux = decoder.Aux
+			return snapshot, stats, nil
+		}
+		if err != nil {
+			return nil, stats, err
+		}
+
+		if entry.ExpireAt != 0 && entry.ExpireAt <= now {
+			stats.Expired++
+			continue
+		}
+		if entry.DB != 0 {
+			stats.Skipped++
+			continue
+		}
+		switch entry.Value.(type) {
+		case string, *storage.SortedSet, *storage.Stream:
+		default:
+			fmt.Printf("Skipping key %q from RDB: unsupported value type %T\n", entry.Key, entry.Value)
+			stats.Skipped++
+			continue
+		}
+
+		kv := storage.KeyValue{Value: entry.Value}
+		if entry.ExpireAt != 0 {
+			t := time.UnixMilli(entry.ExpireAt)
+			kv.ExpiresAt = &t
+		}
+		snapshot[entry.Key] = kv
+		stats.Loaded++
+	}
+}
+
+// Load reads an RDB stream and replaces the content of repo with it. The
+// repository is left untouched when the stream cannot be decoded.
+func Load(r io.Reader, repo repository.KeyValueRepository) (LoadStats, error) {
+	snapshot, stats, err := ReadSnapshot(r)
+	if err != nil {
+		return stats, err
+	}
+	repo.Replace(snapshot)
+	return stats, nil
+}
+
+// LoadFile loads the RDB file at path into repo. A missing file is not an
+// error, the server then starts with an empty dataset. An encrypted file is
+// decrypted with key.
+func LoadFile(path string, repo repository.KeyValueRepository, key []byte) (LoadStats, error) {
+	file, err := crypt.Open(path, key)
+	if os.IsNotExist(err) {
+		return LoadStats{}, nil
+	}
+	if err != nil {
+		return LoadStats{}, err
+	}
+	defer file.Close()
+
+	return Load(file, repo)
+}
+
+// ReadFile decodes the RDB file at path like ReadSnapshot. An encrypted
+// file is decrypted with key.
+func ReadFile(path string, key []byte) (map[string]storage.KeyValue, LoadStats, error) {
+	file, err := crypt.Open(path, key)
+	if err != nil {
+		return nil, LoadStats{}, err
+	}
+	defer file.Close()
+
+	return ReadSnapshot(file)
+}
+
+// SaveFile writes snapshot to path atomically: the data goes to a temporary
+// file in the same directory which is synced and renamed over path, so a
+// crash never leaves a partially written file behind. aux holds extra AUX
+// fields. The file is encrypted when key is not nil.
+func SaveFile(path string, snapshot map[string]storage.KeyValue, aux map[string]string, key []byte) error {
+	return WriteFile(path, func(w io.Writer) error {
+		return WriteFileSnapshot(w, snapshot, aux, key)
+	})
+}
+
+// SaveStream copies the RDB data read from r to path atomically, like
+// SaveFile. The file is encrypted when key is not nil.
+func SaveStream(path string, r io.Reader, key []byte) error {
+	return WriteFile(path, func(w io.Writer) error {
+		if key == nil {
+			_, err := io.Copy(w, r)
+			return err
+		}
+		encrypted, err := crypt.NewWriter(w, key)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(encrypted, r); err != nil {
+			return err
+		}
+		return encrypted.Close()
+	})
+}
+
+// WriteFile creates path atomically with the data written by write, like
+// SaveFile: a failed write leaves any previous file untouched
+func WriteFile(path string, write func(w io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), fmt.Sprintf("temp-%d-*.rdb", os.Getpid()))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	// CreateTemp uses 0600, snapshots are readable like the files Redis writes
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteFileSnapshot writes snapshot to w like WriteSnapshot, encrypted when
+// key is not nil
+func WriteFileSnapshot(w io.Writer, snapshot map[string]storage.KeyValue, aux map[string]string, key []byte) error {
+	if key == nil {
+		return WriteSnapshot(w, snapshot, aux)
+	}
+	encrypted, err := crypt.NewWriter(w, key)
+	if err != nil {
+		return err
+	}
+	if err := WriteSnapshot(encrypted, snapshot, aux); err != nil {
+		return err
+	}
+	return encrypted.Close()
+}
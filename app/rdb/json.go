@@ -0,0 +1,363 @@
+package rdb
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// jsonEntry is the JSON form of an entry, one per line in a JSON lines dump:
+//
+//	{"db":0,"key":"k","type":"string","expire_at":1700000000000,"value":"v"}
+//
+// Values are a string for strings, an array of strings for lists and sets,
+// an array of [field, value] pairs for hashes, an array of [member, score]
+// pairs for sorted sets and a jsonStream for streams. When a string of the
+// entry is not valid UTF-8, all of its strings are base64 encoded and
+// encoding is set to "base64".
+type jsonEntry struct {
+	DB       int             `json:"db"`
+	Key      string          `json:"key"`
+	Type     string          `json:"type"`
+	Encoding string          `json:"encoding,omitempty"`
+	ExpireAt int64           `json:"expire_at,omitempty"`
+	Value    json.RawMessage `json:"value"`
+}
+
+type jsonStream struct {
+	Entries      []jsonStreamEntry `json:"entries"`
+	LastID       string            `json:"last_id"`
+	MaxDeletedID string            `json:"max_deleted_id"`
+	EntriesAdded uint64            `json:"entries_added"`
+	Groups       []jsonStreamGroup `json:"groups,omitempty"`
+}
+
+type jsonStreamEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+type jsonStreamGroup struct {
+	Name        string               `json:"name"`
+	LastID      string               `json:"last_id"`
+	EntriesRead int64                `json:"entries_read"`
+	Pending     []jsonStreamPending  `json:"pending,omitempty"`
+	Consumers   []jsonStreamConsumer `json:"consumers,omitempty"`
+}
+
+type jsonStreamPending struct {
+	ID            string `json:"id"`
+	Consumer      string `json:"consumer,omitempty"`
+	DeliveryTime  int64  `json:"delivery_time"`
+	DeliveryCount uint64 `json:"delivery_count"`
+}
+
+type jsonStreamConsumer struct {
+	Name       string `json:"name"`
+	SeenTime   int64  `json:"seen_time"`
+	ActiveTime int64  `json:"active_time"`
+}
+
+// typeName returns the JSON type name of a value returned by the decoder
+func typeName(value interface{}) (string, error) {
+	switch value.(type) {
+	case string, []byte:
+		return "string", nil
+	case List:
+		return "list", nil
+	case Set:
+		return "set", nil
+	case Hash:
+		return "hash", nil
+	case *storage.SortedSet:
+		return "zset", nil
+	case *storage.Stream:
+		return "stream", nil
+	}
+	return "", fmt.Errorf("cannot convert value of type %T", value)
+}
+
+// MarshalJSON encodes an entry as a single line of JSON
+func MarshalJSON(entry *Entry) ([]byte, error) {
+	typ, err := typeName(entry.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	// Look for binary strings first, they switch the whole entry to base64
+	binary := !utf8.ValidString(entry.Key)
+	forEachString(entry.Value, func(s string) {
+		binary = binary || !utf8.ValidString(s)
+	})
+	str := func(s string) string { return s }
+	encoding := ""
+	if binary {
+		str = func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+		encoding = "base64"
+	}
+	strs := func(elements []string) []string {
+		out := make([]string, len(elements))
+		for i, s := range elements {
+			out[i] = str(s)
+		}
+		return out
+	}
+
+	var value interface{}
+	switch v := entry.Value.(type) {
+	case string:
+		value = str(v)
+	case []byte:
+		value = str(string(v))
+	case List:
+		value = strs(v)
+	case Set:
+		value = strs(v)
+	case Hash:
+		pairs := make([][2]string, len(v))
+		for i, pair := range v {
+			pairs[i] = [2]string{str(pair[0]), str(pair[1])}
+		}
+		value = pairs
+	case *storage.SortedSet:
+		pairs := make([][2]string, 0, v.Len())
+		v.Ascend(func(member string, score float64) bool {
+			pairs = append(pairs, [2]string{str(member), strconv.FormatFloat(score, 'g', 17, 64)})
+			return true
+		})
+		value = pairs
+	case *storage.Stream:
+		value = streamToJSON(v, str, strs)
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonEntry{
+		DB:       entry.DB,
+		Key:      str(entry.Key),
+		Type:     typ,
+		Encoding: encoding,
+		ExpireAt: entry.ExpireAt,
+		Value:    raw,
+	})
+}
+
+// forEachString calls fn with every string stored in a value
+func forEachString(value interface{}, fn func(string)) {
+	switch v := value.(type) {
+	case string:
+		fn(v)
+	case []byte:
+		fn(string(v))
+	case List:
+		for _, s := range v {
+			fn(s)
+		}
+	case Set:
+		for _, s := range v {
+			fn(s)
+		}
+	case Hash:
+		for _, pair := range v {
+			fn(pair[0])
+			fn(pair[1])
+		}
+	case *storage.SortedSet:
+		v.Ascend(func(member string, _ float64) bool {
+			fn(member)
+			return true
+		})
+	case *storage.Stream:
+		for _, entry := range v.Range(storage.StreamID{}, storage.MaxStreamID, -1, false) {
+			for _, s := range entry.Fields {
+				fn(s)
+			}
+		}
+		for _, group := range v.Groups() {
+			fn(group.Name)
+			for _, consumer := range group.Consumers() {
+				fn(consumer.Name)
+			}
+		}
+	}
+}
+
+func streamToJSON(stream *storage.Stream, str func(string) string, strs func([]string) []string) jsonStream {
+	out := jsonStream{
+		Entries:      []jsonStreamEntry{},
+		LastID:       stream.LastID().String(),
+		MaxDeletedID: stream.MaxDeletedID().String(),
+		EntriesAdded: stream.EntriesAdded(),
+	}
+	for _, entry := range stream.Range(storage.StreamID{}, storage.MaxStreamID, -1, false) {
+		out.Entries = append(out.Entries, jsonStreamEntry{ID: entry.ID.String(), Fields: strs(entry.Fields)})
+	}
+	for _, group := range stream.Groups() {
+		g := jsonStreamGroup{Name: str(group.Name), LastID: group.LastID.String(), EntriesRead: group.EntriesRead}
+		group.PEL.Ascend(nil, func(_ []byte, nack *storage.StreamNACK) bool {
+			p := jsonStreamPending{ID: nack.ID.String(), DeliveryTime: nack.DeliveryTime, DeliveryCount: nack.DeliveryCount}
+			if nack.Consumer != nil {
+				p.Consumer = str(nack.Consumer.Name)
+			}
+			g.Pending = append(g.Pending, p)
+			return true
+		})
+		for _, consumer := range group.Consumers() {
+			g.Consumers = append(g.Consumers, jsonStreamConsumer{Name: str(consumer.Name), SeenTime: consumer.SeenTime, ActiveTime: consumer.ActiveTime})
+		}
+		out.Groups = append(out.Groups, g)
+	}
+	return out
+}
+
+// UnmarshalJSON decodes an entry encoded by MarshalJSON
+func UnmarshalJSON(data []byte) (*Entry, error) {
+	var in jsonEntry
+	if err := json.Unmarshal(data, &in); err != nil {
+		return nil, err
+	}
+
+	var decodeErr error
+	str := func(s string) string { return s }
+	switch in.Encoding {
+	case "":
+	case "base64":
+		str = func(s string) string {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil && decodeErr == nil {
+				decodeErr = err
+			}
+			return string(b)
+		}
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", in.Encoding)
+	}
+	strs := func(elements []string) []string {
+		for i, s := range elements {
+			elements[i] = str(s)
+		}
+		return elements
+	}
+
+	entry := &Entry{DB: in.DB, Key: str(in.Key), ExpireAt: in.ExpireAt, Idle: -1, Freq: -1}
+	var err error
+	switch in.Type {
+	case "string":
+		var s string
+		err = json.Unmarshal(in.Value, &s)
+		entry.Value = str(s)
+	case "list":
+		var elements []string
+		err = json.Unmarshal(in.Value, &elements)
+		entry.Value = List(strs(elements))
+	case "set":
+		var elements []string
+		err = json.Unmarshal(in.Value, &elements)
+		entry.Value = Set(strs(elements))
+	case "hash":
+		var pairs [][2]string
+		err = json.Unmarshal(in.Value, &pairs)
+		for i := range pairs {
+			pairs[i] = [2]string{str(pairs[i][0]), str(pairs[i][1])}
+		}
+		entry.Value = Hash(pairs)
+	case "zset":
+		var pairs [][2]string
+		if err = json.Unmarshal(in.Value, &pairs); err != nil {
+			break
+		}
+		zset := storage.NewSortedSet()
+		for _, pair := range pairs {
+			score, perr := strconv.ParseFloat(pair[1], 64)
+			if perr != nil {
+				return nil, fmt.Errorf("invalid score %q of member %q", pair[1], pair[0])
+			}
+			zset.Add(str(pair[0]), score)
+		}
+		entry.Value = zset
+	case "stream":
+		var s jsonStream
+		if err = json.Unmarshal(in.Value, &s); err != nil {
+			break
+		}
+		entry.Value, err = streamFromJSON(s, str, strs)
+	default:
+		return nil, fmt.Errorf("unknown type %q", in.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %w", in.Type, err)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("invalid base64 string: %w", decodeErr)
+	}
+	entry.Type, _ = ObjectType(entry.Value)
+	return entry, nil
+}
+
+func streamFromJSON(in jsonStream, str func(string) string, strs func([]string) []string) (*storage.Stream, error) {
+	parseID := func(s string) (storage.StreamID, error) {
+		return storage.ParseStreamID(s, 0)
+	}
+
+	stream := storage.NewStream()
+	for _, entry := range in.Entries {
+		id, err := parseID(entry.ID)
+		if err != nil {
+			return nil, err
+		}
+		if id.Compare(stream.LastID()) <= 0 && stream.Len() > 0 {
+			return nil, fmt.Errorf("entry %s is not after the previous one", entry.ID)
+		}
+		if len(entry.Fields) == 0 || len(entry.Fields)%2 != 0 {
+			return nil, fmt.Errorf("entry %s has an odd number of fields", entry.ID)
+		}
+		stream.Add(id, strs(entry.Fields))
+	}
+
+	lastID, err := parseID(in.LastID)
+	if err != nil {
+		return nil, err
+	}
+	maxDeletedID, err := parseID(in.MaxDeletedID)
+	if err != nil {
+		return nil, err
+	}
+	stream.SetLastID(lastID, in.EntriesAdded, maxDeletedID)
+
+	for _, g := range in.Groups {
+		groupLastID, err := parseID(g.LastID)
+		if err != nil {
+			return nil, err
+		}
+		group, created := stream.CreateGroup(str(g.Name), groupLastID, g.EntriesRead)
+		if !created {
+			return nil, fmt.Errorf("duplicated consumer group name %q", g.Name)
+		}
+		for _, c := range g.Consumers {
+			consumer, _ := group.CreateConsumer(str(c.Name), c.SeenTime)
+			consumer.ActiveTime = c.ActiveTime
+		}
+		for _, p := range g.Pending {
+			id, err := parseID(p.ID)
+			if err != nil {
+				return nil, err
+			}
+			nack := &storage.StreamNACK{ID: id, DeliveryTime: p.DeliveryTime, DeliveryCount: p.DeliveryCount}
+			group.PEL.Insert(id.Bytes(), nack)
+			if p.Consumer != "" {
+				consumer := group.Consumer(str(p.Consumer))
+				if consumer == nil {
+					return nil, fmt.Errorf("pending entry %s owned by unknown consumer %q", p.ID, p.Consumer)
+				}
+				group.Assign(nack, consumer)
+			}
+		}
+	}
+	return stream, nil
+}
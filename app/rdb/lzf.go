@@ -0,0 +1,125 @@
+package rdb
+
+import "errors"
+
+var errLZF = errors.New("invalid LZF compressed string")
+
+// lzfDecompress expands LZF compressed data into a buffer of outLen bytes
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	out := make([]byte, 0, outLen)
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < 1<<5 {
+			// Literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if ip+n > len(in) || len(out)+n > outLen {
+				return nil, errLZF
+			}
+			out = append(out, in[ip:ip+n]...)
+			ip += n
+			continue
+		}
+
+		// Back reference
+		n := ctrl >> 5
+		if n == 7 {
+			if ip >= len(in) {
+				return nil, errLZF
+			}
+			n += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errLZF
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - 1 - int(in[ip])
+		ip++
+		n += 2
+		if ref < 0 || len(out)+n > outLen {
+			return nil, errLZF
+		}
+		// The reference may overlap the bytes being written
+		for i := 0; i < n; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+
+	if len(out) != outLen {
+		return nil, errLZF
+	}
+	return out, nil
+}
+
+const (
+	lzfMaxLiteral = 1 << 5
+	lzfMaxOffset  = 1 << 13
+	lzfMaxRef     = (1 << 8) + (1 << 3) // Longest back reference, 264 bytes
+	lzfHashLog    = 14
+)
+
+// lzfTable holds the last position+1 of each hashed 3 byte prefix. It is
+// reused across calls without clearing: stale positions are harmless since
+// every candidate is checked against the bytes being compressed.
+type lzfTable [1 << lzfHashLog]int32
+
+// lzfCompress compresses in with LZF. It returns nil when the result would
+// not fit in maxLen bytes, in which case the data is stored uncompressed.
+func lzfCompress(in []byte, maxLen int, table *lzfTable) []byte {
+	out := make([]byte, 0, maxLen)
+
+	literalStart := 0
+	flushLiterals := func(end int) bool {
+		for literalStart < end {
+			n := min(end-literalStart, lzfMaxLiteral)
+			if len(out)+1+n > maxLen {
+				return false
+			}
+			out = append(out, byte(n-1))
+			out = append(out, in[literalStart:literalStart+n]...)
+			literalStart += n
+		}
+		return true
+	}
+
+	ip := 0
+	for ip+2 < len(in) {
+		h := (uint32(in[ip])<<16 | uint32(in[ip+1])<<8 | uint32(in[ip+2])) * 2654435761 >> (32 - lzfHashLog)
+		ref := int(table[h]) - 1
+		table[h] = int32(ip + 1)
+
+		distance := ip - ref
+		if ref < 0 || ref >= ip || distance > lzfMaxOffset ||
+			in[ref] != in[ip] || in[ref+1] != in[ip+1] || in[ref+2] != in[ip+2] {
+			ip++
+			continue
+		}
+
+		length := 3
+		for length < lzfMaxRef && ip+length < len(in) && in[ref+length] == in[ip+length] {
+			length++
+		}
+		if !flushLiterals(ip) {
+			return nil
+		}
+
+		offset := distance - 1
+		encoded := length - 2
+		if encoded < 7 {
+			out = append(out, byte(encoded<<5|offset>>8), byte(offset))
+		} else {
+			out = append(out, byte(7<<5|offset>>8), byte(encoded-7), byte(offset))
+		}
+		if len(out) > maxLen {
+			return nil
+		}
+		ip += length
+		literalStart = ip
+	}
+
+	if !flushLiterals(len(in)) {
+		return nil
+	}
+	return out
+}
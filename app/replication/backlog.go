@@ -0,0 +1,77 @@
+package replication
+
+// Backlog is a circular buffer holding the latest bytes of the replication
+// stream, so a replica that lost its connection can get the commands it
+// missed instead of a whole new snapshot. Offsets follow Redis: the stream
+// offset is the number of bytes streamed so far, and a replica asks to
+// continue from its own offset plus one.
+type Backlog struct {
+	buf     []byte
+	idx     int   // Next write position in buf
+	histlen int   // Bytes of history held, at most len(buf)
+	offset  int64 // Stream offset after the last byte written
+}
+
+// NewBacklog creates an empty backlog of size bytes for a stream currently
+// at offset
+func NewBacklog(size int, offset int64) *Backlog {
+	return &Backlog{
+		buf:    make([]byte, size),
+		offset: offset,
+	}
+}
+
+// Write appends p to the backlog, overwriting the oldest bytes when full
+func (b *Backlog) Write(p []byte) {
+	b.offset += int64(len(p))
+	if len(p) >= len(b.buf) {
+		// Only the tail fits
+		copy(b.buf, p[len(p)-len(b.buf):])
+		b.idx = 0
+		b.histlen = len(b.buf)
+		return
+	}
+	n := copy(b.buf[b.idx:], p)
+	copy(b.buf, p[n:])
+	b.idx = (b.idx + len(p)) % len(b.buf)
+	b.histlen = min(b.histlen+len(p), len(b.buf))
+}
+
+// Offset returns the stream offset after the last byte written
+func (b *Backlog) Offset() int64 {
+	return b.offset
+}
+
+// FirstOffset returns the offset of the oldest byte held, plus one like the
+// offsets sent by replicas: continuing from it sends the whole history
+func (b *Backlog) FirstOffset() int64 {
+	return b.offset - int64(b.histlen) + 1
+}
+
+// Size returns the capacity of the backlog
+func (b *Backlog) Size() int {
+	return len(b.buf)
+}
+
+// Histlen returns the number of bytes held
+func (b *Backlog) Histlen() int {
+	return b.histlen
+}
+
+// ReadFrom returns a copy of the stream from psyncOffset, as sent in PSYNC,
+// to the end. It returns false if those bytes are not all held anymore.
+func (b *Backlog) ReadFrom(psyncOffset int64) ([]byte, bool) {
+	if psyncOffset < b.FirstOffset() || psyncOffset > b.offset+1 {
+		return nil, false
+	}
+	n := int(b.offset + 1 - psyncOffset)
+	out := make([]byte, n)
+	start := (b.idx - n + len(b.buf)) % len(b.buf)
+	if start+n <= len(b.buf) {
+		copy(out, b.buf[start:start+n])
+	} else {
+		k := copy(out, b.buf[start:])
+		copy(out[k:], b.buf[:n-k])
+	}
+	return out, true
+}
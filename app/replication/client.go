@@ -1,10 +1,16 @@
 package replication
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/codecrafters-io/redis-starter-go/app/config"
 	"github.com/codecrafters-io/redis-starter-go/app/parser"
 )
 
@@ -14,444 +20,429 @@ type CommandProcessor func(respData string) error
 // ConnectionSetter defines a function for setting the connection on command processor
 type ConnectionSetter func(conn net.Conn)
 
-// ReplicaClient handles the connection from replica to master
+// SnapshotLoader defines a function replacing the dataset with the RDB
+// payload received from master on a full resync, read from the link, along
+// with the replication ID and offset the dataset corresponds to
+type SnapshotLoader func(replId string, offset int, payload io.Reader) error
+
+// ContinueHandler defines a function called when master accepts a partial
+// resync, with the replication ID it sent (empty if none)
+type ContinueHandler func(replId string)
+
+// LinkState is the state of the link of a replica with its master
+type LinkState int
+
+const (
+	// LinkConnecting is the state while connecting, or waiting to retry
+	LinkConnecting LinkState = iota
+	// LinkHandshake is the state while PING, REPLCONF and PSYNC are
+	// exchanged
+	LinkHandshake
+	// LinkTransfer is the state while the RDB file of a full resync is
+	// received
+	LinkTransfer
+	// LinkConnected is the state while the replication stream is applied
+	LinkConnected
+)
+
+// String returns the name of the state
+func (s LinkState) String() string {
+	switch s {
+	case LinkConnecting:
+		return "connecting"
+	case LinkHandshake:
+		return "handshake"
+	case LinkTransfer:
+		return "transfer"
+	case LinkConnected:
+		return "connected"
+	}
+	return "unknown"
+}
+
+// Delays between attempts to reconnect to master, doubling after each
+// failed attempt
+const (
+	minReconnectDelay = 500 * time.Millisecond
+	maxReconnectDelay = 30 * time.Second
+)
+
+// MasterLink is the state of the link of a replica with its master, as
+// reported by INFO replication
+type MasterLink struct {
+	mu     sync.Mutex
+	state  LinkState
+	lastIO time.Time // Last data received from master
+}
+
+// Link is the state of the link of this server with its master
+var Link = &MasterLink{}
+
+// Status returns whether the link is up, whether a synchronization is in
+// progress and the number of seconds since data was last received (-1
+// when nothing was received yet)
+func (l *MasterLink) Status() (up, syncing bool, lastIOSecondsAgo int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	lastIOSecondsAgo = -1
+	if !l.lastIO.IsZero() {
+		lastIOSecondsAgo = int(time.Since(l.lastIO) / time.Second)
+	}
+	return l.state == LinkConnected, l.state == LinkTransfer, lastIOSecondsAgo
+}
+
+// setState moves the link to state
+func (l *MasterLink) setState(state LinkState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.state != state {
+		fmt.Printf("Replication link: %s -> %s\n", l.state, state)
+		l.state = state
+	}
+}
+
+// touch records that data was received from master
+func (l *MasterLink) touch() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastIO = time.Now()
+}
+
+// ReplicaClient handles the connection from replica to master. Run goes
+// through the states of the link: it connects, performs the handshake,
+// receives the RDB file on a full resync, then applies the replication
+// stream until the link breaks, and starts over until Stop is called.
 type ReplicaClient struct {
 	masterHost       string
 	masterPort       string
 	replicaPort      string
+	mu               sync.Mutex // Guards conn, which Stop closes
 	conn             net.Conn
+	reader           *parser.Reader
 	commandProcessor CommandProcessor
 	connectionSetter ConnectionSetter // New field to set connection on command processor
-	buffer           []byte           // Buffer for accumulating partial data
-	rdbReceived      bool             // Flag to track if RDB file has been fully received
+	snapshotLoader   SnapshotLoader
+	continueHandler  ContinueHandler
+
+	// Deadline for each read from master. Master PINGs its replicas every
+	// repl-ping-replica-period seconds, so a link silent for longer than
+	// repl-timeout while streaming is dropped and reconnected.
+	timeout time.Duration
+
+	// Replication ID and offset announced by +FULLRESYNC
+	syncReplId string
+	syncOffset int
+
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // NewReplicaClient creates a new replica client
-func NewReplicaClient(masterHost, masterPort, replicaPort string, processor CommandProcessor, setter ConnectionSetter) *ReplicaClient {
+func NewReplicaClient(masterHost, masterPort, replicaPort string, processor CommandProcessor, setter ConnectionSetter, loader SnapshotLoader, onContinue ContinueHandler) *ReplicaClient {
 	return &ReplicaClient{
 		masterHost:       masterHost,
 		masterPort:       masterPort,
 		replicaPort:      replicaPort,
 		commandProcessor: processor,
 		connectionSetter: setter,
-		buffer:           make([]byte, 0, 4096),
+		snapshotLoader:   loader,
+		continueHandler:  onContinue,
+		stop:             make(chan struct{}),
 	}
 }
 
-// Connect establishes connection to the master server
-func (r *ReplicaClient) Connect() error {
-	address := r.masterHost + ":" + r.masterPort
-	fmt.Printf("Connecting to master at %s\n", address)
-
-	var err error
-	r.conn, err = net.Dial("tcp", address)
-	if err != nil {
-		return fmt.Errorf("failed to connect to master: %v", err)
+// Run keeps the replica synchronized with master, reconnecting with an
+// exponential backoff whenever the link fails. Once the replica holds a
+// dataset of master, reconnections ask for a partial resync. It returns
+// once Stop is called.
+func (r *ReplicaClient) Run() {
+	delay := minReconnectDelay
+	for {
+		synced, err := r.session()
+		if r.stopped() {
+			fmt.Printf("Replication link with master %s:%s closed\n", r.masterHost, r.masterPort)
+			return
+		}
+		Link.setState(LinkConnecting)
+		if synced {
+			delay = minReconnectDelay
+		}
+		fmt.Printf("Replication link with master %s:%s failed: %v (retrying in %v)\n", r.masterHost, r.masterPort, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-r.stop:
+		}
+		delay = min(2*delay, maxReconnectDelay)
 	}
+}
 
-	fmt.Printf("Connected to master at %s\n", address)
+// Stop closes the link with master for good
+func (r *ReplicaClient) Stop() {
+	r.stopOnce.Do(func() { close(r.stop) })
 
-	// Set the connection on the command processor so it can send ACK responses
-	if r.connectionSetter != nil {
-		r.connectionSetter(r.conn)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil {
+		r.conn.Close()
 	}
-
-	return nil
 }
 
-// StartHandshake initiates the replication handshake with the master
-func (r *ReplicaClient) StartHandshake() error {
-	if r.conn == nil {
-		return fmt.Errorf("not connected to master")
-	}
-
-	// Step 1: Send PING command as RESP array
-	if err := r.sendPing(); err != nil {
-		return fmt.Errorf("failed to send PING: %v", err)
+// stopped reports whether Stop was called
+func (r *ReplicaClient) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
 	}
+}
 
-	// Wait for PONG response
-	time.Sleep(50 * time.Millisecond)
+// session connects to master and goes through the handshake, the transfer
+// and the streaming states until an error occurs. It returns whether the
+// streaming state was reached.
+func (r *ReplicaClient) session() (bool, error) {
+	Link.setState(LinkConnecting)
+	r.timeout = time.Duration(config.Server.ReplTimeout) * time.Second
 
-	// Step 2: Send REPLCONF listening-port
-	if err := r.sendReplconfListeningPort(); err != nil {
-		return fmt.Errorf("failed to send REPLCONF listening-port: %v", err)
+	address := net.JoinHostPort(r.masterHost, r.masterPort)
+	fmt.Printf("Connecting to master at %s\n", address)
+	conn, err := net.DialTimeout("tcp", address, r.timeout)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to master: %v", err)
 	}
+	defer conn.Close()
+	fmt.Printf("Connected to master at %s\n", address)
 
-	// Wait for OK response
-	time.Sleep(50 * time.Millisecond)
-
-	// Step 3: Send REPLCONF capa eof capa psync2
-	if err := r.sendReplconfCapa(); err != nil {
-		return fmt.Errorf("failed to send REPLCONF capa: %v", err)
+	r.mu.Lock()
+	r.conn = conn
+	r.mu.Unlock()
+	if r.stopped() {
+		return false, fmt.Errorf("replication stopped")
 	}
+	r.reader = parser.NewReader(linkReader{r})
 
-	// Wait for OK response
-	time.Sleep(50 * time.Millisecond)
-
-	// Step 4: Send PSYNC ? -1
-	if err := r.sendPsync(); err != nil {
-		return fmt.Errorf("failed to send PSYNC: %v", err)
+	// Set the connection on the command processor so it can send ACK responses
+	if r.connectionSetter != nil {
+		r.connectionSetter(conn)
 	}
 
-	// Wait for FULLRESYNC response
-	time.Sleep(50 * time.Millisecond)
-
-	fmt.Println("Replication handshake completed")
-	return nil
-}
-
-// sendPing sends a PING command to the master
-func (r *ReplicaClient) sendPing() error {
-	// Create PING command as RESP array: *1\r\n$4\r\nPING\r\n
-	pingCommand := parser.RESPValue{
-		Type: "array",
-		Array: []parser.RESPValue{
-			{Type: "bulk", Str: "PING"},
-		},
+	Link.setState(LinkHandshake)
+	if err := r.handshake(); err != nil {
+		return false, err
 	}
-
-	// Encode to RESP format
-	respData := parser.EncodeRESP(pingCommand)
-
-	// Send to master
-	_, err := r.conn.Write([]byte(respData))
+	full, err := r.psync()
 	if err != nil {
-		return fmt.Errorf("failed to write PING command: %v", err)
+		return false, err
 	}
-
-	fmt.Printf("Sent PING to master: %s", respData)
-
-	// Start background response reader if not already started
-	go r.readResponse()
-
-	return nil
-}
-
-// sendReplconfListeningPort sends REPLCONF listening-port command to the master
-func (r *ReplicaClient) sendReplconfListeningPort() error {
-	// Create REPLCONF listening-port command as RESP array
-	// *3\r\n$8\r\nREPLCONF\r\n$14\r\nlistening-port\r\n$<port_len>\r\n<port>\r\n
-	replconfCommand := parser.RESPValue{
-		Type: "array",
-		Array: []parser.RESPValue{
-			{Type: "bulk", Str: "REPLCONF"},
-			{Type: "bulk", Str: "listening-port"},
-			{Type: "bulk", Str: r.replicaPort},
-		},
+	if full {
+		Link.setState(LinkTransfer)
+		if err := r.receiveSnapshot(); err != nil {
+			return false, err
+		}
 	}
 
-	// Encode to RESP format
-	respData := parser.EncodeRESP(replconfCommand)
-
-	// Send to master
-	_, err := r.conn.Write([]byte(respData))
-	if err != nil {
-		return fmt.Errorf("failed to write REPLCONF listening-port command: %v", err)
-	}
+	Link.setState(LinkConnected)
+	conn.SetDeadline(time.Time{}) // Acknowledgments are written from now on too
+	return true, r.stream()
+}
 
-	fmt.Printf("Sent REPLCONF listening-port to master: %s", respData)
-	return nil
+// linkReader reads from the connection to master, renewing the read
+// deadline before each read, and records the activity of the link
+type linkReader struct {
+	client *ReplicaClient
 }
 
-// sendReplconfCapa sends REPLCONF capa eof capa psync2 command to the master
-func (r *ReplicaClient) sendReplconfCapa() error {
-	// Create REPLCONF capa eof capa psync2 command as RESP array
-	// *5\r\n$8\r\nREPLCONF\r\n$4\r\ncapa\r\n$3\r\neof\r\n$4\r\ncapa\r\n$6\r\npsync2\r\n
-	replconfCommand := parser.RESPValue{
-		Type: "array",
-		Array: []parser.RESPValue{
-			{Type: "bulk", Str: "REPLCONF"},
-			{Type: "bulk", Str: "capa"},
-			{Type: "bulk", Str: "eof"},
-			{Type: "bulk", Str: "capa"},
-			{Type: "bulk", Str: "psync2"},
-		},
+// Read reads from master
+func (lr linkReader) Read(p []byte) (int, error) {
+	r := lr.client
+	r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+	n, err := r.conn.Read(p)
+	if n > 0 {
+		Link.touch()
 	}
+	return n, err
+}
 
-	// Encode to RESP format
-	respData := parser.EncodeRESP(replconfCommand)
-
-	// Send to master
-	_, err := r.conn.Write([]byte(respData))
+// handshake sends PING, then the REPLCONF options. Master must answer
+// PING, while errors to REPLCONF are only logged as Redis does: they only
+// mean master ignores the option.
+func (r *ReplicaClient) handshake() error {
+	reply, err := r.command("PING")
 	if err != nil {
-		return fmt.Errorf("failed to write REPLCONF capa command: %v", err)
+		return err
 	}
-
-	fmt.Printf("Sent REPLCONF capa eof capa psync2 to master: %s", respData)
-	return nil
-}
-
-// sendPsync sends PSYNC ? -1 command to the master
-func (r *ReplicaClient) sendPsync() error {
-	// Create PSYNC ? -1 command as RESP array
-	// *3\r\n$5\r\nPSYNC\r\n$1\r\n?\r\n$2\r\n-1\r\n
-	psyncCommand := parser.RESPValue{
-		Type: "array",
-		Array: []parser.RESPValue{
-			{Type: "bulk", Str: "PSYNC"},
-			{Type: "bulk", Str: "?"},
-			{Type: "bulk", Str: "-1"},
-		},
+	if reply != "+PONG" {
+		return fmt.Errorf("unexpected reply to PING: %s", reply)
 	}
 
-	// Encode to RESP format
-	respData := parser.EncodeRESP(psyncCommand)
-
-	// Send to master
-	_, err := r.conn.Write([]byte(respData))
-	if err != nil {
-		return fmt.Errorf("failed to write PSYNC command: %v", err)
+	options := [][]string{
+		{"REPLCONF", "listening-port", r.replicaPort},
+		{"REPLCONF", "capa", "eof", "capa", "psync2"},
 	}
-
-	fmt.Printf("Sent PSYNC ? -1 to master: %s", respData)
-	return nil
-}
-
-// readResponse reads responses from master (runs in background)
-func (r *ReplicaClient) readResponse() {
-	readBuffer := make([]byte, 1024)
-	for {
-		n, err := r.conn.Read(readBuffer)
+	for _, option := range options {
+		reply, err := r.command(option...)
 		if err != nil {
-			fmt.Printf("Master connection closed: %v\n", err)
-			return
+			return err
 		}
-
-		if n > 0 {
-			// Accumulate data in buffer
-			r.buffer = append(r.buffer, readBuffer[:n]...)
-
-			// Process complete commands from the buffer
-			r.processBufferedCommands()
+		if reply != "+OK" {
+			fmt.Printf("(Non critical) Master does not understand %s: %s\n", strings.Join(option[:2], " "), reply)
 		}
 	}
+	return nil
 }
 
-// processBufferedCommands processes complete RESP commands from the buffer
-func (r *ReplicaClient) processBufferedCommands() {
-	data := string(r.buffer)
-
-	// First, process any handshake responses (+PONG, +OK, +FULLRESYNC)
-	for len(data) > 0 && (data[0] == '+' || data[0] == '-' || data[0] == ':') {
-		complete, consumed := r.isCompleteRESPMessage(data)
-		if !complete {
-			break
-		}
-
-		message := data[:consumed]
-		fmt.Printf("Received from master: %s", message)
-
-		data = data[consumed:]
-		r.buffer = r.buffer[consumed:]
+// psync sends PSYNC to the master. A replica holding a dataset of the
+// master asks to continue from its offset, otherwise it asks for a full
+// resync with PSYNC ? -1. Returns true if master starts a full resync.
+func (r *ReplicaClient) psync() (bool, error) {
+	replId, offset := "?", "-1"
+	if config.Server.MasterCached {
+		replId = config.Server.MasterReplId
+		offset = strconv.Itoa(config.Server.MasterReplOffset + 1)
 	}
 
-	// Handle RDB file if not yet received
-	if !r.rdbReceived && len(data) > 0 {
-		consumed := r.handleRDBFile(data)
-		if consumed > 0 {
-			data = data[consumed:]
-			r.buffer = r.buffer[consumed:]
-			r.rdbReceived = true
-		} else if data[0] == '$' {
-			// We see the start of RDB but don't have it all yet
-			return
-		}
-		// If it doesn't start with $, we might not have the RDB start yet
+	reply, err := r.command("PSYNC", replId, offset)
+	if err != nil {
+		return false, err
 	}
 
-	// Process commands after RDB file
-	for len(data) > 0 {
-		// Check if we have a complete RESP message
-		complete, consumed := r.isCompleteRESPMessage(data)
-		if !complete {
-			// Not enough data for a complete message, wait for more
-			break
+	// +FULLRESYNC <replid> <offset> announces an RDB file, +CONTINUE
+	// [<replid>] a partial resync
+	fields := strings.Fields(reply)
+	switch {
+	case len(fields) == 3 && fields[0] == "+FULLRESYNC":
+		syncOffset, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return false, fmt.Errorf("bad reply to PSYNC: %s", reply)
 		}
-
-		// Extract the complete message
-		message := data[:consumed]
-
-		// Check if this looks like a command (starts with *) vs a response (+, -, :, $)
-		if len(message) > 0 && message[0] == '*' {
-			// This is a command array, process it
-			if r.commandProcessor != nil {
-				err := r.commandProcessor(message)
-				if err != nil {
-					fmt.Printf("Error processing replica command: %v\n", err)
-				}
-			}
-		} else {
-			// This is a response (PONG, OK, FULLRESYNC, etc.), just log it
-			fmt.Printf("Received from master: %s", message)
+		r.syncReplId, r.syncOffset = fields[1], syncOffset
+		fmt.Printf("Full resync from master: %s:%d\n", r.syncReplId, r.syncOffset)
+		return true, nil
+	case len(fields) >= 1 && len(fields) <= 2 && fields[0] == "+CONTINUE":
+		newReplId := ""
+		if len(fields) == 2 {
+			newReplId = fields[1]
 		}
-
-		// Remove processed data from buffer
-		data = data[consumed:]
-		r.buffer = r.buffer[consumed:]
-	}
-}
-
-func min(a, b int) int {
-	if a < b {
-		return a
+		fmt.Printf("Partial resync with master accepted\n")
+		if r.continueHandler != nil {
+			r.continueHandler(newReplId)
+		}
+		return false, nil
 	}
-	return b
+	return false, fmt.Errorf("unexpected reply to PSYNC: %s", reply)
 }
 
-// handleRDBFile handles the RDB file reception and returns how many bytes were consumed
-func (r *ReplicaClient) handleRDBFile(data string) int {
-	// Look for the RDB file marker (starts with $)
-	if len(data) == 0 || data[0] != '$' {
-		return 0
-	}
-
-	// Find the end of the length specification
-	firstCRLF := findCRLF(data)
-	if firstCRLF == -1 {
-		return 0 // Not enough data yet
+// command sends a command to master and returns its reply, a simple
+// string or an error, without the trailing CRLF
+func (r *ReplicaClient) command(args ...string) (string, error) {
+	respData := parser.ToBulkStringArray(args)
+	r.conn.SetWriteDeadline(time.Now().Add(r.timeout))
+	if _, err := r.conn.Write([]byte(respData)); err != nil {
+		return "", fmt.Errorf("failed to write %s command: %v", args[0], err)
 	}
+	fmt.Printf("Sent %s to master: %s", strings.Join(args, " "), respData)
 
-	// Parse the RDB file length
-	lengthStr := data[1:firstCRLF]
-	length := 0
-	for _, char := range lengthStr {
-		if char >= '0' && char <= '9' {
-			length = length*10 + int(char-'0')
-		} else {
-			// Invalid length
-			return 0
-		}
+	reply, err := r.reader.ReadMessage()
+	if err != nil {
+		return "", fmt.Errorf("no reply to %s: %v", args[0], err)
 	}
-
-	// Check if we have the complete RDB file
-	headerLen := firstCRLF + 2
-	totalNeeded := headerLen + length
-	if len(data) < totalNeeded {
-		return 0 // Not enough data yet
+	fmt.Printf("Received from master: %s", reply)
+	if reply[0] != '+' && reply[0] != '-' {
+		return "", fmt.Errorf("unexpected reply to %s: %q", args[0], reply)
 	}
-
-	// We have the complete RDB file, consume it
-	return totalNeeded
+	return strings.TrimSuffix(reply, "\r\n"), nil
 }
 
-// isCompleteRESPMessage checks if we have a complete RESP message and returns how many bytes it consumes
-func (r *ReplicaClient) isCompleteRESPMessage(data string) (complete bool, consumed int) {
-	if len(data) == 0 {
-		return false, 0
+// receiveSnapshot reads the RDB file of a full resync, sent as
+// $<length>\r\n<contents> without the trailing CRLF of a bulk string, or
+// as $EOF:<mark>\r\n<contents><mark> by a diskless master, and replaces
+// the dataset with it before the commands that follow are applied. A
+// diskless master waits for an acknowledgment before streaming them.
+func (r *ReplicaClient) receiveSnapshot() error {
+	line, err := r.reader.ReadLine()
+	if err != nil {
+		return fmt.Errorf("no RDB file from master: %v", err)
 	}
 
-	switch data[0] {
-	case '+', '-', ':': // Simple string, error, integer
-		end := findCRLF(data)
-		if end == -1 {
-			return false, 0
+	var payload io.Reader
+	var limited *io.LimitedReader
+	mark, diskless := strings.CutPrefix(line, "$EOF:")
+	if diskless {
+		if len(mark) != eofMarkLen {
+			return fmt.Errorf("bad RDB file header from master: %q", line)
 		}
-		return true, end + 2
-
-	case '$': // Bulk string (but we should have handled RDB already)
-		return r.isCompleteBulkString(data)
-
-	case '*': // Array
-		return r.isCompleteArray(data)
-
-	default:
-		// Unknown type, skip this byte and continue
-		return true, 1
+		payload = newEOFReader(r.reader, mark)
+	} else {
+		length, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+		if !strings.HasPrefix(line, "$") || err != nil || length < 0 {
+			return fmt.Errorf("bad RDB file header from master: %q", line)
+		}
+		limited = &io.LimitedReader{R: r.reader, N: int64(length)}
+		payload = limited
 	}
-}
 
-// findCRLF finds the first occurrence of \r\n in data
-func findCRLF(data string) int {
-	for i := 0; i < len(data)-1; i++ {
-		if data[i] == '\r' && data[i+1] == '\n' {
-			return i
+	// The payload is consumed to the end whatever the loader read of it
+	counter := &countingReader{r: payload}
+	if r.snapshotLoader != nil {
+		if err := r.snapshotLoader(r.syncReplId, r.syncOffset, counter); err != nil {
+			return fmt.Errorf("failed to load RDB from master: %v", err)
 		}
 	}
-	return -1
-}
-
-// isCompleteBulkString checks if we have a complete bulk string
-func (r *ReplicaClient) isCompleteBulkString(data string) (bool, int) {
-	firstCRLF := findCRLF(data)
-	if firstCRLF == -1 {
-		return false, 0
+	_, err = io.Copy(io.Discard, counter)
+	if err == nil && limited != nil && limited.N > 0 {
+		err = io.ErrUnexpectedEOF
 	}
-
-	// Parse length
-	lengthStr := data[1:firstCRLF]
-	length := 0
-	for _, char := range lengthStr {
-		if char >= '0' && char <= '9' {
-			length = length*10 + int(char-'0')
-		} else if char == '-' && length == 0 {
-			// Null bulk string
-			return true, firstCRLF + 2
-		} else {
-			return false, 0
-		}
+	if err != nil {
+		return fmt.Errorf("transfer of the RDB file interrupted: %v", err)
 	}
+	fmt.Printf("Loaded RDB from master (%d bytes)\n", counter.n)
 
-	// Check if we have enough data for the content + final CRLF
-	needed := firstCRLF + 2 + length + 2
-	if len(data) < needed {
-		return false, 0
+	if diskless {
+		respData := parser.ToBulkStringArray([]string{"REPLCONF", "ACK", strconv.Itoa(r.syncOffset)})
+		r.conn.SetWriteDeadline(time.Now().Add(r.timeout))
+		if _, err := r.conn.Write([]byte(respData)); err != nil {
+			return fmt.Errorf("failed to acknowledge the RDB file: %v", err)
+		}
 	}
+	return nil
+}
 
-	return true, needed
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
 }
 
-// isCompleteArray checks if we have a complete array
-func (r *ReplicaClient) isCompleteArray(data string) (bool, int) {
-	firstCRLF := findCRLF(data)
-	if firstCRLF == -1 {
-		return false, 0
-	}
+// Read reads from the underlying reader
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
 
-	// Parse array count
-	countStr := data[1:firstCRLF]
-	count := 0
-	for _, char := range countStr {
-		if char >= '0' && char <= '9' {
-			count = count*10 + int(char-'0')
-		} else if char == '-' && count == 0 {
-			// Null array
-			return true, firstCRLF + 2
-		} else {
-			return false, 0
+// stream applies the commands master propagates until the link breaks
+func (r *ReplicaClient) stream() error {
+	for {
+		message, err := r.reader.ReadMessage()
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return fmt.Errorf("master timeout: no data nor PING received for %v", r.timeout)
 		}
-	}
-
-	// Process each element in the array
-	consumed := firstCRLF + 2
-	remaining := data[consumed:]
-
-	for i := 0; i < count; i++ {
-		if len(remaining) == 0 {
-			return false, 0
+		if err != nil {
+			return fmt.Errorf("master connection closed: %v", err)
 		}
 
-		// For now, only handle bulk string elements (most common in commands)
-		if remaining[0] == '$' {
-			complete, elementConsumed := r.isCompleteBulkString(remaining)
-			if !complete {
-				return false, 0
+		// Check if this looks like a command (starts with *) vs a response (+, -, :, $)
+		if message[0] == '*' {
+			if r.commandProcessor != nil {
+				if err := r.commandProcessor(message); err != nil {
+					fmt.Printf("Error processing replica command: %v\n", err)
+				}
 			}
-			consumed += elementConsumed
-			remaining = remaining[elementConsumed:]
 		} else {
-			// Handle other types if needed
-			return false, 0
+			fmt.Printf("Received from master: %s", message)
 		}
 	}
-
-	return true, consumed
-}
-
-// Close closes the connection to master
-func (r *ReplicaClient) Close() error {
-	if r.conn != nil {
-		return r.conn.Close()
-	}
-	return nil
 }
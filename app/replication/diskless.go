@@ -0,0 +1,137 @@
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/config"
+)
+
+// A diskless transfer streams the RDB file while it is generated, so its
+// length is unknown when it starts. It is sent as $EOF:<mark>\r\n<contents>
+// <mark> instead of $<length>\r\n<contents>, mark being 40 random
+// characters, to replicas announcing REPLCONF capa eof.
+const eofMarkLen = 40
+
+// errNoReplicas is returned when every replica of a transfer failed
+var errNoReplicas = errors.New("no replica left to transfer to")
+
+// SendDisklessSnapshot streams the RDB file written by writeRDB to
+// replicas, all at once. A replica failing is disconnected while the
+// others go on. As Redis does, the replicas only get the commands buffered
+// since the snapshot once they acknowledge having loaded it: nothing
+// follows the mark until then, so they can read ahead while looking for it.
+func SendDisklessSnapshot(replicas []*ReplicaConnection, writeRDB func(w io.Writer) error) error {
+	t := &transfer{replicas: replicas}
+	defer t.finish()
+
+	mark := config.NewReplId() // 40 random hexadecimal characters
+	out := bufio.NewWriterSize(t, 64*1024)
+	out.WriteString("$EOF:" + mark + "\r\n")
+	if err := writeRDB(out); err != nil {
+		t.abort()
+		return err
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	// Before the mark is sent: a replica may acknowledge right after
+	for _, replica := range t.replicas {
+		replica.waitAck()
+	}
+	_, err := t.Write([]byte(mark))
+	return err
+}
+
+// transfer writes the data of a diskless transfer to its replicas
+type transfer struct {
+	replicas []*ReplicaConnection
+}
+
+// Write writes p to every replica, dropping those failing to receive it
+// within the replication timeout. It only fails once none is left.
+func (t *transfer) Write(p []byte) (int, error) {
+	timeout := time.Duration(config.Server.ReplTimeout) * time.Second
+	alive := t.replicas[:0]
+	for _, replica := range t.replicas {
+		replica.Conn.SetWriteDeadline(time.Now().Add(timeout))
+		if _, err := replica.Conn.Write(p); err != nil {
+			fmt.Printf("Failed to transfer RDB to replica %s: %v\n", replica.ID, err)
+			replica.mu.Lock()
+			replica.close()
+			replica.mu.Unlock()
+			continue
+		}
+		alive = append(alive, replica)
+	}
+	t.replicas = alive
+	if len(alive) == 0 {
+		return 0, errNoReplicas
+	}
+	return len(p), nil
+}
+
+// abort disconnects the replicas, which cannot tell a truncated transfer
+func (t *transfer) abort() {
+	for _, replica := range t.replicas {
+		replica.mu.Lock()
+		replica.close()
+		replica.mu.Unlock()
+	}
+	t.replicas = nil
+}
+
+// finish removes the write deadline of the replicas left
+func (t *transfer) finish() {
+	for _, replica := range t.replicas {
+		replica.Conn.SetWriteDeadline(time.Time{})
+	}
+}
+
+// eofReader reads the contents of an RDB file sent as $EOF:<mark>\r\n
+// <contents><mark>, returning io.EOF at the mark. The last eofMarkLen bytes
+// read are held back until more data shows they are not the mark.
+type eofReader struct {
+	r    io.Reader
+	mark []byte
+	buf  []byte // Data read and not returned yet, ending with what may be the mark
+	tmp  []byte
+	done bool
+}
+
+// newEOFReader creates a reader of the contents following $EOF:<mark>\r\n
+func newEOFReader(r io.Reader, mark string) *eofReader {
+	return &eofReader{r: r, mark: []byte(mark), tmp: make([]byte, 16*1024)}
+}
+
+// Read reads the contents up to the mark
+func (er *eofReader) Read(p []byte) (int, error) {
+	for !er.done && len(er.buf) <= len(er.mark) {
+		n, err := er.r.Read(er.tmp)
+		er.buf = append(er.buf, er.tmp[:n]...)
+		if bytes.HasSuffix(er.buf, er.mark) {
+			er.buf = er.buf[:len(er.buf)-len(er.mark)]
+			er.done = true
+		} else if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return 0, err
+		}
+	}
+
+	available := len(er.buf)
+	if !er.done {
+		available -= len(er.mark)
+	}
+	if available == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, er.buf[:available])
+	er.buf = er.buf[n:]
+	return n, nil
+}
@@ -1,10 +1,14 @@
 package replication
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/codecrafters-io/redis-starter-go/app/config"
 	"github.com/codecrafters-io/redis-starter-go/app/parser"
 )
 
@@ -12,12 +16,190 @@ import (
 type ReplicaConnection struct {
 	Conn net.Conn
 	ID   string // Could be the remote address
+
+	// Port announced with REPLCONF listening-port, 0 if none
+	ListeningPort int
+
+	// Order of registration, for listing replicas like Redis does
+	seq uint64
+
+	// Output buffer: the data propagated to the replica and not written
+	// yet. A writer goroutine sends it so a slow replica never stalls the
+	// server; while the replica receives its snapshot the buffer only grows.
+	// A replica waiting for a diskless transfer to start gets nothing, its
+	// snapshot is yet to be taken.
+	mu          sync.Mutex
+	wake        *sync.Cond // Signalled when data is queued or the replica is closed
+	waiting     bool       // Waiting for a diskless transfer to start
+	syncing     bool
+	onlineOnAck bool // Diskless transfer done, streaming starts on the first ACK
+	pending     [][]byte
+	buffered    int64     // Bytes queued or being written
+	softLimitAt time.Time // When buffered went over the soft limit, zero if under it
+	closed      bool
+
+	// Last offset acknowledged with REPLCONF ACK and when
+	ackOffset int64
+	ackTime   time.Time
+}
+
+// errReplicaClosed is returned when writing to a disconnected replica
+var errReplicaClosed = errors.New("replica disconnected")
+
+// write queues propagated data for the replica. It fails if the replica is
+// disconnected, which happens when its output buffer goes over the limit.
+func (rc *ReplicaConnection) write(data []byte) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.closed {
+		return errReplicaClosed
+	}
+	if rc.waiting {
+		return nil
+	}
+	rc.pending = append(rc.pending, data)
+	rc.buffered += int64(len(data))
+	if err := rc.checkLimit(config.Server.ReplicaOutputBufferLimit); err != nil {
+		rc.close()
+		return err
+	}
+	rc.wake.Signal()
+	return nil
+}
+
+// checkLimit returns an error if the output buffer is over the hard limit,
+// or has been over the soft limit for too long. The caller must hold rc.mu.
+func (rc *ReplicaConnection) checkLimit(limit config.OutputBufferLimit) error {
+	if limit.Hard > 0 && rc.buffered > limit.Hard {
+		return fmt.Errorf("output buffer of %d bytes over the hard limit of %d bytes", rc.buffered, limit.Hard)
+	}
+	if limit.Soft <= 0 || rc.buffered <= limit.Soft {
+		rc.softLimitAt = time.Time{}
+		return nil
+	}
+	if rc.softLimitAt.IsZero() {
+		rc.softLimitAt = time.Now()
+	}
+	if elapsed := time.Since(rc.softLimitAt); elapsed > time.Duration(limit.SoftSeconds)*time.Second {
+		return fmt.Errorf("output buffer of %d bytes over the soft limit of %d bytes for %v",
+			rc.buffered, limit.Soft, elapsed.Truncate(time.Second))
+	}
+	return nil
+}
+
+// close disconnects the replica and stops its writer. The caller must
+// hold rc.mu.
+func (rc *ReplicaConnection) close() {
+	if rc.closed {
+		return
+	}
+	rc.closed = true
+	rc.Conn.Close()
+	rc.wake.Broadcast()
+}
+
+// writeLoop sends the output buffer to the replica until it is closed. A
+// failed write closes the connection, so the replica is removed once its
+// connection handler notices.
+func (rc *ReplicaConnection) writeLoop() {
+	for {
+		rc.mu.Lock()
+		for len(rc.pending) == 0 && !rc.closed {
+			rc.wake.Wait()
+		}
+		if rc.closed {
+			rc.mu.Unlock()
+			return
+		}
+		pending := net.Buffers(rc.pending)
+		rc.pending = nil
+		rc.mu.Unlock()
+
+		n, err := pending.WriteTo(rc.Conn)
+
+		rc.mu.Lock()
+		rc.buffered -= n
+		if err != nil && !rc.closed {
+			fmt.Printf("Failed to write to replica %s: %v\n", rc.ID, err)
+			rc.close()
+		}
+		rc.mu.Unlock()
+	}
+}
+
+// StartSync starts the full resync of a replica that waited for a
+// diskless transfer, sending reply, its +FULLRESYNC. Commands propagated
+// from now on are buffered, so the caller must take the snapshot before
+// any other write runs.
+func (rc *ReplicaConnection) StartSync(reply string) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.closed {
+		return errReplicaClosed
+	}
+	if _, err := rc.Conn.Write([]byte(reply)); err != nil {
+		rc.close()
+		return err
+	}
+	rc.waiting = false
+	return nil
 }
 
-// ReplicaManager manages all replica connections
+// waitAck makes the replica start streaming once it acknowledges the
+// diskless transfer it is receiving
+func (rc *ReplicaConnection) waitAck() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.onlineOnAck = true
+}
+
+// FinishSync sends the RDB payload of a full resynchronization, then
+// switches the replica to streaming mode
+func (rc *ReplicaConnection) FinishSync(payload []byte) error {
+	// Sent as $<length>\r\n<contents>, without the trailing CRLF of a bulk string
+	if _, err := rc.Conn.Write([]byte(fmt.Sprintf("$%d\r\n", len(payload)))); err != nil {
+		return err
+	}
+	if _, err := rc.Conn.Write(payload); err != nil {
+		return err
+	}
+	return rc.StartStreaming()
+}
+
+// StartStreaming switches the replica to streaming mode: its writer starts
+// sending the data buffered so far, then whatever is propagated next
+func (rc *ReplicaConnection) StartStreaming() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.closed {
+		return errReplicaClosed
+	}
+	rc.syncing = false
+	go rc.writeLoop()
+	return nil
+}
+
+// ReplicaManager manages all replica connections and the replication
+// backlog. The stream offset is config.Server.MasterReplOffset, only
+// changed under the execution lock.
 type ReplicaManager struct {
 	replicas map[string]*ReplicaConnection
 	mu       sync.RWMutex
+
+	backlog      *Backlog  // nil until the first replica connects
+	noReplicasAt time.Time // When the last replica disconnected
+
+	// Listening ports and support of diskless transfers announced by
+	// connections before they sent PSYNC
+	ports   map[string]int
+	capaEOF map[string]bool
+	nextSeq uint64
+
+	// Channels of clients blocked in WAIT, signalled on every ACK
+	ackWaiters map[chan struct{}]struct{}
 }
 
 // Global replica manager instance
@@ -26,21 +208,101 @@ var Manager = NewReplicaManager()
 // NewReplicaManager creates a new replica manager
 func NewReplicaManager() *ReplicaManager {
 	return &ReplicaManager{
-		replicas: make(map[string]*ReplicaConnection),
+		replicas:   make(map[string]*ReplicaConnection),
+		ports:      make(map[string]int),
+		capaEOF:    make(map[string]bool),
+		ackWaiters: make(map[chan struct{}]struct{}),
 	}
 }
 
+// newReplica creates the connection of a replica, with the listening port
+// it announced. The caller must hold rm.mu.
+func (rm *ReplicaManager) newReplica(conn net.Conn, syncing bool) *ReplicaConnection {
+	id := conn.RemoteAddr().String()
+	rm.nextSeq++
+	replica := &ReplicaConnection{
+		Conn:          conn,
+		ID:            id,
+		ListeningPort: rm.ports[id],
+		seq:           rm.nextSeq,
+		syncing:       syncing,
+		ackTime:       time.Now(),
+	}
+	replica.wake = sync.NewCond(&replica.mu)
+	if !syncing {
+		go replica.writeLoop()
+	}
+	return replica
+}
+
 // AddReplica adds a new replica connection
 func (rm *ReplicaManager) AddReplica(conn net.Conn) {
 	rm.mu.Lock()
 	defer rm.mu.Unlock()
 
+	replica := rm.newReplica(conn, false)
+	rm.replicas[replica.ID] = replica
+	fmt.Printf("Added replica: %s\n", replica.ID)
+}
+
+// SetListeningPort records the port a connection announced with REPLCONF
+// listening-port, reported by INFO once it becomes a replica
+func (rm *ReplicaManager) SetListeningPort(conn net.Conn, port int) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
 	id := conn.RemoteAddr().String()
-	rm.replicas[id] = &ReplicaConnection{
-		Conn: conn,
-		ID:   id,
+	rm.ports[id] = port
+	if replica, ok := rm.replicas[id]; ok {
+		replica.ListeningPort = port
 	}
-	fmt.Printf("Added replica: %s\n", id)
+}
+
+// SetCapabilities records the capabilities a connection announced with
+// REPLCONF capa. Only eof, the support of diskless transfers, matters.
+func (rm *ReplicaManager) SetCapabilities(conn net.Conn, capabilities []string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	for _, capability := range capabilities {
+		if capability == "eof" {
+			rm.capaEOF[conn.RemoteAddr().String()] = true
+		}
+	}
+}
+
+// SupportsDiskless reports whether the connection announced it can
+// receive a diskless transfer
+func (rm *ReplicaManager) SupportsDiskless(conn net.Conn) bool {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+	return rm.capaEOF[conn.RemoteAddr().String()]
+}
+
+// AddWaitingReplica registers a replica waiting for a diskless transfer
+// to start. StartSync must be called once the transfer starts.
+func (rm *ReplicaManager) AddWaitingReplica(conn net.Conn) *ReplicaConnection {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	replica := rm.newReplica(conn, true)
+	replica.waiting = true
+	rm.replicas[replica.ID] = replica
+	fmt.Printf("Added replica: %s (waiting for a diskless full resync)\n", replica.ID)
+	return replica
+}
+
+// AddSyncingReplica registers a replica that is about to receive a
+// snapshot. Commands propagated from now on are buffered until FinishSync,
+// so the caller must take the snapshot before any other write runs.
+func (rm *ReplicaManager) AddSyncingReplica(conn net.Conn) *ReplicaConnection {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	replica := rm.newReplica(conn, true)
+	rm.replicas[replica.ID] = replica
+	fmt.Printf("Added replica: %s (waiting for full resync)\n", replica.ID)
+	return replica
 }
 
 // RemoveReplica removes a replica connection
@@ -49,45 +311,153 @@ func (rm *ReplicaManager) RemoveReplica(conn net.Conn) {
 	defer rm.mu.Unlock()
 
 	id := conn.RemoteAddr().String()
+	delete(rm.ports, id)
+	delete(rm.capaEOF, id)
+	rm.removeReplica(id)
+}
+
+// removeReplica disconnects and forgets the replica id, if any. The caller
+// must hold rm.mu.
+func (rm *ReplicaManager) removeReplica(id string) {
+	replica, ok := rm.replicas[id]
+	if !ok {
+		return
+	}
+	replica.mu.Lock()
+	replica.close()
+	replica.mu.Unlock()
 	delete(rm.replicas, id)
+	if len(rm.replicas) == 0 {
+		rm.noReplicasAt = time.Now()
+	}
 	fmt.Printf("Removed replica: %s\n", id)
 }
 
-// PropagateCommand sends a command to all replicas
-func (rm *ReplicaManager) PropagateCommand(commandName string, args []string) {
-	rm.mu.RLock()
-	defer rm.mu.RUnlock()
+// CreateBacklog creates the backlog of size bytes if there is none yet.
+// It holds the stream from the current offset on. The caller must hold the
+// execution lock.
+func (rm *ReplicaManager) CreateBacklog(size int) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
 
-	if len(rm.replicas) == 0 {
-		return // No replicas to propagate to
+	if rm.backlog == nil {
+		rm.backlog = NewBacklog(size, int64(config.Server.MasterReplOffset))
+		fmt.Printf("Created replication backlog of %d bytes\n", size)
 	}
+}
+
+// FreeIdleBacklog frees the backlog once no replica has been connected for
+// ttl, like Redis' repl-backlog-ttl (0 keeps it forever). Returns true if
+// it was freed. The caller must hold the execution lock.
+func (rm *ReplicaManager) FreeIdleBacklog(ttl time.Duration) bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if rm.backlog == nil || ttl <= 0 || len(rm.replicas) > 0 || time.Since(rm.noReplicasAt) < ttl {
+		return false
+	}
+	rm.backlog = nil
+	fmt.Printf("Replication backlog freed after %v without replicas\n", ttl)
+	return true
+}
+
+// Reset disconnects every replica and frees the backlog, when this server
+// becomes a replica or loads a new dataset from its master: the stream it
+// served ends there. The caller must hold the execution lock.
+func (rm *ReplicaManager) Reset() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	for id := range rm.replicas {
+		rm.removeReplica(id)
+	}
+	rm.noReplicasAt = time.Now()
+	rm.backlog = nil
+}
+
+// DisconnectReplicas disconnects every replica but keeps the backlog, when
+// the replication ID changes: they reconnect and learn the new one with a
+// partial resync. The caller must hold the execution lock.
+func (rm *ReplicaManager) DisconnectReplicas() {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	for id := range rm.replicas {
+		rm.removeReplica(id)
+	}
+}
+
+// PartialResync registers a replica continuing the stream from psyncOffset
+// if the backlog still holds everything after it. The missing data is
+// buffered and sent by StartStreaming, which the caller invokes after
+// replying +CONTINUE. Returns nil if a full resynchronization is needed.
+// The caller must hold the execution lock.
+func (rm *ReplicaManager) PartialResync(conn net.Conn, psyncOffset int64) *ReplicaConnection {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
 
-	// Create RESP array for the command
-	respArray := parser.RESPValue{
-		Type:  "array",
-		Array: make([]parser.RESPValue, len(args)+1),
+	if rm.backlog == nil {
+		return nil
 	}
+	data, ok := rm.backlog.ReadFrom(psyncOffset)
+	if !ok {
+		fmt.Printf("Unable to partial resync from offset %d: the backlog holds %d to %d\n",
+			psyncOffset, rm.backlog.FirstOffset(), rm.backlog.Offset())
+		return nil
+	}
+
+	replica := rm.newReplica(conn, true)
+	replica.pending = [][]byte{data}
+	rm.replicas[replica.ID] = replica
+	fmt.Printf("Added replica: %s (partial resync, %d bytes of backlog)\n", replica.ID, len(data))
+	return replica
+}
 
-	// First element is the command name
-	respArray.Array[0] = parser.RESPValue{Type: "bulk", Str: commandName}
+// PropagateCommand appends a command to the backlog, advancing the stream
+// offset, and to the output buffer of every replica. It never waits for
+// the network. Nothing is streamed before the first replica connects. The
+// caller must hold the execution lock.
+func (rm *ReplicaManager) PropagateCommand(commandName string, args []string) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
 
-	// Rest are the arguments
-	for i, arg := range args {
-		respArray.Array[i+1] = parser.RESPValue{Type: "bulk", Str: arg}
+	if rm.backlog == nil {
+		return // No replica ever connected
 	}
 
-	// Encode to RESP format
-	respData := parser.EncodeRESP(respArray)
+	// Encode as a RESP array of bulk strings, empty arguments included
+	respData := parser.ToBulkStringArray(append([]string{commandName}, args...))
+
+	rm.backlog.Write([]byte(respData))
+	config.Server.MasterReplOffset += len(respData)
+	rm.feed([]byte(respData), "command "+commandName)
+}
+
+// FeedFromMaster forwards data a replica received from its master to its
+// own replicas and appends it to the backlog, byte for byte, so they share
+// the replication ID and offsets of its master. The caller advanced the
+// offset and holds the execution lock.
+func (rm *ReplicaManager) FeedFromMaster(data []byte) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if rm.backlog == nil {
+		return // No replica ever connected
+	}
+	rm.backlog.Write(data)
+	rm.feed(data, fmt.Sprintf("%d bytes from master", len(data)))
+}
 
-	// Send to all replicas
+// feed queues data for all replicas, dropping those falling too far
+// behind. what describes the data in logs. The caller must hold rm.mu.
+func (rm *ReplicaManager) feed(data []byte, what string) {
 	for id, replica := range rm.replicas {
-		_, err := replica.Conn.Write([]byte(respData))
+		err := replica.write(data)
 		if err != nil {
-			fmt.Printf("Failed to propagate command to replica %s: %v\n", id, err)
-			// Remove failed replica (will be cleaned up in next propagation)
-			go rm.RemoveReplica(replica.Conn)
+			fmt.Printf("Failed to propagate %s to replica %s: %v\n", what, id, err)
+			rm.removeReplica(id)
 		} else {
-			fmt.Printf("Propagated command %s to replica %s\n", commandName, id)
+			fmt.Printf("Propagated %s to replica %s\n", what, id)
 		}
 	}
 }
@@ -98,3 +468,146 @@ func (rm *ReplicaManager) GetReplicaCount() int {
 	defer rm.mu.RUnlock()
 	return len(rm.replicas)
 }
+
+// Ack records that the replica on conn acknowledged offset with REPLCONF
+// ACK and wakes up the clients blocked in WAIT. Returns false if conn is
+// not a replica.
+func (rm *ReplicaManager) Ack(conn net.Conn, offset int64) bool {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	replica, ok := rm.replicas[conn.RemoteAddr().String()]
+	if !ok {
+		return false
+	}
+	replica.mu.Lock()
+	replica.ackOffset = max(replica.ackOffset, offset)
+	replica.ackTime = time.Now()
+	online := replica.onlineOnAck
+	replica.onlineOnAck = false
+	replica.mu.Unlock()
+
+	if online {
+		if err := replica.StartStreaming(); err == nil {
+			fmt.Printf("Replica %s loaded the diskless transfer, streaming\n", replica.ID)
+		}
+	}
+
+	for ch := range rm.ackWaiters {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// AckedCount returns the number of replicas that acknowledged offset
+func (rm *ReplicaManager) AckedCount(offset int64) int {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	count := 0
+	for _, replica := range rm.replicas {
+		replica.mu.Lock()
+		if replica.ackOffset >= offset {
+			count++
+		}
+		replica.mu.Unlock()
+	}
+	return count
+}
+
+// RegisterAckWaiter returns a channel signalled whenever a replica sends an
+// acknowledgment
+func (rm *ReplicaManager) RegisterAckWaiter() chan struct{} {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	ch := make(chan struct{}, 1)
+	rm.ackWaiters[ch] = struct{}{}
+	return ch
+}
+
+// UnregisterAckWaiter removes a channel returned by RegisterAckWaiter
+func (rm *ReplicaManager) UnregisterAckWaiter(ch chan struct{}) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+	delete(rm.ackWaiters, ch)
+}
+
+// ReplicaInfo describes a replica as reported by INFO replication
+type ReplicaInfo struct {
+	IP     string
+	Port   int
+	State  string // wait_bgsave, send_bulk while syncing, then online
+	Offset int64  // Last offset acknowledged
+	Lag    int64  // Seconds since the last acknowledgment
+
+	OutputBuffer int64 // Bytes queued and not sent yet
+}
+
+// Replicas returns the replicas in the order they connected
+func (rm *ReplicaManager) Replicas() []ReplicaInfo {
+	rm.mu.RLock()
+	replicas := make([]*ReplicaConnection, 0, len(rm.replicas))
+	for _, replica := range rm.replicas {
+		replicas = append(replicas, replica)
+	}
+	rm.mu.RUnlock()
+	sort.Slice(replicas, func(i, j int) bool {
+		return replicas[i].seq < replicas[j].seq
+	})
+
+	infos := make([]ReplicaInfo, 0, len(replicas))
+	now := time.Now()
+	for _, replica := range replicas {
+		ip, _, err := net.SplitHostPort(replica.ID)
+		if err != nil {
+			ip = replica.ID
+		}
+		replica.mu.Lock()
+		info := ReplicaInfo{
+			IP:     ip,
+			Port:   replica.ListeningPort,
+			State:  "online",
+			Offset: replica.ackOffset,
+			Lag:    int64(now.Sub(replica.ackTime) / time.Second),
+
+			OutputBuffer: replica.buffered,
+		}
+		if replica.waiting {
+			info.State = "wait_bgsave"
+		} else if replica.syncing {
+			info.State = "send_bulk"
+		}
+		replica.mu.Unlock()
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// BacklogInfo describes the backlog as reported by INFO replication
+type BacklogInfo struct {
+	Active          bool
+	Size            int
+	FirstByteOffset int64
+	Histlen         int
+}
+
+// BacklogInfo returns the state of the backlog. size is reported when no
+// backlog has been created.
+func (rm *ReplicaManager) BacklogInfo(size int) BacklogInfo {
+	rm.mu.RLock()
+	defer rm.mu.RUnlock()
+
+	if rm.backlog == nil {
+		return BacklogInfo{Size: size}
+	}
+	return BacklogInfo{
+		Active:          true,
+		Size:            rm.backlog.Size(),
+		FirstByteOffset: rm.backlog.FirstOffset(),
+		Histlen:         rm.backlog.Histlen(),
+	}
+}
@@ -1,8 +1,10 @@
 package repository
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/codecrafters-io/redis-starter-go/app/glob"
 	"github.com/codecrafters-io/redis-starter-go/app/storage"
 )
 
@@ -44,27 +46,176 @@ func (r *MemoryRepository) Delete(key string) error {
 
 // Exists checks if a key exists in storage
 func (r *MemoryRepository) Exists(key string) bool {
-	_, exists := r.storage.Get(key)
+	_, exists := r.storage.Lookup(key)
 	return exists
 }
 
-// Keys returns all keys matching a pattern (simplified implementation)
+// Keys returns all keys matching a glob-style pattern
 func (r *MemoryRepository) Keys(pattern string) ([]string, error) {
-	// For now, return empty slice - this would need proper implementation
-	// with pattern matching in a production system
-	return []string{}, nil
+	keys := r.storage.Keys()
+	if pattern == "*" {
+		return keys, nil
+	}
+
+	matching := keys[:0]
+	for _, key := range keys {
+		if glob.Match(pattern, key, false) {
+			matching = append(matching, key)
+		}
+	}
+	return matching, nil
 }
 
 // Clear removes all keys from storage
 func (r *MemoryRepository) Clear() error {
-	// Create a new dictionary to clear all data
-	r.storage = storage.NewExpiringDict()
+	// Clear in place, the dictionary may be shared with other components
+	r.storage.Clear()
 	return nil
 }
 
 // Size returns the number of keys in storage
 func (r *MemoryRepository) Size() int {
-	// This would need to be implemented in ExpiringDict
-	// For now, return 0 as a placeholder
-	return 0
+	return r.storage.Len()
+}
+
+// Restore stores a value with an absolute expiration
+func (r *MemoryRepository) Restore(key string, value interface{}, expiresAt *time.Time) error {
+	switch value.(type) {
+	case string, []byte, *storage.Stream, *storage.SortedSet:
+	default:
+		return fmt.Errorf("unsupported value type %T", value)
+	}
+	r.storage.SetEntry(key, storage.KeyValue{Value: value, ExpiresAt: expiresAt})
+	return nil
+}
+
+// Lookup returns the value and expiration stored at key
+func (r *MemoryRepository) Lookup(key string) (storage.KeyValue, bool) {
+	return r.storage.Lookup(key)
+}
+
+// Snapshot returns a point-in-time copy of all keys
+func (r *MemoryRepository) Snapshot() map[string]storage.KeyValue {
+	return r.storage.Snapshot()
+}
+
+// Replace atomically swaps all keys for the content of snapshot
+func (r *MemoryRepository) Replace(snapshot map[string]storage.KeyValue) {
+	r.storage.Replace(snapshot)
+}
+
+// Type returns the type name of the value stored at key
+func (r *MemoryRepository) Type(key string) string {
+	kv, exists := r.storage.Lookup(key)
+	if !exists {
+		return "none"
+	}
+	switch kv.Value.(type) {
+	case string, []byte:
+		return "string"
+	case *storage.Stream:
+		return "stream"
+	case *storage.SortedSet:
+		return "zset"
+	default:
+		return "unknown"
+	}
+}
+
+// GetBytes returns the raw bytes of the string stored at key
+func (r *MemoryRepository) GetBytes(key string) ([]byte, bool, error) {
+	kv, exists := r.storage.Lookup(key)
+	if !exists {
+		return nil, false, nil
+	}
+
+	switch value := kv.Value.(type) {
+	case []byte:
+		return value, true, nil
+	case string:
+		return []byte(value), true, nil
+	}
+	return nil, false, ErrWrongType
+}
+
+// UpdateString modifies the string stored at key in place
+func (r *MemoryRepository) UpdateString(key string, fn func(value []byte, exists bool) ([]byte, error)) error {
+	var updateErr error
+	r.storage.Update(key, func(kv *storage.KeyValue, exists bool) bool {
+		var buf []byte
+		if exists {
+			switch value := kv.Value.(type) {
+			case []byte:
+				buf = value
+			case string:
+				// Switch to a mutable buffer once, later updates reuse it
+				buf = []byte(value)
+			default:
+				updateErr = ErrWrongType
+				return false
+			}
+		}
+
+		newBuf, err := fn(buf, exists)
+		if err != nil {
+			updateErr = err
+			return false
+		}
+		kv.Value = newBuf
+		return true
+	})
+	return updateErr
+}
+
+// GetStream returns the stream stored at key, optionally creating it
+func (r *MemoryRepository) GetStream(key string, create bool) (*storage.Stream, error) {
+	kv, exists := r.storage.Lookup(key)
+	if !exists {
+		if !create {
+			return nil, nil
+		}
+		stream := storage.NewStream()
+		r.storage.SetObject(key, stream)
+		return stream, nil
+	}
+
+	stream, ok := kv.Value.(*storage.Stream)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return stream, nil
+}
+
+// GetSortedSet returns the sorted set stored at key, optionally creating it
+func (r *MemoryRepository) GetSortedSet(key string, create bool) (*storage.SortedSet, error) {
+	kv, exists := r.storage.Lookup(key)
+	if !exists {
+		if !create {
+			return nil, nil
+		}
+		zset := storage.NewSortedSet()
+		r.storage.SetObject(key, zset)
+		return zset, nil
+	}
+
+	zset, ok := kv.Value.(*storage.SortedSet)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return zset, nil
+}
+
+// SetSortedSet stores zset at key, replacing any previous value
+func (r *MemoryRepository) SetSortedSet(key string, zset *storage.SortedSet) {
+	r.storage.SetObject(key, zset)
+}
+
+// SetExpireHandler sets the function deciding the fate of expired keys
+func (r *MemoryRepository) SetExpireHandler(fn func(key string) storage.ExpireAction) {
+	r.storage.SetExpireHandler(fn)
+}
+
+// ExpireKeys handles the expired keys among a sample of volatile keys
+func (r *MemoryRepository) ExpireKeys(sample int) (int, int) {
+	return r.storage.ExpireKeys(sample)
 }
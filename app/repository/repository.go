@@ -1,6 +1,14 @@
 package repository
 
-import "time"
+import (
+	"errors"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// ErrWrongType is returned when a key holds a value of a different type
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
 
 // KeyValueRepository defines the interface for key-value storage operations
 type KeyValueRepository interface {
@@ -16,7 +24,7 @@ type KeyValueRepository interface {
 	// Exists checks if a key exists in storage
 	Exists(key string) bool
 
-	// Keys returns all keys matching a pattern (for future use)
+	// Keys returns all keys matching a glob-style pattern
 	Keys(pattern string) ([]string, error)
 
 	// Clear removes all keys from storage
@@ -24,4 +32,51 @@ type KeyValueRepository interface {
 
 	// Size returns the number of keys in storage
 	Size() int
+
+	// Restore stores a value (string, []byte, *storage.Stream or
+	// *storage.SortedSet) with an absolute expiration, replacing any
+	// previous value, as loaded from a snapshot or set with a deadline
+	Restore(key string, value interface{}, expiresAt *time.Time) error
+
+	// Lookup returns the value and expiration stored at key, of any type
+	Lookup(key string) (storage.KeyValue, bool)
+
+	// Snapshot returns a point-in-time copy of all keys that can be
+	// serialized while commands keep modifying the repository
+	Snapshot() map[string]storage.KeyValue
+
+	// Replace atomically swaps all keys for the content of snapshot, which
+	// must not be used by the caller afterwards
+	Replace(snapshot map[string]storage.KeyValue)
+
+	// Type returns the type name of the value stored at key ("none" if missing)
+	Type(key string) string
+
+	// GetBytes returns the raw bytes of the string stored at key. The returned
+	// slice must not be modified.
+	GetBytes(key string) ([]byte, bool, error)
+
+	// UpdateString replaces the string stored at key with the buffer returned
+	// by fn, which may modify value in place. Missing keys are passed as nil
+	// with exists set to false. The expiration of the key is preserved.
+	UpdateString(key string, fn func(value []byte, exists bool) ([]byte, error)) error
+
+	// GetStream returns the stream stored at key, creating it when create is true.
+	// Returns a nil stream if the key does not exist and create is false.
+	GetStream(key string, create bool) (*storage.Stream, error)
+
+	// GetSortedSet returns the sorted set stored at key, creating it when create
+	// is true. Returns a nil set if the key does not exist and create is false.
+	GetSortedSet(key string, create bool) (*storage.SortedSet, error)
+
+	// SetSortedSet stores zset at key, replacing any previous value and expiration
+	SetSortedSet(key string, zset *storage.SortedSet)
+
+	// SetExpireHandler sets the function deciding whether a key found
+	// expired is deleted, hidden or kept
+	SetExpireHandler(fn func(key string) storage.ExpireAction)
+
+	// ExpireKeys checks up to sample keys having an expiration and handles
+	// the expired ones. Returns the numbers of keys checked and expired.
+	ExpireKeys(sample int) (int, int)
 }
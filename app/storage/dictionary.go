@@ -5,25 +5,95 @@ import (
 	"time"
 )
 
-// KeyValue represents a value with expiration
+// KeyValue represents a value with expiration.
+// Value holds a string for plain keys, a []byte for strings that are
+// modified in place (bitmaps, ...) or a pointer to a data structure such as
+// *Stream for the other types.
 type KeyValue struct {
-	Value     string
+	Value     interface{}
 	ExpiresAt *time.Time
 }
 
-// ExpiringDict is a thread-safe dictionary with expiration support
+// ExpireAction is what the expire handler decides for a key found expired
+type ExpireAction int
+
+const (
+	// ExpireDelete deletes the key
+	ExpireDelete ExpireAction = iota
+	// ExpireHide keeps the key but reports it missing
+	ExpireHide
+	// ExpireKeep reports the key as if it had not expired
+	ExpireKeep
+)
+
+// ExpiringDict is a thread-safe dictionary with expiration support.
+// Expired keys are not returned unless the expire handler keeps them. They
+// are removed when an access finds them or by ExpireKeys, if the handler
+// agrees.
 type ExpiringDict struct {
-	data map[string]KeyValue
-	mu   sync.RWMutex
+	data     map[string]KeyValue
+	volatile map[string]struct{} // Keys having an expiration
+	mu       sync.RWMutex
+
+	onExpire func(key string) ExpireAction
 }
 
 // NewExpiringDict creates a new expiring dictionary
 func NewExpiringDict() *ExpiringDict {
 	return &ExpiringDict{
-		data: make(map[string]KeyValue),
+		data:     make(map[string]KeyValue),
+		volatile: make(map[string]struct{}),
+	}
+}
+
+// SetExpireHandler sets the function called with each key found expired,
+// deciding whether it is deleted, hidden or kept: a replica keeps expired
+// keys until its master deletes them, hiding them from its clients only.
+// Without a handler expired keys are deleted. fn must not access the
+// dictionary.
+func (ed *ExpiringDict) SetExpireHandler(fn func(key string) ExpireAction) {
+	ed.mu.Lock()
+	defer ed.mu.Unlock()
+	ed.onExpire = fn
+}
+
+// store sets key to kv; the caller must hold the write lock
+func (ed *ExpiringDict) store(key string, kv KeyValue) {
+	ed.data[key] = kv
+	if kv.ExpiresAt != nil {
+		ed.volatile[key] = struct{}{}
+	} else {
+		delete(ed.volatile, key)
 	}
 }
 
+// remove deletes key; the caller must hold the write lock
+func (ed *ExpiringDict) remove(key string) {
+	delete(ed.data, key)
+	delete(ed.volatile, key)
+}
+
+// expired handles key found expired as the handler decides. Returns false
+// if the key must be treated as live. The caller must hold the write lock.
+func (ed *ExpiringDict) expired(key string) bool {
+	action := ExpireDelete
+	if ed.onExpire != nil {
+		action = ed.onExpire(key)
+	}
+	switch action {
+	case ExpireDelete:
+		ed.remove(key)
+	case ExpireKeep:
+		return false
+	}
+	return true
+}
+
+// isExpired reports whether kv expired at now
+func isExpired(kv KeyValue, now time.Time) bool {
+	return kv.ExpiresAt != nil && now.After(*kv.ExpiresAt)
+}
+
 // Set stores a key-value pair with optional expiration
 func (ed *ExpiringDict) Set(key, value string, expiration *time.Duration) {
 	ed.mu.Lock()
@@ -33,42 +103,183 @@ func (ed *ExpiringDict) Set(key, value string, expiration *time.Duration) {
 	if expiration != nil {
 		expiresAt := time.Now().Add(*expiration)
 		kv.ExpiresAt = &expiresAt
-
-		// Start a goroutine to delete the key after expiration
-		go func(k string, expireTime time.Time) {
-			time.Sleep(time.Until(expireTime))
-			ed.Delete(k)
-		}(key, expiresAt)
 	}
+	ed.store(key, kv)
+}
 
-	ed.data[key] = kv
+// SetObject stores a non-string value (stream, ...) without expiration
+func (ed *ExpiringDict) SetObject(key string, value interface{}) {
+	ed.mu.Lock()
+	defer ed.mu.Unlock()
+	ed.store(key, KeyValue{Value: value})
 }
 
-// Get retrieves a value by key, checking expiration
+// SetEntry stores kv as is, with its absolute expiration
+func (ed *ExpiringDict) SetEntry(key string, kv KeyValue) {
+	ed.mu.Lock()
+	defer ed.mu.Unlock()
+	ed.store(key, kv)
+}
+
+// Get retrieves a string value by key, checking expiration.
+// Keys holding other types are reported as missing.
 func (ed *ExpiringDict) Get(key string) (string, bool) {
-	ed.mu.RLock()
-	defer ed.mu.RUnlock()
+	kv, exists := ed.Lookup(key)
+	if !exists {
+		return "", false
+	}
+	switch value := kv.Value.(type) {
+	case string:
+		return value, true
+	case []byte:
+		return string(value), true
+	}
+	return "", false
+}
 
+// Lookup retrieves the entry stored at key, checking expiration
+func (ed *ExpiringDict) Lookup(key string) (KeyValue, bool) {
+	ed.mu.RLock()
 	kv, exists := ed.data[key]
+	ed.mu.RUnlock()
 	if !exists {
-		return "", false
+		return KeyValue{}, false
+	}
+	if !isExpired(kv, time.Now()) {
+		return kv, true
 	}
 
-	// Check if expired
-	if kv.ExpiresAt != nil && time.Now().After(*kv.ExpiresAt) {
-		// Key has expired, delete it
-		go ed.Delete(key)
-		return "", false
+	// Check again with the write lock, the key may have changed meanwhile
+	ed.mu.Lock()
+	defer ed.mu.Unlock()
+	kv, exists = ed.data[key]
+	if !exists {
+		return KeyValue{}, false
+	}
+	if isExpired(kv, time.Now()) && ed.expired(key) {
+		return KeyValue{}, false
+	}
+	return kv, true
+}
+
+// Update runs fn on the entry stored at key while holding the write lock.
+// Missing keys, and expired keys the handler does not keep, are passed
+// with exists set to false. The entry is stored back when fn returns true.
+func (ed *ExpiringDict) Update(key string, fn func(kv *KeyValue, exists bool) bool) {
+	ed.mu.Lock()
+	defer ed.mu.Unlock()
+
+	kv, exists := ed.data[key]
+	if exists && isExpired(kv, time.Now()) && ed.expired(key) {
+		kv, exists = KeyValue{}, false
+	}
+
+	if fn(&kv, exists) {
+		ed.store(key, kv)
 	}
+}
+
+// ExpireKeys checks up to sample keys having an expiration, in no
+// particular order, and handles those that expired like an access would.
+// Returns the number of keys checked and of keys found expired.
+func (ed *ExpiringDict) ExpireKeys(sample int) (int, int) {
+	ed.mu.Lock()
+	defer ed.mu.Unlock()
 
-	return kv.Value, true
+	now := time.Now()
+	var checked, expired []string
+	for key := range ed.volatile {
+		if len(checked) == sample {
+			break
+		}
+		checked = append(checked, key)
+		if isExpired(ed.data[key], now) {
+			expired = append(expired, key)
+		}
+	}
+	for _, key := range expired {
+		ed.expired(key)
+	}
+	return len(checked), len(expired)
+}
+
+// Keys returns the keys that have not expired, in no particular order
+func (ed *ExpiringDict) Keys() []string {
+	ed.mu.RLock()
+	defer ed.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]string, 0, len(ed.data))
+	for key, kv := range ed.data {
+		if isExpired(kv, now) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Snapshot returns a point-in-time copy of the keys that have not expired.
+// Mutable values are cloned so the copy can be read while the dictionary
+// keeps changing. Data structures are modified outside of the dictionary
+// lock, so callers must also prevent commands from running meanwhile.
+func (ed *ExpiringDict) Snapshot() map[string]KeyValue {
+	ed.mu.RLock()
+	defer ed.mu.RUnlock()
+
+	now := time.Now()
+	snapshot := make(map[string]KeyValue, len(ed.data))
+	for key, kv := range ed.data {
+		if isExpired(kv, now) {
+			continue
+		}
+		switch value := kv.Value.(type) {
+		case []byte:
+			kv.Value = append([]byte(nil), value...)
+		case *Stream:
+			kv.Value = value.Clone()
+		case *SortedSet:
+			kv.Value = value.Clone()
+		}
+		snapshot[key] = kv
+	}
+	return snapshot
+}
+
+// Replace swaps the whole content of the dictionary for data in one step
+func (ed *ExpiringDict) Replace(data map[string]KeyValue) {
+	ed.mu.Lock()
+	defer ed.mu.Unlock()
+	ed.data = data
+	ed.volatile = make(map[string]struct{})
+	for key, kv := range data {
+		if kv.ExpiresAt != nil {
+			ed.volatile[key] = struct{}{}
+		}
+	}
+}
+
+// Len returns the number of stored keys, including expired keys that have
+// not been removed yet
+func (ed *ExpiringDict) Len() int {
+	ed.mu.RLock()
+	defer ed.mu.RUnlock()
+	return len(ed.data)
+}
+
+// Clear removes all keys
+func (ed *ExpiringDict) Clear() {
+	ed.mu.Lock()
+	defer ed.mu.Unlock()
+	ed.data = make(map[string]KeyValue)
+	ed.volatile = make(map[string]struct{})
 }
 
 // Delete removes a key from the dictionary
 func (ed *ExpiringDict) Delete(key string) {
 	ed.mu.Lock()
 	defer ed.mu.Unlock()
-	delete(ed.data, key)
+	ed.remove(key)
 }
 
 // Global dictionary instance
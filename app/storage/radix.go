@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"bytes"
+	"sort"
+)
+
+// radixNode is a node of a path-compressed radix tree
+type radixNode[V any] struct {
+	prefix   []byte
+	children []*radixNode[V] // Sorted by the first byte of their prefix
+	hasValue bool
+	value    V
+}
+
+// RadixTree is an ordered map from byte-string keys to values, backed by a
+// path-compressed radix tree in the spirit of Redis' rax. Keys sharing a
+// prefix (such as big-endian stream IDs) share their path in the tree.
+type RadixTree[V any] struct {
+	root radixNode[V]
+	size int
+}
+
+// Len returns the number of keys stored in the tree
+func (t *RadixTree[V]) Len() int {
+	return t.size
+}
+
+// NodeCount returns the number of nodes in the tree, including the root
+func (t *RadixTree[V]) NodeCount() int {
+	return t.root.count()
+}
+
+// Insert stores value at key and returns true if the key was not present
+func (t *RadixTree[V]) Insert(key []byte, value V) bool {
+	node := &t.root
+	for {
+		if len(key) == 0 {
+			isNew := !node.hasValue
+			node.hasValue = true
+			node.value = value
+			if isNew {
+				t.size++
+			}
+			return isNew
+		}
+
+		idx, found := node.childIndex(key[0])
+		if !found {
+			child := &radixNode[V]{prefix: append([]byte(nil), key...), hasValue: true, value: value}
+			node.children = append(node.children, nil)
+			copy(node.children[idx+1:], node.children[idx:])
+			node.children[idx] = child
+			t.size++
+			return true
+		}
+
+		child := node.children[idx]
+		common := commonPrefixLength(child.prefix, key)
+		if common < len(child.prefix) {
+			// Split the child so that the shared part becomes its own node
+			split := &radixNode[V]{
+				prefix:   append([]byte(nil), child.prefix[:common]...),
+				children: []*radixNode[V]{child},
+			}
+			child.prefix = child.prefix[common:]
+			node.children[idx] = split
+			child = split
+		}
+		node = child
+		key = key[common:]
+	}
+}
+
+// Find returns the value stored at key
+func (t *RadixTree[V]) Find(key []byte) (V, bool) {
+	node := &t.root
+	for len(key) > 0 {
+		idx, found := node.childIndex(key[0])
+		if !found {
+			var zero V
+			return zero, false
+		}
+		child := node.children[idx]
+		if !bytes.HasPrefix(key, child.prefix) {
+			var zero V
+			return zero, false
+		}
+		node = child
+		key = key[len(child.prefix):]
+	}
+	return node.value, node.hasValue
+}
+
+// Remove deletes key from the tree and returns true if it was present
+func (t *RadixTree[V]) Remove(key []byte) bool {
+	type step struct {
+		parent *radixNode[V]
+		idx    int
+	}
+
+	var path []step
+	node := &t.root
+	for len(key) > 0 {
+		idx, found := node.childIndex(key[0])
+		if !found {
+			return false
+		}
+		child := node.children[idx]
+		if !bytes.HasPrefix(key, child.prefix) {
+			return false
+		}
+		path = append(path, step{parent: node, idx: idx})
+		node = child
+		key = key[len(child.prefix):]
+	}
+	if !node.hasValue {
+		return false
+	}
+
+	var zero V
+	node.hasValue = false
+	node.value = zero
+	t.size--
+
+	// Compact the tree: drop empty leaves and merge single-child chains
+	if len(path) == 0 {
+		return true
+	}
+	switch len(node.children) {
+	case 0:
+		last := path[len(path)-1]
+		parent := last.parent
+		parent.children = append(parent.children[:last.idx], parent.children[last.idx+1:]...)
+		if len(path) > 1 && !parent.hasValue && len(parent.children) == 1 {
+			parent.mergeWithChild()
+		}
+	case 1:
+		node.mergeWithChild()
+	}
+	return true
+}
+
+// Ascend calls fn for every key >= from in ascending order until fn returns
+// false. A nil from starts at the smallest key.
+func (t *RadixTree[V]) Ascend(from []byte, fn func(key []byte, value V) bool) {
+	t.root.ascend(nil, from, fn)
+}
+
+// Descend calls fn for every key <= from in descending order until fn returns
+// false. A nil from starts at the largest key.
+func (t *RadixTree[V]) Descend(from []byte, fn func(key []byte, value V) bool) {
+	t.root.descend(nil, from, fn)
+}
+
+// First returns the smallest key and its value
+func (t *RadixTree[V]) First() (key []byte, value V, ok bool) {
+	t.Ascend(nil, func(k []byte, v V) bool {
+		key, value, ok = k, v, true
+		return false
+	})
+	return key, value, ok
+}
+
+// Last returns the largest key and its value
+func (t *RadixTree[V]) Last() (key []byte, value V, ok bool) {
+	t.Descend(nil, func(k []byte, v V) bool {
+		key, value, ok = k, v, true
+		return false
+	})
+	return key, value, ok
+}
+
+// childIndex finds the child starting with b, or the position to insert it
+func (n *radixNode[V]) childIndex(b byte) (int, bool) {
+	idx := sort.Search(len(n.children), func(i int) bool {
+		return n.children[i].prefix[0] >= b
+	})
+	return idx, idx < len(n.children) && n.children[idx].prefix[0] == b
+}
+
+// count returns the number of nodes in the subtree rooted at n
+func (n *radixNode[V]) count() int {
+	total := 1
+	for _, child := range n.children {
+		total += child.count()
+	}
+	return total
+}
+
+// mergeWithChild folds the only child of n into n
+func (n *radixNode[V]) mergeWithChild() {
+	child := n.children[0]
+	n.prefix = append(append([]byte(nil), n.prefix...), child.prefix...)
+	n.children = child.children
+	n.hasValue = child.hasValue
+	n.value = child.value
+}
+
+// ascend walks the subtree whose keys all start with key
+func (n *radixNode[V]) ascend(key, from []byte, fn func([]byte, V) bool) bool {
+	if from != nil {
+		c := comparePrefix(key, from)
+		if c < 0 {
+			return true // Every key in this subtree sorts before from
+		}
+		if c > 0 {
+			from = nil // Every key in this subtree sorts after from
+		}
+	}
+
+	if n.hasValue && (from == nil || len(key) >= len(from)) {
+		if !fn(key, n.value) {
+			return false
+		}
+	}
+	for _, child := range n.children {
+		childKey := append(key[:len(key):len(key)], child.prefix...)
+		if !child.ascend(childKey, from, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// descend walks the subtree whose keys all start with key in reverse order
+func (n *radixNode[V]) descend(key, from []byte, fn func([]byte, V) bool) bool {
+	if from != nil {
+		c := comparePrefix(key, from)
+		if c > 0 {
+			return true // Every key in this subtree sorts after from
+		}
+		if c < 0 {
+			from = nil // Every key in this subtree sorts before from
+		}
+	}
+
+	for i := len(n.children) - 1; i >= 0; i-- {
+		child := n.children[i]
+		childKey := append(key[:len(key):len(key)], child.prefix...)
+		if !child.descend(childKey, from, fn) {
+			return false
+		}
+	}
+	if n.hasValue {
+		return fn(key, n.value)
+	}
+	return true
+}
+
+// comparePrefix compares key with the first len(key) bytes of from. A key
+// that extends from sorts after it.
+func comparePrefix(key, from []byte) int {
+	if len(key) <= len(from) {
+		return bytes.Compare(key, from[:len(key)])
+	}
+	if c := bytes.Compare(key[:len(from)], from); c != 0 {
+		return c
+	}
+	return 1
+}
+
+// commonPrefixLength returns the length of the common prefix of a and b
+func commonPrefixLength(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,186 @@
+package storage
+
+import "math/rand"
+
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+// skiplistNode is an element of the skiplist ordered by (score, member)
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	forward  []*skiplistNode
+}
+
+// SortedSet is a set of members ordered by score, backed by a skiplist for
+// range queries and a map for member lookups like Redis' zset encoding
+type SortedSet struct {
+	header *skiplistNode
+	tail   *skiplistNode
+	level  int
+	scores map[string]float64
+}
+
+// NewSortedSet creates an empty sorted set
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		header: &skiplistNode{forward: make([]*skiplistNode, skiplistMaxLevel)},
+		level:  1,
+		scores: make(map[string]float64),
+	}
+}
+
+// Len returns the number of members
+func (z *SortedSet) Len() int {
+	return len(z.scores)
+}
+
+// Score returns the score of member
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, ok := z.scores[member]
+	return score, ok
+}
+
+// less reports whether (score, member) sorts before node
+func (n *skiplistNode) less(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func randomLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// Add sets the score of member and reports whether it was newly added
+func (z *SortedSet) Add(member string, score float64) bool {
+	old, exists := z.scores[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.delete(member, old)
+	}
+	z.insert(member, score)
+	z.scores[member] = score
+	return !exists
+}
+
+// Remove deletes member and reports whether it existed
+func (z *SortedSet) Remove(member string) bool {
+	score, exists := z.scores[member]
+	if !exists {
+		return false
+	}
+	z.delete(member, score)
+	delete(z.scores, member)
+	return true
+}
+
+func (z *SortedSet) insert(member string, score float64) {
+	var update [skiplistMaxLevel]*skiplistNode
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && x.forward[i].less(score, member) {
+			x = x.forward[i]
+		}
+		update[i] = x
+	}
+
+	level := randomLevel()
+	if level > z.level {
+		for i := z.level; i < level; i++ {
+			update[i] = z.header
+		}
+		z.level = level
+	}
+
+	node := &skiplistNode{member: member, score: score, forward: make([]*skiplistNode, level)}
+	for i := 0; i < level; i++ {
+		node.forward[i] = update[i].forward[i]
+		update[i].forward[i] = node
+	}
+
+	if update[0] != z.header {
+		node.backward = update[0]
+	}
+	if node.forward[0] != nil {
+		node.forward[0].backward = node
+	} else {
+		z.tail = node
+	}
+}
+
+func (z *SortedSet) delete(member string, score float64) {
+	var update [skiplistMaxLevel]*skiplistNode
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && x.forward[i].less(score, member) {
+			x = x.forward[i]
+		}
+		update[i] = x
+	}
+
+	x = x.forward[0]
+	if x == nil || x.score != score || x.member != member {
+		return
+	}
+	for i := 0; i < z.level; i++ {
+		if update[i].forward[i] == x {
+			update[i].forward[i] = x.forward[i]
+		}
+	}
+	if x.forward[0] != nil {
+		x.forward[0].backward = x.backward
+	} else {
+		z.tail = x.backward
+	}
+	for z.level > 1 && z.header.forward[z.level-1] == nil {
+		z.level--
+	}
+}
+
+// RangeByScore calls fn for the members with min <= score < max (or
+// score <= max when maxInclusive is set) in ascending order until fn
+// returns false
+func (z *SortedSet) RangeByScore(min, max float64, maxInclusive bool, fn func(member string, score float64) bool) {
+	x := z.header
+	for i := z.level - 1; i >= 0; i-- {
+		for x.forward[i] != nil && x.forward[i].score < min {
+			x = x.forward[i]
+		}
+	}
+
+	for x = x.forward[0]; x != nil; x = x.forward[0] {
+		if x.score > max || (x.score == max && !maxInclusive) {
+			return
+		}
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+// Ascend calls fn for every member in ascending order until fn returns false
+func (z *SortedSet) Ascend(fn func(member string, score float64) bool) {
+	for x := z.header.forward[0]; x != nil; x = x.forward[0] {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+// Clone returns a copy of the set
+func (z *SortedSet) Clone() *SortedSet {
+	clone := NewSortedSet()
+	z.Ascend(func(member string, score float64) bool {
+		clone.Add(member, score)
+		return true
+	})
+	return clone
+}
@@ -0,0 +1,500 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Limits for a single stream node, mirroring Redis' stream-node-max-entries
+// and stream-node-max-bytes defaults
+const (
+	StreamNodeMaxEntries = 100
+	StreamNodeMaxBytes   = 4096
+)
+
+// ErrInvalidStreamID is returned when a stream ID cannot be parsed
+var ErrInvalidStreamID = errors.New("ERR Invalid stream ID specified as stream command argument")
+
+// StreamID identifies a stream entry as <milliseconds>-<sequence>
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// MaxStreamID is the largest possible stream ID
+var MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+
+// ParseStreamID parses "<ms>-<seq>" or "<ms>". When the sequence part is
+// missing it is set to missingSeq.
+func ParseStreamID(s string, missingSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: missingSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrInvalidStreamID
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+// String formats the ID as <ms>-<seq>
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Compare returns -1, 0 or 1 depending on how id sorts relative to other
+func (id StreamID) Compare(other StreamID) int {
+	switch {
+	case id.Ms < other.Ms:
+		return -1
+	case id.Ms > other.Ms:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	}
+	return 0
+}
+
+// IsZero reports whether id is 0-0
+func (id StreamID) IsZero() bool {
+	return id.Ms == 0 && id.Seq == 0
+}
+
+// Incr returns the ID that immediately follows id, or false on overflow
+func (id StreamID) Incr() (StreamID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return StreamID{Ms: id.Ms + 1, Seq: 0}, true
+	}
+	return id, false
+}
+
+// Decr returns the ID that immediately precedes id, or false on underflow
+func (id StreamID) Decr() (StreamID, bool) {
+	if id.Seq > 0 {
+		return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// Bytes encodes the ID as a 16 byte big-endian key that sorts like the ID
+func (id StreamID) Bytes() []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], id.Ms)
+	binary.BigEndian.PutUint64(key[8:], id.Seq)
+	return key
+}
+
+// StreamIDFromBytes decodes a key produced by StreamID.Bytes
+func StreamIDFromBytes(key []byte) StreamID {
+	return StreamID{
+		Ms:  binary.BigEndian.Uint64(key[:8]),
+		Seq: binary.BigEndian.Uint64(key[8:16]),
+	}
+}
+
+// StreamEntry is a single stream entry with its flattened field/value pairs
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// streamNodeEntry is an entry stored relative to the master entry of its node
+type streamNodeEntry struct {
+	msDelta  int64
+	seqDelta int64
+	deleted  bool
+	fields   []string // nil when the entry has the same fields as the master entry
+	values   []string
+}
+
+// streamNode is a batch of consecutive entries. Entries store their IDs as
+// deltas from the master ID, and field names are shared with the master
+// entry whenever possible, the same way Redis packs entries in listpacks.
+type streamNode struct {
+	master       StreamID
+	masterFields []string
+	entries      []streamNodeEntry
+	live         int
+	bytes        int
+}
+
+// id returns the full ID of the entry at position i
+func (n *streamNode) id(i int) StreamID {
+	e := &n.entries[i]
+	return StreamID{Ms: n.master.Ms + uint64(e.msDelta), Seq: n.master.Seq + uint64(e.seqDelta)}
+}
+
+// entry materializes the entry at position i
+func (n *streamNode) entry(i int) StreamEntry {
+	e := &n.entries[i]
+	fieldNames := e.fields
+	if fieldNames == nil {
+		fieldNames = n.masterFields
+	}
+	fields := make([]string, 0, len(e.values)*2)
+	for j, value := range e.values {
+		fields = append(fields, fieldNames[j], value)
+	}
+	return StreamEntry{ID: n.id(i), Fields: fields}
+}
+
+// find returns the position of the entry with the given ID in the node
+func (n *streamNode) find(id StreamID) (int, bool) {
+	lo, hi := 0, len(n.entries)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if n.id(mid).Compare(id) < 0 {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, lo < len(n.entries) && n.id(lo) == id
+}
+
+// lastID returns the ID of the last entry in the node, deleted or not
+func (n *streamNode) lastID() StreamID {
+	return n.id(len(n.entries) - 1)
+}
+
+// Stream is an append-only log of entries ordered by ID. Entries are kept in
+// nodes indexed by the ID of their first entry in a radix tree.
+type Stream struct {
+	nodes        RadixTree[*streamNode]
+	length       uint64
+	lastID       StreamID
+	firstID      StreamID
+	maxDeletedID StreamID
+	entriesAdded uint64
+	groups       RadixTree[*StreamGroup]
+}
+
+// NewStream creates an empty stream
+func NewStream() *Stream {
+	return &Stream{}
+}
+
+// Len returns the number of entries in the stream
+func (s *Stream) Len() uint64 {
+	return s.length
+}
+
+// LastID returns the ID of the last entry ever added to the stream
+func (s *Stream) LastID() StreamID {
+	return s.lastID
+}
+
+// FirstID returns the ID of the first entry, or 0-0 for an empty stream
+func (s *Stream) FirstID() StreamID {
+	return s.firstID
+}
+
+// MaxDeletedID returns the largest ID removed with XDEL
+func (s *Stream) MaxDeletedID() StreamID {
+	return s.maxDeletedID
+}
+
+// EntriesAdded returns the number of entries added over the stream lifetime
+func (s *Stream) EntriesAdded() uint64 {
+	return s.entriesAdded
+}
+
+// NodeStats returns the number of entry nodes and radix tree nodes
+func (s *Stream) NodeStats() (keys int, nodes int) {
+	return s.nodes.Len(), s.nodes.NodeCount()
+}
+
+// SetLastID overrides the last ID, entries-added counter and max deleted ID
+func (s *Stream) SetLastID(lastID StreamID, entriesAdded uint64, maxDeletedID StreamID) {
+	s.lastID = lastID
+	s.entriesAdded = entriesAdded
+	s.maxDeletedID = maxDeletedID
+}
+
+// NextID generates the ID for a new entry. When seqGiven is false the
+// sequence is picked automatically for the given milliseconds.
+func (s *Stream) NextID(ms uint64, seq uint64, seqGiven bool) (StreamID, bool) {
+	if seqGiven {
+		id := StreamID{Ms: ms, Seq: seq}
+		return id, id.Compare(s.lastID) > 0
+	}
+	if ms > s.lastID.Ms {
+		return StreamID{Ms: ms, Seq: 0}, true
+	}
+	if ms == s.lastID.Ms {
+		return s.lastID.Incr()
+	}
+	return StreamID{}, false
+}
+
+// Add appends an entry; id must be greater than LastID
+func (s *Stream) Add(id StreamID, fields []string) {
+	names := make([]string, 0, len(fields)/2)
+	values := make([]string, 0, len(fields)/2)
+	size := 0
+	for i := 0; i+1 < len(fields); i += 2 {
+		names = append(names, fields[i])
+		values = append(values, fields[i+1])
+		size += len(fields[i]) + len(fields[i+1])
+	}
+
+	_, node, ok := s.nodes.Last()
+	if !ok || len(node.entries) >= StreamNodeMaxEntries || (node.bytes > 0 && node.bytes+size > StreamNodeMaxBytes) {
+		node = &streamNode{master: id, masterFields: names}
+		s.nodes.Insert(id.Bytes(), node)
+	}
+
+	entry := streamNodeEntry{
+		msDelta:  int64(id.Ms - node.master.Ms),
+		seqDelta: int64(id.Seq - node.master.Seq),
+		values:   values,
+	}
+	if !equalStrings(names, node.masterFields) {
+		entry.fields = names
+	}
+	node.entries = append(node.entries, entry)
+	node.live++
+	node.bytes += size
+
+	if s.length == 0 {
+		s.firstID = id
+	}
+	s.length++
+	s.entriesAdded++
+	s.lastID = id
+}
+
+// Clone returns a deep copy of the stream, consumer groups included
+func (s *Stream) Clone() *Stream {
+	clone := &Stream{
+		length:       s.length,
+		lastID:       s.lastID,
+		firstID:      s.firstID,
+		maxDeletedID: s.maxDeletedID,
+		entriesAdded: s.entriesAdded,
+	}
+	// Field and value slices are never modified once added, only the
+	// entries themselves (deleted flag) need to be copied
+	s.nodes.Ascend(nil, func(key []byte, node *streamNode) bool {
+		copied := *node
+		copied.entries = append([]streamNodeEntry(nil), node.entries...)
+		clone.nodes.Insert(key, &copied)
+		return true
+	})
+	s.groups.Ascend(nil, func(key []byte, group *StreamGroup) bool {
+		clone.groups.Insert(key, group.clone())
+		return true
+	})
+	return clone
+}
+
+// Range returns up to count entries with IDs between start and end,
+// inclusive. A count <= 0 means no limit.
+func (s *Stream) Range(start, end StreamID, count int, reverse bool) []StreamEntry {
+	var entries []StreamEntry
+	if start.Compare(end) > 0 {
+		return entries
+	}
+
+	full := func() bool {
+		return count > 0 && len(entries) >= count
+	}
+
+	if reverse {
+		s.nodes.Descend(end.Bytes(), func(_ []byte, node *streamNode) bool {
+			for i := len(node.entries) - 1; i >= 0; i-- {
+				id := node.id(i)
+				if id.Compare(end) > 0 || node.entries[i].deleted {
+					continue
+				}
+				if id.Compare(start) < 0 {
+					return false
+				}
+				entries = append(entries, node.entry(i))
+				if full() {
+					return false
+				}
+			}
+			return true
+		})
+		return entries
+	}
+
+	s.nodes.Ascend(s.seekNode(start), func(_ []byte, node *streamNode) bool {
+		for i := range node.entries {
+			id := node.id(i)
+			if id.Compare(start) < 0 || node.entries[i].deleted {
+				continue
+			}
+			if id.Compare(end) > 0 {
+				return false
+			}
+			entries = append(entries, node.entry(i))
+			if full() {
+				return false
+			}
+		}
+		return true
+	})
+	return entries
+}
+
+// Get returns the entry with the given ID
+func (s *Stream) Get(id StreamID) (StreamEntry, bool) {
+	node := s.nodeFor(id)
+	if node == nil {
+		return StreamEntry{}, false
+	}
+	i, found := node.find(id)
+	if !found || node.entries[i].deleted {
+		return StreamEntry{}, false
+	}
+	return node.entry(i), true
+}
+
+// Delete removes the entry with the given ID and reports whether it existed
+func (s *Stream) Delete(id StreamID) bool {
+	node := s.nodeFor(id)
+	if node == nil {
+		return false
+	}
+	i, found := node.find(id)
+	if !found || node.entries[i].deleted {
+		return false
+	}
+
+	node.entries[i].deleted = true
+	node.live--
+	s.length--
+	if node.live == 0 {
+		s.nodes.Remove(node.master.Bytes())
+	}
+	if id.Compare(s.maxDeletedID) > 0 {
+		s.maxDeletedID = id
+	}
+	if id == s.firstID {
+		s.updateFirstID()
+	}
+	return true
+}
+
+// TrimByLength evicts the oldest entries until at most maxLen remain. With
+// approx set only whole nodes are evicted. A positive limit caps the number
+// of evicted entries. Returns the number of evicted entries.
+func (s *Stream) TrimByLength(maxLen uint64, approx bool, limit int64) int64 {
+	return s.trim(func(node *streamNode) bool {
+		return s.length-uint64(node.live) >= maxLen
+	}, func(id StreamID) bool {
+		return s.length > maxLen
+	}, approx, limit)
+}
+
+// TrimByMinID evicts entries with IDs lower than minID. The approx and limit
+// arguments behave as in TrimByLength.
+func (s *Stream) TrimByMinID(minID StreamID, approx bool, limit int64) int64 {
+	return s.trim(func(node *streamNode) bool {
+		return node.lastID().Compare(minID) < 0
+	}, func(id StreamID) bool {
+		return id.Compare(minID) < 0
+	}, approx, limit)
+}
+
+// trim evicts whole nodes while dropNode allows it and then, unless approx
+// is set, single entries while dropEntry allows it
+func (s *Stream) trim(dropNode func(*streamNode) bool, dropEntry func(StreamID) bool, approx bool, limit int64) int64 {
+	var removed int64
+	for s.length > 0 {
+		key, node, _ := s.nodes.First()
+		if dropNode(node) {
+			if limit > 0 && removed+int64(node.live) > limit {
+				break
+			}
+			s.nodes.Remove(key)
+			s.length -= uint64(node.live)
+			removed += int64(node.live)
+			continue
+		}
+		if approx {
+			break
+		}
+
+		for i := range node.entries {
+			if node.entries[i].deleted {
+				continue
+			}
+			if !dropEntry(node.id(i)) {
+				break
+			}
+			node.entries[i].deleted = true
+			node.live--
+			s.length--
+			removed++
+		}
+		if node.live == 0 {
+			s.nodes.Remove(key)
+		}
+		break
+	}
+
+	if removed > 0 {
+		s.updateFirstID()
+	}
+	return removed
+}
+
+// seekNode returns the key of the node that may contain id
+func (s *Stream) seekNode(id StreamID) []byte {
+	from := id.Bytes()
+	s.nodes.Descend(from, func(key []byte, _ *streamNode) bool {
+		from = key
+		return false
+	})
+	return from
+}
+
+// nodeFor returns the node that may contain id
+func (s *Stream) nodeFor(id StreamID) *streamNode {
+	var found *streamNode
+	s.nodes.Descend(id.Bytes(), func(_ []byte, node *streamNode) bool {
+		found = node
+		return false
+	})
+	return found
+}
+
+// updateFirstID recomputes the ID of the first live entry
+func (s *Stream) updateFirstID() {
+	s.firstID = StreamID{}
+	if entries := s.Range(StreamID{}, MaxStreamID, 1, false); len(entries) > 0 {
+		s.firstID = entries[0].ID
+	}
+}
+
+// equalStrings reports whether two string slices hold the same elements
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
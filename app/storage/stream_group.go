@@ -0,0 +1,258 @@
+package storage
+
+// StreamNACK is a pending entry: delivered to a consumer but not yet acknowledged
+type StreamNACK struct {
+	ID            StreamID
+	Consumer      *StreamConsumer
+	DeliveryTime  int64 // Unix time in milliseconds of the last delivery
+	DeliveryCount uint64
+}
+
+// StreamConsumer is a named consumer of a consumer group
+type StreamConsumer struct {
+	Name       string
+	SeenTime   int64 // Last time the consumer attempted an interaction
+	ActiveTime int64 // Last successful interaction, -1 if never
+	PEL        RadixTree[*StreamNACK]
+}
+
+// StreamGroup is a consumer group of a stream
+type StreamGroup struct {
+	Name        string
+	LastID      StreamID
+	EntriesRead int64 // Logical read counter, -1 when unknown
+	PEL         RadixTree[*StreamNACK]
+	consumers   RadixTree[*StreamConsumer]
+}
+
+// CreateGroup adds a consumer group; returns false if it already exists
+func (s *Stream) CreateGroup(name string, lastID StreamID, entriesRead int64) (*StreamGroup, bool) {
+	if _, exists := s.groups.Find([]byte(name)); exists {
+		return nil, false
+	}
+	group := &StreamGroup{Name: name, LastID: lastID, EntriesRead: entriesRead}
+	s.groups.Insert([]byte(name), group)
+	return group, true
+}
+
+// Group returns the consumer group with the given name, or nil
+func (s *Stream) Group(name string) *StreamGroup {
+	group, _ := s.groups.Find([]byte(name))
+	return group
+}
+
+// DestroyGroup removes a consumer group and reports whether it existed
+func (s *Stream) DestroyGroup(name string) bool {
+	return s.groups.Remove([]byte(name))
+}
+
+// Groups returns all consumer groups ordered by name
+func (s *Stream) Groups() []*StreamGroup {
+	groups := make([]*StreamGroup, 0, s.groups.Len())
+	s.groups.Ascend(nil, func(_ []byte, group *StreamGroup) bool {
+		groups = append(groups, group)
+		return true
+	})
+	return groups
+}
+
+// Contains reports whether an entry with the given ID exists
+func (s *Stream) Contains(id StreamID) bool {
+	_, exists := s.Get(id)
+	return exists
+}
+
+// ReadGroup delivers up to count entries newer than the group's last ID to
+// consumer, adding them to the pending entries list unless noAck is set
+func (s *Stream) ReadGroup(group *StreamGroup, consumer *StreamConsumer, count int, noAck bool, now int64) []StreamEntry {
+	start, ok := group.LastID.Incr()
+	if !ok {
+		return nil
+	}
+
+	entries := s.Range(start, MaxStreamID, count, false)
+	for _, entry := range entries {
+		s.advanceGroup(group, entry.ID)
+		if noAck {
+			continue
+		}
+
+		nack := group.NACK(entry.ID)
+		if nack == nil {
+			nack = &StreamNACK{ID: entry.ID}
+			group.PEL.Insert(entry.ID.Bytes(), nack)
+		}
+		group.Assign(nack, consumer)
+		nack.DeliveryTime = now
+		nack.DeliveryCount = 1
+	}
+	if len(entries) > 0 {
+		consumer.ActiveTime = now
+	}
+	return entries
+}
+
+// advanceGroup moves the group's last delivered ID forward to id, keeping
+// the logical entries-read counter up to date when possible
+func (s *Stream) advanceGroup(group *StreamGroup, id StreamID) {
+	if id.Compare(group.LastID) <= 0 {
+		return
+	}
+	if group.EntriesRead != -1 && !s.rangeHasTombstones(id) {
+		group.EntriesRead++
+	} else if s.entriesAdded > 0 {
+		group.EntriesRead = s.EstimateEntriesRead(id)
+	}
+	group.LastID = id
+}
+
+// EstimateEntriesRead estimates how many entries were added to the stream
+// up to and including id. Returns -1 when it cannot be determined because
+// of deleted entries.
+func (s *Stream) EstimateEntriesRead(id StreamID) int64 {
+	if s.entriesAdded == 0 {
+		return 0
+	}
+	if s.length == 0 && id.Compare(s.lastID) < 1 {
+		return int64(s.entriesAdded)
+	}
+
+	cmpLast := id.Compare(s.lastID)
+	if cmpLast == 0 {
+		return int64(s.entriesAdded)
+	} else if cmpLast > 0 {
+		return -1
+	}
+
+	cmpFirst := id.Compare(s.firstID)
+	if s.maxDeletedID.IsZero() || s.maxDeletedID.Compare(s.firstID) < 0 {
+		// There are no tombstones ahead of the first entry
+		if cmpFirst < 0 {
+			return int64(s.entriesAdded - s.length)
+		} else if cmpFirst == 0 {
+			return int64(s.entriesAdded - s.length + 1)
+		}
+	}
+	return -1
+}
+
+// Lag returns the number of entries not yet delivered to the group, or
+// false when it cannot be computed
+func (s *Stream) Lag(group *StreamGroup) (int64, bool) {
+	if s.entriesAdded == 0 {
+		return 0, true
+	}
+	if group.EntriesRead != -1 && !s.rangeHasTombstones(group.LastID) {
+		return int64(s.entriesAdded) - group.EntriesRead, true
+	}
+	if entriesRead := s.EstimateEntriesRead(group.LastID); entriesRead != -1 {
+		return int64(s.entriesAdded) - entriesRead, true
+	}
+	return 0, false
+}
+
+// rangeHasTombstones reports whether entries with IDs >= start were deleted
+func (s *Stream) rangeHasTombstones(start StreamID) bool {
+	if s.length == 0 || s.maxDeletedID.IsZero() {
+		return false
+	}
+	return start.Compare(s.maxDeletedID) <= 0
+}
+
+// Consumer returns the consumer with the given name, or nil
+func (g *StreamGroup) Consumer(name string) *StreamConsumer {
+	consumer, _ := g.consumers.Find([]byte(name))
+	return consumer
+}
+
+// CreateConsumer adds a consumer; returns the existing one and false if it
+// is already present
+func (g *StreamGroup) CreateConsumer(name string, now int64) (*StreamConsumer, bool) {
+	if consumer := g.Consumer(name); consumer != nil {
+		return consumer, false
+	}
+	consumer := &StreamConsumer{Name: name, SeenTime: now, ActiveTime: -1}
+	g.consumers.Insert([]byte(name), consumer)
+	return consumer, true
+}
+
+// DeleteConsumer removes a consumer and its pending entries. Returns the
+// number of pending entries that were dropped and whether it existed.
+func (g *StreamGroup) DeleteConsumer(name string) (int, bool) {
+	consumer := g.Consumer(name)
+	if consumer == nil {
+		return 0, false
+	}
+	pending := consumer.PEL.Len()
+	consumer.PEL.Ascend(nil, func(key []byte, _ *StreamNACK) bool {
+		g.PEL.Remove(key)
+		return true
+	})
+	g.consumers.Remove([]byte(name))
+	return pending, true
+}
+
+// Consumers returns all consumers ordered by name
+func (g *StreamGroup) Consumers() []*StreamConsumer {
+	consumers := make([]*StreamConsumer, 0, g.consumers.Len())
+	g.consumers.Ascend(nil, func(_ []byte, consumer *StreamConsumer) bool {
+		consumers = append(consumers, consumer)
+		return true
+	})
+	return consumers
+}
+
+// NACK returns the pending entry with the given ID, or nil
+func (g *StreamGroup) NACK(id StreamID) *StreamNACK {
+	nack, _ := g.PEL.Find(id.Bytes())
+	return nack
+}
+
+// Assign moves a pending entry to the PEL of consumer
+func (g *StreamGroup) Assign(nack *StreamNACK, consumer *StreamConsumer) {
+	if nack.Consumer == consumer {
+		return
+	}
+	key := nack.ID.Bytes()
+	if nack.Consumer != nil {
+		nack.Consumer.PEL.Remove(key)
+	}
+	consumer.PEL.Insert(key, nack)
+	nack.Consumer = consumer
+}
+
+// Ack removes an entry from the pending entries list
+func (g *StreamGroup) Ack(id StreamID) bool {
+	nack := g.NACK(id)
+	if nack == nil {
+		return false
+	}
+	key := id.Bytes()
+	g.PEL.Remove(key)
+	if nack.Consumer != nil {
+		nack.Consumer.PEL.Remove(key)
+	}
+	return true
+}
+
+// clone returns a deep copy of the group with its consumers and PEL
+func (g *StreamGroup) clone() *StreamGroup {
+	clone := &StreamGroup{Name: g.Name, LastID: g.LastID, EntriesRead: g.EntriesRead}
+	consumers := make(map[*StreamConsumer]*StreamConsumer)
+	g.consumers.Ascend(nil, func(key []byte, consumer *StreamConsumer) bool {
+		copied := &StreamConsumer{Name: consumer.Name, SeenTime: consumer.SeenTime, ActiveTime: consumer.ActiveTime}
+		consumers[consumer] = copied
+		clone.consumers.Insert(key, copied)
+		return true
+	})
+	g.PEL.Ascend(nil, func(key []byte, nack *StreamNACK) bool {
+		copied := *nack
+		clone.PEL.Insert(key, &copied)
+		if nack.Consumer != nil {
+			copied.Consumer = consumers[nack.Consumer]
+			copied.Consumer.PEL.Insert(key, &copied)
+		}
+		return true
+	})
+	return clone
+}
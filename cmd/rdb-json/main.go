@@ -0,0 +1,138 @@
+// Command rdb-json converts RDB files to JSON lines and back, using the
+// server's own RDB decoder and encoder. Each line holds one key in the
+// format described by rdb.MarshalJSON. With an encryption key, given by
+// --key-file or else in the REDIS_ENCRYPTION_KEY environment variable,
+// encrypted RDB files are decrypted on export and written on import.
+//
+// Usage:
+//
+//	rdb-json [--key-file <file>] export <file.rdb>                 writes JSON lines to stdout
+//	rdb-json [--key-file <file>] import <file.jsonl> <file.rdb>    writes an RDB file
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/codecrafters-io/redis-starter-go/app/crypt"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+)
+
+func main() {
+	args := os.Args[1:]
+	keyFile := ""
+	if len(args) > 2 && args[0] == "--key-file" {
+		keyFile = args[1]
+		args = args[2:]
+	}
+	key, err := crypt.ConfiguredKey(keyFile)
+	if err == nil {
+		switch {
+		case len(args) == 2 && args[0] == "export":
+			err = export(args[1], os.Stdout, key)
+		case len(args) == 3 && args[0] == "import":
+			err = importFile(args[1], args[2], key)
+		default:
+			fmt.Fprintf(os.Stderr, "Usage:\n  %[1]s [--key-file <file>] export <file.rdb>\n  %[1]s [--key-file <file>] import <file.jsonl> <file.rdb>\n", os.Args[0])
+			os.Exit(1)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+}
+
+// export writes every key of the RDB file at path as a line of JSON
+func export(path string, w io.Writer, key []byte) error {
+	file, err := crypt.Open(path, key)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	out := bufio.NewWriter(w)
+	decoder := rdb.NewDecoder(file)
+	for {
+		entry, err := decoder.Next()
+		if err == io.EOF {
+			return out.Flush()
+		}
+		if err != nil {
+			return err
+		}
+		line, err := rdb.MarshalJSON(entry)
+		if err != nil {
+			return fmt.Errorf("key %q at offset %d: %w", entry.Key, entry.Offset, err)
+		}
+		out.Write(line)
+		out.WriteByte('\n')
+	}
+}
+
+// importFile writes the keys of the JSON lines file at path to an RDB file,
+// encrypted when key is not nil. The file is replaced atomically, so a
+// failed import leaves any previous one untouched.
+func importFile(path, rdbPath string, key []byte) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	return rdb.WriteFile(rdbPath, func(out io.Writer) error {
+		return writeImport(in, out, key)
+	})
+}
+
+// writeImport writes the keys of the JSON lines read from in as an RDB
+// file to out, encrypted when key is not nil
+func writeImport(in io.Reader, out io.Writer, key []byte) error {
+	w := out
+	var encrypted *crypt.Writer
+	if key != nil {
+		var err error
+		if encrypted, err = crypt.NewWriter(out, key); err != nil {
+			return err
+		}
+		w = encrypted
+	}
+	encoder := rdb.NewEncoder(w)
+	if err := encoder.WriteHeader(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	db := -1
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		entry, err := rdb.UnmarshalJSON(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if entry.DB != db {
+			db = entry.DB
+			if err := encoder.WriteDB(db, 0, 0); err != nil {
+				return err
+			}
+		}
+		if err := encoder.WriteEntry(entry.Key, entry.Value, entry.ExpireAt); err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	if encrypted != nil {
+		return encrypted.Close()
+	}
+	return nil
+}
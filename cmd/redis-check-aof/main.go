@@ -0,0 +1,109 @@
+// Command redis-check-aof verifies an append-only file with the server's
+// own reader and reports the offset and cause of the first problem found.
+// It accepts a single file or the manifest of a multi-part log, whose files
+// are checked in load order. With --fix a log cut short in the middle of a
+// command is truncated to its last complete command. Encrypted files are
+// decrypted with the key in the file given by --key-file, or else in the
+// REDIS_ENCRYPTION_KEY environment variable.
+//
+// Usage: redis-check-aof [--fix] [--key-file <file>] <file.aof|file.manifest>
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/aof"
+	"github.com/codecrafters-io/redis-starter-go/app/crypt"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+)
+
+func main() {
+	fix := false
+	keyFile := ""
+	args := os.Args[1:]
+	for len(args) > 1 {
+		if args[0] == "--fix" {
+			fix = true
+			args = args[1:]
+		} else if args[0] == "--key-file" && len(args) > 2 {
+			keyFile = args[1]
+			args = args[2:]
+		} else {
+			break
+		}
+	}
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [--fix] [--key-file <file>] <file.aof|file.manifest>\n", os.Args[0])
+		os.Exit(1)
+	}
+	key, err := crypt.ConfiguredKey(keyFile)
+	if err != nil {
+		fmt.Printf("Cannot load the encryption key: %v\n", err)
+		os.Exit(1)
+	}
+
+	path := args[0]
+	files := []aof.ManifestFile{{Name: filepath.Base(path), Type: aof.TypeBase}}
+	dir := filepath.Dir(path)
+	if strings.HasSuffix(path, ".manifest") {
+		fmt.Printf("Start checking Multi Part AOF\n")
+		manifest, err := aof.ReadManifest(path)
+		if err != nil {
+			fmt.Printf("Invalid manifest %s: %v\n", path, err)
+			os.Exit(1)
+		}
+		files = manifest.Files()
+	}
+
+	for i, f := range files {
+		if !check(filepath.Join(dir, f.Name), f.Type == aof.TypeBase, fix && i == len(files)-1, key) {
+			os.Exit(1)
+		}
+	}
+	fmt.Println("AOF is valid")
+}
+
+// check verifies one file and reports whether it is valid, possibly after
+// fixing it
+func check(path string, base bool, fix bool, key []byte) bool {
+	fmt.Printf("Checking %s\n", path)
+	stats, err := aof.CheckFile(path, base, key)
+	if err == nil {
+		fmt.Printf("AOF analyzed: filename=%s, size=%d, keys=%d, commands=%d\n", filepath.Base(path), stats.Size, stats.Keys, stats.Commands)
+		return true
+	}
+
+	var rdbErr *rdb.Error
+	if errors.As(err, &rdbErr) {
+		fmt.Printf("RDB preamble is invalid: %v at offset %d\n", rdbErr.Err, rdbErr.Offset)
+		return false
+	}
+	var aofErr *aof.Error
+	if !errors.As(err, &aofErr) {
+		fmt.Printf("Cannot check %s: %v\n", path, err)
+		return false
+	}
+
+	fmt.Printf("%v at offset %d\n", aofErr.Err, aofErr.Offset)
+	fmt.Printf("AOF analyzed: filename=%s, size=%d, ok_up_to=%d, commands=%d, diff=%d\n",
+		filepath.Base(path), stats.Size, aofErr.Offset, stats.Commands, stats.Size-aofErr.Offset)
+	if !errors.Is(err, aof.ErrTruncated) {
+		fmt.Println("The file is corrupted, not just truncated: fix it by hand, truncating at the offset above would drop the rest of it")
+		return false
+	}
+	if !fix {
+		fmt.Println("AOF is not valid. Use the --fix option to try fixing it.")
+		return false
+	}
+
+	if err := os.Truncate(path, aofErr.Offset); err != nil {
+		fmt.Printf("Failed to truncate AOF: %v\n", err)
+		return false
+	}
+	fmt.Printf("Successfully truncated AOF %s, %d bytes discarded\n", filepath.Base(path), stats.Size-aofErr.Offset)
+	return true
+}
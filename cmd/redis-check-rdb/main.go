@@ -0,0 +1,136 @@
+// Command redis-check-rdb verifies an RDB file with the server's own
+// decoder and reports the offset and cause of the first problem found.
+// Encrypted files are decrypted with the key in the file given by
+// --key-file, or else in the REDIS_ENCRYPTION_KEY environment variable;
+// offsets are then offsets in the decrypted data.
+//
+// Usage: redis-check-rdb [--key-file <file>] <file.rdb>
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/crypt"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+)
+
+func main() {
+	args := os.Args[1:]
+	keyFile := ""
+	if len(args) == 3 && args[0] == "--key-file" {
+		keyFile = args[1]
+		args = args[2:]
+	}
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [--key-file <file>] <rdb-file-name>\n", os.Args[0])
+		os.Exit(1)
+	}
+	key, err := crypt.ConfiguredKey(keyFile)
+	if err != nil {
+		fmt.Printf("Cannot load the encryption key: %v\n", err)
+		os.Exit(1)
+	}
+	if !check(args[0], key) {
+		os.Exit(1)
+	}
+}
+
+// check prints a report about the file at path and reports whether it is valid
+func check(path string, key []byte) bool {
+	fmt.Printf("[offset 0] Checking RDB file %s\n", path)
+	file, err := crypt.Open(path, key)
+	if err != nil {
+		fmt.Printf("Cannot open %s: %v\n", path, err)
+		return false
+	}
+	defer file.Close()
+	if file.Encrypted() {
+		fmt.Println("[info] The file is encrypted, offsets are in the decrypted data")
+	}
+
+	decoder := rdb.NewDecoder(bufio.NewReader(file))
+	now := time.Now().UnixMilli()
+	var keys, expires, expired int
+	types := make(map[string]int)
+	var last *rdb.Entry
+	for {
+		entry, err := decoder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Println("--- RDB ERROR DETECTED ---")
+			var rdbErr *rdb.Error
+			if errors.As(err, &rdbErr) {
+				fmt.Printf("[offset %d] %v\n", rdbErr.Offset, rdbErr.Err)
+			} else {
+				fmt.Printf("[offset %d] %v\n", decoder.Offset(), err)
+			}
+			if last != nil {
+				fmt.Printf("[additional info] Last key read: '%s' at offset %d\n", last.Key, last.Offset)
+			}
+			fmt.Printf("[info] %d keys read\n", keys)
+			return false
+		}
+
+		keys++
+		types[typeName(entry.Type)]++
+		if entry.ExpireAt != 0 {
+			expires++
+			if entry.ExpireAt < now {
+				expired++
+			}
+		}
+		last = entry
+	}
+
+	auxKeys := make([]string, 0, len(decoder.Aux))
+	for key := range decoder.Aux {
+		auxKeys = append(auxKeys, key)
+	}
+	sort.Strings(auxKeys)
+	for _, key := range auxKeys {
+		fmt.Printf("[info] AUX field %s = '%s'\n", key, decoder.Aux[key])
+	}
+
+	fmt.Printf("[info] RDB version %d\n", decoder.Version())
+	fmt.Printf("[offset %d] Checksum OK\n", decoder.Offset())
+	fmt.Printf("[info] %d keys read\n", keys)
+	fmt.Printf("[info] %d expires\n", expires)
+	fmt.Printf("[info] %d already expired\n", expired)
+	typeNames := make([]string, 0, len(types))
+	for name := range types {
+		typeNames = append(typeNames, name)
+	}
+	sort.Strings(typeNames)
+	for _, name := range typeNames {
+		fmt.Printf("[info] %d keys of type %s\n", types[name], name)
+	}
+	fmt.Println("RDB looks OK!")
+	return true
+}
+
+// typeName describes an RDB object type
+func typeName(objType byte) string {
+	switch objType {
+	case rdb.TypeString:
+		return "string"
+	case rdb.TypeList, rdb.TypeListZiplist, rdb.TypeListQuicklist, rdb.TypeListQuicklist2:
+		return "list"
+	case rdb.TypeSet, rdb.TypeSetIntset, rdb.TypeSetListpack:
+		return "set"
+	case rdb.TypeZSet, rdb.TypeZSet2, rdb.TypeZSetZiplist, rdb.TypeZSetListpack:
+		return "zset"
+	case rdb.TypeHash, rdb.TypeHashZipmap, rdb.TypeHashZiplist, rdb.TypeHashListpack:
+		return "hash"
+	case rdb.TypeStreamListpacks, rdb.TypeStreamListpacks2, rdb.TypeStreamListpacks3:
+		return "stream"
+	}
+	return fmt.Sprintf("%d", objType)
+}